@@ -0,0 +1,335 @@
+package scm
+
+// The analyzing evaluator. An expression is analyzed once into a tree
+// of nodes, as the evaluator of SICP 4.1.7 separates the syntactic
+// analysis from the execution: the derived expressions are transformed,
+// the parts of every form are selected and the defines of the bodies
+// are scanned out. A node is executed by the label stored in it, the
+// machine does not look at the list structure of an analyzed
+// expression again however many times it runs. The labels of the nodes
+// share the labels of the explicit-control evaluator that only deal
+// with values, like the evaluation of the operands or apply_dispatch,
+// so continuations, dynamic-wind and exceptions work the same way.
+//
+// A macro is only known when the operator of a combination is
+// evaluated, so a macro use is analyzed as a combination, and an operand
+// that is not an expression is left unanalyzed. Its expansion
+// is analyzed the first time the combination is evaluated and kept for
+// the next times, as the expansion of a form registered with
+// DefineSpecialForm is. The forms that need the environment while they
+// are analyzed, define-syntax, let-syntax and letrec-syntax, are left to
+// the explicit-control evaluator, which examines them and their bodies
+// every time they are evaluated.
+
+// Analysis is an analyzed expression
+type Analysis struct {
+	// the expression before it was analyzed
+	exp *Value
+	// the label that executes the node
+	execute *Value
+	node    interface{}
+}
+
+type analyzedConstant struct {
+	value *Value
+}
+
+type analyzedVariable struct {
+	variable *Value
+}
+
+// an assignment or a definition
+type analyzedAssignment struct {
+	variable *Value
+	value    *Value
+}
+
+type analyzedIf struct {
+	predicate   *Value
+	consequent  *Value
+	alternative *Value
+}
+
+type analyzedLambda struct {
+	parameters *Value
+	// a list of analyzed expressions
+	body *Value
+}
+
+// a sequence, an and or an or
+type analyzedSequence struct {
+	exps *Value
+}
+
+type analyzedApplication struct {
+	operator *Value
+	operands *Value
+}
+
+func (in *Interpreter) make_analysis(exp *Value, execute func(), node interface{}) *Value {
+	return &Value{
+		kind: Analyzed,
+		val: &Analysis{
+			exp:     exp,
+			execute: label(execute),
+			node:    node,
+		},
+		pos: exp.pos,
+	}
+}
+
+func is_analyzed(exp *Value) bool {
+	return exp.kind == Analyzed
+}
+
+func analysis_of(exp *Value) *Analysis {
+	return exp.val.(*Analysis)
+}
+
+func analyzed_expression(exp *Value) *Value {
+	return analysis_of(exp).exp
+}
+
+func (in *Interpreter) analyze(exp *Value) *Value {
+	if test(is_self_evaluating(exp)) {
+		return in.make_analysis(exp, in.ex_constant, &analyzedConstant{exp})
+	}
+	if test(is_variable(exp)) {
+		return in.make_analysis(exp, in.ex_variable, &analyzedVariable{exp})
+	}
+	if in.special_form(exp) != nil {
+		return in.analyze_special_form(exp)
+	}
+	if test(is_application(exp)) {
+		return in.analyze_application(exp)
+	}
+	// unknown_expression_type is signaled when it runs
+	return in.analyze_source(exp)
+}
+
+func (in *Interpreter) analyze_special_form(exp *Value) *Value {
+	switch original_name(car(exp)).val.(string) {
+	case "quote":
+		return in.make_analysis(exp, in.ex_constant, &analyzedConstant{text_of_quotation(exp)})
+	case "set!":
+		return in.make_analysis(exp, in.ex_assignment, &analyzedAssignment{
+			variable: assignment_variable(exp),
+			value:    in.analyze(assignment_value(exp)),
+		})
+	case "define":
+		return in.make_analysis(exp, in.ex_definition, &analyzedAssignment{
+			variable: definition_variable(exp),
+			value:    in.analyze(definition_value(exp)),
+		})
+	case "if":
+		return in.make_analysis(exp, in.ex_if, &analyzedIf{
+			predicate:   in.analyze(if_predicate(exp)),
+			consequent:  in.analyze(if_consequent(exp)),
+			alternative: in.analyze(if_alternative(exp)),
+		})
+	case "lambda":
+		return in.make_analysis(exp, in.ex_lambda, &analyzedLambda{
+			parameters: lambda_parameters(exp),
+			body:       _map(in.analyze, scan_out_defines(lambda_body(exp))),
+		})
+	case "begin":
+		if isNull(begin_actions(exp)) {
+			return in.analyze_source(exp)
+		}
+		return in.make_analysis(exp, in.ex_sequence, &analyzedSequence{_map(in.analyze, begin_actions(exp))})
+	case "and":
+		return in.make_analysis(exp, in.ex_and, &analyzedSequence{_map(in.analyze, logical_operands(exp))})
+	case "or":
+		return in.make_analysis(exp, in.ex_or, &analyzedSequence{_map(in.analyze, logical_operands(exp))})
+	case "quasiquote":
+		return in.analyze_derived(exp, quasiquote_to_combination(exp))
+	case "let":
+		return in.analyze_derived(exp, let_to_combination(exp))
+	case "let*":
+		return in.analyze_derived(exp, let_star_to_nested_lets(exp))
+	case "letrec", "letrec*":
+		return in.analyze_derived(exp, letrec_to_let(exp))
+	case "cond":
+		return in.analyze_derived(exp, cond_to_if(exp))
+	case "when":
+		return in.analyze_derived(exp, when_to_if(exp))
+	case "unless":
+		return in.analyze_derived(exp, unless_to_if(exp))
+	case "case":
+		return in.analyze_derived(exp, case_to_cond(exp))
+	case "do":
+		return in.analyze_derived(exp, do_to_named_let(exp))
+	case "define-macro":
+		return in.analyze_derived(exp, define_macro_to_definition(exp))
+	case "defmacro":
+		return in.analyze_derived(exp, defmacro_to_definition(exp))
+	case "guard":
+		return in.analyze_derived(exp, guard_to_combination(
+			exp,
+			in.machine_procedure("call/cc"),
+			in.machine_procedure("with-exception-handler"),
+			in.machine_procedure("raise-continuable")))
+	}
+	return in.analyze_source(exp)
+}
+
+// a derived expression is analyzed as the expression it is transformed
+// into, the node keeps the original expression
+func (in *Interpreter) analyze_derived(exp *Value, transformed *Value) *Value {
+	analysis := in.analyze(transformed)
+	analysis_of(analysis).exp = exp
+	analysis.pos = exp.pos
+	return analysis
+}
+
+// the operands are analyzed too even when the operator turns out to be
+// a macro, the macro gets the operands as they were written
+func (in *Interpreter) analyze_application(exp *Value) *Value {
+	return in.make_analysis(exp, in.ex_application, &analyzedApplication{
+		operator: in.analyze(operator(exp)),
+		operands: _map(in.analyze_operand, operands(exp)),
+	})
+}
+
+// an operand of a macro need not be an expression, like the (if) of
+// (quote-it (if)). An operand that cannot be analyzed is left to the
+// explicit-control evaluator, which signals the error only if the
+// operand is evaluated
+func (in *Interpreter) analyze_operand(exp *Value) (analysis *Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*SchemeError); !ok {
+				panic(r)
+			}
+			analysis = in.analyze_source(exp)
+		}
+	}()
+	return in.analyze(exp)
+}
+
+// an expression that is evaluated by the explicit-control evaluator
+func (in *Interpreter) analyze_source(exp *Value) *Value {
+	return in.make_analysis(exp, in.ex_source, nil)
+}
+
+// analyze the expression in exp and execute it
+func (in *Interpreter) ev_analyze() {
+	assign(in.exp, in.analyze(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+// the label that evaluates a new expression, it is
+// analyzed first when the analyzing evaluator is used
+func (in *Interpreter) eval_entry() *Value {
+	if in.analyzing {
+		return label(in.ev_analyze)
+	}
+	return label(in.eval_dispatch)
+}
+
+// the labels of the analyzed expressions, exp holds the node
+func (in *Interpreter) ex_constant() {
+	assign(in.val, analysis_of(reg(in.exp)).node.(*analyzedConstant).value)
+	in.go_to(reg(in.cont))
+}
+
+func (in *Interpreter) ex_variable() {
+	variable := analysis_of(reg(in.exp)).node.(*analyzedVariable).variable
+	assign(in.val, lookup_variable_value(variable, reg(in.env)))
+	in.go_to(reg(in.cont))
+}
+
+func (in *Interpreter) ex_assignment() {
+	node := analysis_of(reg(in.exp)).node.(*analyzedAssignment)
+	assign(in.unev, node.variable)
+	in.save(in.unev)
+	assign(in.exp, node.value)
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ev_assignment_1))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ex_definition() {
+	node := analysis_of(reg(in.exp)).node.(*analyzedAssignment)
+	assign(in.unev, node.variable)
+	in.save(in.unev)
+	assign(in.exp, node.value)
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ev_definition_1))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ex_if() {
+	in.save(in.exp)
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ex_if_decide))
+	assign(in.exp, analysis_of(reg(in.exp)).node.(*analyzedIf).predicate)
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ex_if_decide() {
+	in.restore(in.cont)
+	in.restore(in.env)
+	in.restore(in.exp)
+	node := analysis_of(reg(in.exp)).node.(*analyzedIf)
+	if test(is_true(reg(in.val))) {
+		assign(in.exp, node.consequent)
+	} else {
+		assign(in.exp, node.alternative)
+	}
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ex_lambda() {
+	node := analysis_of(reg(in.exp)).node.(*analyzedLambda)
+	assign(in.val, make_scanned_procedure(node.parameters, node.body, reg(in.env)))
+	in.go_to(reg(in.cont))
+}
+
+func (in *Interpreter) ex_sequence() {
+	assign(in.unev, analysis_of(reg(in.exp)).node.(*analyzedSequence).exps)
+	in.save(in.cont)
+	in.go_to(label(in.ev_sequence))
+}
+
+func (in *Interpreter) ex_and() {
+	assign(in.unev, analysis_of(reg(in.exp)).node.(*analyzedSequence).exps)
+	if test(has_no_operands(reg(in.unev))) {
+		assign(in.val, make_true())
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.save(in.cont)
+	in.go_to(label(in.ev_and_loop))
+}
+
+func (in *Interpreter) ex_or() {
+	assign(in.unev, analysis_of(reg(in.exp)).node.(*analyzedSequence).exps)
+	if test(has_no_operands(reg(in.unev))) {
+		assign(in.val, make_false())
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.save(in.cont)
+	in.go_to(label(in.ev_or_loop))
+}
+
+func (in *Interpreter) ex_application() {
+	node := analysis_of(reg(in.exp)).node.(*analyzedApplication)
+	in.save(in.cont)
+	in.save(in.exp)
+	in.save(in.env)
+	assign(in.unev, node.operands)
+	in.save(in.unev)
+	assign(in.exp, node.operator)
+	assign(in.cont, label(in.ev_appl_did_operator))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ex_source() {
+	assign(in.exp, analyzed_expression(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
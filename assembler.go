@@ -0,0 +1,450 @@
+package scm
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// The assembler of SICP 5.2, every instruction of a controller text
+// becomes a label of the machine that executes it and goes to the label
+// of the next one. The operands of an instruction are resolved when it
+// is assembled, as the execution procedures of SICP are. The assembler
+// serves the machines made with make-machine and the compiled code,
+// which runs on the machine of the evaluator, in the same loop as its
+// labels: compiled code jumps to the labels of the evaluator and the
+// evaluator jumps to the entries of the compiled procedures.
+
+// the parts of a register machine that its instructions use
+type machineModel struct {
+	register   func(name *Value) *Register
+	operations map[string]func(args []*Value) *Value
+	// the labels outside of the controller text
+	labels map[string]*Value
+	// where the machine goes after the last instruction
+	end     *Value
+	flag    *Register
+	go_to   func(label *Value)
+	save    func(r *Register)
+	restore func(r *Register)
+	// called with the expressions of compiled code that an
+	// instruction starts evaluating and the last of their positions
+	evaluated func(exp *Value, where *Position)
+	// called with the combination whose procedure an instruction
+	// starts to apply
+	called func(exp *Value)
+	// counts the instructions executed by label
+	stats *statistics
+}
+
+// the machine of the evaluator, as compiled code sees it
+func (in *Interpreter) machine_model() *machineModel {
+	return &machineModel{
+		register:   in.machine_register,
+		operations: in.machine_operations(),
+		labels: map[string]*Value{
+			"apply-dispatch": label(in.apply_dispatch),
+			"eval-dispatch":  in.eval_entry(),
+			"ev-macro":       label(in.ev_macro),
+		},
+		end:     label(in.done),
+		stats:   in.stats,
+		flag:    in.flag,
+		go_to:   in.go_to,
+		save:    in.save,
+		restore: in.restore,
+		evaluated: func(exp *Value, where *Position) {
+			assign(in.exp, exp)
+			if where != nil {
+				in.where = where
+			}
+		},
+		called: func(exp *Value) {
+			in.combination = exp
+		},
+	}
+}
+
+// the operations of compiled code
+func (in *Interpreter) machine_operations() map[string]func(args []*Value) *Value {
+	return map[string]func(args []*Value) *Value{
+		"lookup-variable-value": func(args []*Value) *Value {
+			return lookup_variable_value(args[0], args[1])
+		},
+		"set-variable-value!": func(args []*Value) *Value {
+			set_variable_value(args[0], args[1], args[2])
+			return nil
+		},
+		"define-variable!": func(args []*Value) *Value {
+			define_variable(args[0], args[1], args[2])
+			return nil
+		},
+		"make-compiled-procedure": func(args []*Value) *Value {
+			return make_compiled_procedure(args[0], args[1])
+		},
+		"compiled-procedure-entry": func(args []*Value) *Value {
+			return compiled_procedure_entry(args[0])
+		},
+		"compiled-procedure-env": func(args []*Value) *Value {
+			return compiled_procedure_env(args[0])
+		},
+		"extend-environment": func(args []*Value) *Value {
+			in.in_call = true
+			env := extend_environment(args[0], args[1], args[2])
+			in.in_call = false
+			return env
+		},
+		"list": func(args []*Value) *Value {
+			return list(args...)
+		},
+		"adjoin-arg": func(args []*Value) *Value {
+			return adjoin_arg(args[0], args[1])
+		},
+		"false?": func(args []*Value) *Value {
+			return is_false(args[0])
+		},
+		"true?": func(args []*Value) *Value {
+			return is_true(args[0])
+		},
+		"primitive-procedure?": func(args []*Value) *Value {
+			return is_primitive_procedure(args[0])
+		},
+		"compiled-procedure?": func(args []*Value) *Value {
+			// traced procedures are applied by apply-dispatch,
+			// which traces them
+			if in.is_traced(args[0]) {
+				return make_false()
+			}
+			return is_compiled_procedure(args[0])
+		},
+		"macro?": func(args []*Value) *Value {
+			return is_macro(args[0])
+		},
+		"eq?": func(args []*Value) *Value {
+			if args[0] == args[1] {
+				return make_true()
+			}
+			return make_false()
+		},
+		"apply-primitive-procedure": func(args []*Value) *Value {
+			in.in_primitive = true
+			v := apply_primitive_procedure(args[0], args[1])
+			in.in_primitive = false
+			return v
+		},
+	}
+}
+
+func (in *Interpreter) machine_register(name *Value) *Register {
+	switch name.val.(string) {
+	case "exp":
+		return in.exp
+	case "env":
+		return in.env
+	case "val":
+		return in.val
+	case "cont":
+		return in.cont
+	case "proc":
+		return in.proc
+	case "argl":
+		return in.argl
+	case "unev":
+		return in.unev
+	}
+	panic(fmt.Sprintf("unknown register %s", name))
+}
+
+// the statements of a controller text, labels are names and
+// instructions are lists
+func controller_statements(text *Value) []statement {
+	var statements []statement
+	for ; isPair(text); text = cdr(text) {
+		if !isName(car(text)) && !isPair(car(text)) {
+			raise_error(SyntaxError, "not a label or an instruction", car(text))
+		}
+		statements = append(statements, statement{text: car(text)})
+	}
+	return statements
+}
+
+// the label of the first instruction of statements
+func assemble(statements []statement, model *machineModel) *Value {
+	a := &assembler{
+		model:  model,
+		labels: map[*Value]int{},
+	}
+	var instructions []statement
+	// the label that precedes each instruction
+	var blocks []string
+	block := "start"
+	for _, s := range statements {
+		if isName(s.text) {
+			if _, ok := a.labels[s.text]; ok {
+				raise_error(SyntaxError, "Multiply-defined label", s.text)
+			}
+			a.labels[s.text] = len(instructions)
+			block = s.text.val.(string)
+			continue
+		}
+		instructions = append(instructions, s)
+		blocks = append(blocks, block)
+	}
+
+	a.entries = make([]*Value, len(instructions)+1)
+	a.entries[len(instructions)] = model.end
+	for i, s := range instructions {
+		a.entries[i] = label(a.counted(blocks[i], a.execution_procedure(s, i+1)))
+	}
+	return a.entries[0]
+}
+
+// count the instruction under the label of its block while the
+// statistics are counting
+func (a *assembler) counted(block string, run func()) func() {
+	stats := a.model.stats
+	if stats == nil {
+		return run
+	}
+	return func() {
+		if stats.counting {
+			stats.executed(block)
+		}
+		run()
+	}
+}
+
+type assembler struct {
+	model  *machineModel
+	labels map[*Value]int
+	// the label of every instruction
+	entries []*Value
+}
+
+// the label named name, in the controller text or outside of it
+func (a *assembler) label(name *Value) func() *Value {
+	if i, ok := a.labels[name]; ok {
+		return func() *Value {
+			return a.entries[i]
+		}
+	}
+	if l, ok := a.model.labels[name.val.(string)]; ok {
+		return func() *Value {
+			return l
+		}
+	}
+	raise_error(SyntaxError, "Unknown label", name)
+	return nil
+}
+
+func (a *assembler) execution_procedure(s statement, next int) func() {
+	m := a.model
+	inst := s.text
+	var run func()
+
+	if !isName(car(inst)) {
+		raise_error(SyntaxError, "Unknown instruction type", inst)
+	}
+	switch car(inst).val.(string) {
+	case "assign":
+		target := m.register(cadr(inst))
+		value := a.value(cddr(inst))
+		run = func() {
+			assign(target, value())
+			m.go_to(a.entries[next])
+		}
+	case "perform":
+		action := a.value(cdr(inst))
+		run = func() {
+			action()
+			m.go_to(a.entries[next])
+		}
+	case "test":
+		condition := a.value(cdr(inst))
+		run = func() {
+			assign(m.flag, condition())
+			m.go_to(a.entries[next])
+		}
+	case "branch":
+		destination := a.label(cadr(cadr(inst)))
+		run = func() {
+			if isTrue(reg(m.flag)) {
+				m.go_to(destination())
+				return
+			}
+			m.go_to(a.entries[next])
+		}
+	case "goto":
+		destination := a.operand(cadr(inst))
+		run = func() {
+			m.go_to(destination())
+		}
+	case "save":
+		register := m.register(cadr(inst))
+		run = func() {
+			m.save(register)
+			m.go_to(a.entries[next])
+		}
+	case "restore":
+		register := m.register(cadr(inst))
+		run = func() {
+			m.restore(register)
+			m.go_to(a.entries[next])
+		}
+	default:
+		raise_error(SyntaxError, "Unknown instruction type", inst)
+	}
+
+	if s.call != nil && m.called != nil {
+		call, apply := s.call, run
+		run = func() {
+			m.called(call)
+			apply()
+		}
+	}
+	if len(s.exps) == 0 {
+		return run
+	}
+	// the expressions evaluated from here on, as eval_dispatch
+	// would have dispatched them
+	exp := s.exps[len(s.exps)-1]
+	var where *Position
+	for _, e := range s.exps {
+		if e.pos != nil {
+			where = e.pos
+		}
+	}
+	if s.where != nil {
+		where = s.where
+	}
+	return func() {
+		m.evaluated(exp, where)
+		run()
+	}
+}
+
+// the value of an operation applied to its operands, or of an operand
+func (a *assembler) value(exp *Value) func() *Value {
+	if !is_tagged_list(car(exp), "op") {
+		return a.operand(car(exp))
+	}
+	operation, ok := a.model.operations[cadr(car(exp)).val.(string)]
+	if !ok {
+		raise_error(SyntaxError, "Unknown operation", cadr(car(exp)))
+	}
+	var operands []func() *Value
+	for exps := cdr(exp); isPair(exps); exps = cdr(exps) {
+		operands = append(operands, a.operand(car(exps)))
+	}
+	return func() *Value {
+		args := make([]*Value, len(operands))
+		for i, operand := range operands {
+			args[i] = operand()
+		}
+		return operation(args)
+	}
+}
+
+func (a *assembler) operand(exp *Value) func() *Value {
+	switch {
+	case is_tagged_list(exp, "const"):
+		v := cadr(exp)
+		return func() *Value {
+			return v
+		}
+	case is_tagged_list(exp, "reg"):
+		register := a.model.register(cadr(exp))
+		return func() *Value {
+			return reg(register)
+		}
+	case is_tagged_list(exp, "label"):
+		return a.label(cadr(exp))
+	}
+	raise_error(SyntaxError, "Unknown expression type", exp)
+	return nil
+}
+
+// compile datum and run the code on the machine, the value is left in
+// the val register like startEval does
+func (in *Interpreter) eval_compiled(datum *Value) error {
+	return in.run_compiled(in.compile_for_machine(datum))
+}
+
+func (in *Interpreter) run_compiled(code *instructionSequence) error {
+	entry := assemble(code.statements, in.machine_model())
+
+	in.initialize_stack()
+	in.err = nil
+	in.where = nil
+	assign(in.env, in.global)
+	assign(in.cont, label(in.done))
+	assign(in.winders, the_empty_extent)
+	assign(in.handlers, no_handlers)
+	in.go_to(entry)
+	in.execute()
+
+	if in.err != nil {
+		return in.err
+	}
+	return nil
+}
+
+// apply proc to args while an expression is compiled, the macros
+// defined when it is compiled are expanded then
+func (in *Interpreter) apply_at_compile_time(proc *Value, args *Value) (*Value, error) {
+	in.initialize_stack()
+	in.err = nil
+	assign(in.proc, proc)
+	assign(in.argl, args)
+	assign(in.cont, label(in.done))
+	in.save(in.cont)
+	assign(in.winders, the_empty_extent)
+	assign(in.handlers, no_handlers)
+	in.go_to(label(in.apply_dispatch))
+	in.execute()
+
+	if in.err != nil {
+		return nil, in.err
+	}
+	return reg(in.val), nil
+}
+
+// Compile writes the instructions that every expression of the file
+// named filename is compiled to. Only the macro definitions of the
+// file are run, so the expressions that follow them can be expanded.
+func (in *Interpreter) Compile(w io.Writer, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := newReader(file, filename)
+	for {
+		datum, err := reader.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		code := in.compile_for_machine(datum)
+		if datum.pos != nil {
+			fmt.Fprintf(w, "; %s\n", datum.pos)
+		}
+		for _, s := range code.statements {
+			if isName(s.text) {
+				fmt.Fprintf(w, "%s\n", s.text)
+			} else {
+				fmt.Fprintf(w, "  %s\n", s.text)
+			}
+		}
+		fmt.Fprintln(w)
+
+		if is_macro_definition(datum) {
+			if err := in.run_compiled(code); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,14 @@
+; variables of frames several levels up, where a lookup by
+; name scans every frame in between
+(define (make-counter a b c d)
+  (let ((e (+ a b)))
+    (let ((f (+ c d)))
+      (lambda (n)
+        (let loop ((i 0) (acc 0))
+          (if (= i n)
+              acc
+              (loop (+ i 1) (+ acc a b c d e f))))))))
+
+(define count (make-counter 1 2 3 4))
+(display (count 50000))
+(newline)
@@ -0,0 +1,15 @@
+; a loop nested in five procedures of four parameters each, the
+; variables it uses are bound in the outermost frames
+(define (level1 a1 b1 c1 d1)
+  (lambda (a2 b2 c2 d2)
+    (lambda (a3 b3 c3 d3)
+      (lambda (a4 b4 c4 d4)
+        (lambda (a5 b5 c5 d5)
+          (define (loop i acc)
+            (if (= i 0)
+                acc
+                (loop (- i 1) (+ acc a1 b1 c1 d1 a2 b2 c2 d2 a3 b3))))
+          (loop 20000 0))))))
+
+(display (((((level1 1 2 3 4) 5 6 7 8) 9 10 11 12) 13 14 15 16) 17 18 19 20))
+(newline)
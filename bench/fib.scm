@@ -0,0 +1,9 @@
+; doubly recursive calls, every reference is to a parameter
+; or to a global procedure
+(define (fib n)
+  (if (< n 2)
+      n
+      (+ (fib (- n 1)) (fib (- n 2)))))
+
+(display (fib 22))
+(newline)
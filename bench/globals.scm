@@ -0,0 +1,12 @@
+; references to global variables, the global frame holds every
+; primitive and both lookups find them through its index
+(define v0 0) (define v1 1) (define v2 2) (define v3 3) (define v4 4)
+(define v5 5) (define v6 6) (define v7 7) (define v8 8) (define v9 9)
+
+(define (sum-globals n acc)
+  (if (= n 0)
+      acc
+      (sum-globals (- n 1) (+ acc v0 v1 v2 v3 v4 v5 v6 v7 v8 v9))))
+
+(display (sum-globals 20000 0))
+(newline)
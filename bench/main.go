@@ -0,0 +1,87 @@
+// Command bench compares the ways the interpreter can evaluate a
+// program: the explicit-control evaluator looking variables up by name
+// or by lexical address, the analyzing evaluator, the compiler to
+// register-machine instructions and the virtual machine. Every program
+// given is run several times in a fresh interpreter in each way, its
+// output is discarded and the fastest run is reported with the ratio
+// of the time of the lookup by name to its own:
+//
+//	go run ./bench bench/*.scm
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jonathantorres/scm"
+)
+
+var runs = flag.Int("runs", 3, "number of runs of every program")
+
+type evaluator struct {
+	name    string
+	lexical bool
+	analyze bool
+	compile bool
+	vm      bool
+}
+
+var evaluators = []evaluator{
+	{name: "scan"},
+	{name: "lexical", lexical: true},
+	{name: "analyze", lexical: true, analyze: true},
+	{name: "compiled", compile: true},
+	{name: "vm", vm: true},
+}
+
+func main() {
+	flag.Parse()
+
+	fmt.Printf("%-22s", "program")
+	for _, e := range evaluators {
+		fmt.Printf(" %18s", e.name)
+	}
+	fmt.Println()
+
+	for _, filename := range flag.Args() {
+		fmt.Printf("%-22s", filename)
+		var base time.Duration
+		for i, e := range evaluators {
+			d, err := fastest(filename, e)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "\n%s: %s\n", filename, err)
+				os.Exit(1)
+			}
+			if i == 0 {
+				base = d
+			}
+			fmt.Printf(" %10s (%.2fx)", d.Round(time.Millisecond), float64(base)/float64(d))
+		}
+		fmt.Println()
+	}
+}
+
+// the shortest time taken to run the program in filename
+func fastest(filename string, e evaluator) (time.Duration, error) {
+	var best time.Duration
+	for i := 0; i < *runs; i++ {
+		in := scm.New()
+		in.SetOutput(io.Discard)
+		in.SetLexicalAddressing(e.lexical)
+		in.SetAnalyzing(e.analyze)
+		in.SetCompiled(e.compile)
+		in.SetBytecode(e.vm)
+
+		start := time.Now()
+		if _, err := in.EvalFile(filename); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); i == 0 || d < best {
+			best = d
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,12 @@
+; the Takeuchi function, three parameters looked up at every call
+(define (tak x y z)
+  (if (not (< y x))
+      z
+      (tak (tak (- x 1) y z)
+           (tak (- y 1) z x)
+           (tak (- z 1) x y))))
+
+(define (not x) (if x #f #t))
+
+(display (tak 18 12 6))
+(newline)
@@ -0,0 +1,288 @@
+package scm
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// The bytecode of the virtual machine. A lambda expression is compiled
+// to a prototype, the instructions of its body, the constants they use
+// and how a closure made from it captures the variables of the
+// procedures around it. An instruction is a 32 bit word with the
+// opcode in the low byte and its operand in the other 24 bits.
+//
+// Every call has a frame on the stack of the machine. The procedure
+// called is just below the frame, the arguments are the first slots of
+// the frame and the local variables of the let expressions of the body
+// follow them, the operands of the instructions are pushed above the
+// slots. A variable that a closure captures or that is assigned with
+// set! lives in a cell instead of a slot: the closures share the cell
+// and a continuation that copies the stack does not copy the variable.
+
+type opcode uint8
+
+const (
+	// push constants[arg]
+	op_const opcode = iota
+	op_pop
+	// push the value of slot arg
+	op_local
+	// pop the value of slot arg
+	op_set_local
+	// pop the value of a new cell arg
+	op_box
+	// a new cell arg for a variable not defined yet
+	op_new_cell
+	op_cell
+	op_set_cell
+	// the cells captured by the closure
+	op_upvalue
+	op_set_upvalue
+	// the global variable named constants[arg]
+	op_global
+	op_set_global
+	op_define_global
+	// signal an error when the value on top is the value of a
+	// variable not assigned yet, constants[arg] is its name
+	op_check
+	// jump to arg when the value on top is the value of a cell not
+	// defined yet, which is popped
+	op_jump_undefined
+	op_jump
+	// pop the value on top and jump when it is false
+	op_jump_false
+	// jump when the value on top is false, pop it otherwise
+	op_jump_false_or_pop
+	op_jump_true_or_pop
+	// push a closure of prototypes[arg]
+	op_closure
+	// call the procedure below the arg values on top
+	op_call
+	op_tail_call
+	op_return
+	// remember the position of the instruction, see where
+	op_where
+	// signal the error object constants[arg]
+	op_signal
+)
+
+var opcode_names = [...]string{
+	op_const:             "const",
+	op_pop:               "pop",
+	op_local:             "local",
+	op_set_local:         "set-local",
+	op_box:               "box",
+	op_new_cell:          "new-cell",
+	op_cell:              "cell",
+	op_set_cell:          "set-cell",
+	op_upvalue:           "upvalue",
+	op_set_upvalue:       "set-upvalue",
+	op_global:            "global",
+	op_set_global:        "set-global",
+	op_define_global:     "define-global",
+	op_check:             "check",
+	op_jump_undefined:    "jump-undefined",
+	op_jump:              "jump",
+	op_jump_false:        "jump-false",
+	op_jump_false_or_pop: "jump-false-or-pop",
+	op_jump_true_or_pop:  "jump-true-or-pop",
+	op_closure:           "closure",
+	op_call:              "call",
+	op_tail_call:         "tail-call",
+	op_return:            "return",
+	op_where:             "where",
+	op_signal:            "signal",
+}
+
+func (op opcode) String() string {
+	if int(op) < len(opcode_names) {
+		return opcode_names[op]
+	}
+	return fmt.Sprintf("op%d", op)
+}
+
+// the largest operand of an instruction
+const max_operand = 1<<24 - 1
+
+func instruction(op opcode, arg int) uint32 {
+	return uint32(op) | uint32(arg)<<8
+}
+
+func instruction_opcode(i uint32) opcode {
+	return opcode(i & 0xff)
+}
+
+func instruction_operand(i uint32) int {
+	return int(i >> 8)
+}
+
+// Prototype is a compiled lambda expression, or the top level
+// expression that is compiled to a procedure without parameters
+type Prototype struct {
+	name string
+	// the lambda expression, nil at the top level
+	exp *Value
+	// the parameter list, a rest parameter takes a slot of its own
+	parameters *Value
+	nparams    int
+	rest       bool
+	nslots     int
+	ncells     int
+
+	code       []uint32
+	constants  []*Value
+	prototypes []*Prototype
+	// for every upvalue of the closures made from the prototype,
+	// the cell or the upvalue of the enclosing frame it captures
+	captures []capture
+
+	// for every instruction, the position of the last expression
+	// evaluated before it and the expression itself, nil when they
+	// are only known while the program runs
+	where []*Position
+	exps  []*Value
+	// for every call, the combination
+	calls []*form
+}
+
+type capture struct {
+	cell  bool
+	index int
+}
+
+// a procedure defined at the top level is compiled when it is first
+// called, so that its body can use the macros defined after it
+func (p *Prototype) compiled() bool {
+	return p.code != nil
+}
+
+type closure struct {
+	proto    *Prototype
+	upvalues []*cell
+}
+
+// a variable shared by the closures that capture it, the value is
+// nil while an internal definition that is not scanned out has not
+// defined the variable yet
+type cell struct {
+	value *Value
+}
+
+func make_closure(proto *Prototype, upvalues []*cell) *Value {
+	return &Value{
+		kind: Closure,
+		val: &closure{
+			proto:    proto,
+			upvalues: upvalues,
+		},
+	}
+}
+
+func is_closure(v *Value) bool {
+	return v.kind == Closure
+}
+
+func closure_name(v *Value) string {
+	if name := v.val.(*closure).proto.name; name != "" {
+		return fmt.Sprintf("#<procedure %s>", name)
+	}
+	return "#<procedure>"
+}
+
+// Disassemble compiles every expression of the file named filename
+// and writes the instructions of every prototype to w. Only the
+// macro definitions of the file are evaluated, so the expressions
+// that follow them can be expanded.
+func (in *Interpreter) Disassemble(w io.Writer, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := newReader(file, filename)
+
+	for {
+		datum, err := reader.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = in.each_toplevel(datum, nil, func(exp *Value, where *Position) error {
+			proto := in.compile_toplevel(exp)
+			disassemble(w, proto, in)
+			if is_macro_definition(exp) {
+				m := in.new_vm()
+				m.where = where
+				if m.execute_toplevel(proto); m.err != nil {
+					return m.err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func disassemble(w io.Writer, p *Prototype, in *Interpreter) {
+	if !p.compiled() {
+		in.compile_lazily(p)
+	}
+
+	name := p.name
+	if name == "" {
+		name = "lambda"
+	}
+	if p.exp == nil {
+		name = "top level"
+	}
+	fmt.Fprintf(w, "%s", name)
+	if p.exp != nil {
+		fmt.Fprintf(w, " %s", p.parameters)
+	}
+	if p.exp != nil && p.exp.pos != nil {
+		fmt.Fprintf(w, " at %s", p.exp.pos)
+	} else if len(p.where) > 0 && p.where[0] != nil {
+		fmt.Fprintf(w, " at %s", p.where[0])
+	}
+	fmt.Fprintf(w, "\n  slots %d, cells %d, upvalues %d\n", p.nslots, p.ncells, len(p.captures))
+
+	var last *Position
+	for ip, i := range p.code {
+		op := instruction_opcode(i)
+		arg := instruction_operand(i)
+
+		where := ""
+		if p.where[ip] != nil && p.where[ip] != last {
+			where = fmt.Sprintf("%d:%d", p.where[ip].Line, p.where[ip].Column)
+			last = p.where[ip]
+		}
+		switch op {
+		case op_const, op_global, op_set_global, op_define_global, op_check, op_signal:
+			fmt.Fprintf(w, "  %04d %7s  %-18s %-4d ; %s\n", ip, where, op, arg, p.constants[arg])
+		case op_closure:
+			fmt.Fprintf(w, "  %04d %7s  %-18s %-4d ; %s\n", ip, where, op, arg, prototype_name(p.prototypes[arg]))
+		case op_pop, op_return, op_where:
+			fmt.Fprintf(w, "  %04d %7s  %s\n", ip, where, op)
+		default:
+			fmt.Fprintf(w, "  %04d %7s  %-18s %d\n", ip, where, op, arg)
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, child := range p.prototypes {
+		disassemble(w, child, in)
+	}
+}
+
+func prototype_name(p *Prototype) string {
+	if p.name == "" {
+		return "lambda"
+	}
+	return p.name
+}
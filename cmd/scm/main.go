@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jonathantorres/scm"
+)
+
+var (
+	scan    = flag.Bool("scan", false, "look up variables by name instead of by lexical address")
+	analyze = flag.Bool("analyze", false, "analyze every expression once before it is executed")
+	vm      = flag.Bool("vm", false, "compile every expression to bytecode run by the virtual machine")
+	stats   = flag.Bool("stats", false, "print the statistics of the machine when the program ends")
+	trace   = flag.Bool("trace", false, "print every call to a procedure bound to a name and the value it returns")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: scm [flags] [file]\n")
+		fmt.Fprintf(os.Stderr, "       scm run [-compiled] file\n")
+		fmt.Fprintf(os.Stderr, "       scm compile file\n")
+		fmt.Fprintf(os.Stderr, "       scm disasm file\n")
+		fmt.Fprintf(os.Stderr, "       scm machine description file\n")
+		fmt.Fprintf(os.Stderr, "       scm debug file\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	in := scm.New()
+	in.SetLexicalAddressing(!*scan)
+	in.SetAnalyzing(*analyze)
+	in.SetBytecode(*vm)
+	in.SetStatistics(*stats)
+	in.SetTracing(*trace)
+
+	if flag.NArg() == 0 {
+		// no file to run, start the interactive loop
+		if err := in.Repl(os.Stdin); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "run":
+		// run the file, compiled or with the evaluator
+		run := flag.NewFlagSet("run", flag.ExitOnError)
+		compiled := run.Bool("compiled", false, "compile every expression to instructions of the register machine")
+		run.Parse(flag.Args()[1:])
+		if run.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		in.SetCompiled(*compiled)
+		runFile(in, run.Arg(0))
+		return
+	case "compile":
+		// print the instructions the file is compiled to
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := in.Compile(os.Stdout, flag.Arg(1)); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	case "debug":
+		// run the file under the debugger
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := in.Debug(flag.Arg(1), os.Stdin); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	case "machine":
+		// run the file on the machine in the description file
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := in.RunMachine(flag.Arg(1), flag.Arg(2)); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	case "disasm":
+		// print the bytecode the file is compiled to
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := in.Disassemble(os.Stdout, flag.Arg(1)); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	runFile(in, flag.Arg(0))
+}
+
+// run the file, the statistics are printed even when
+// the program signals an error
+func runFile(in *scm.Interpreter, filename string) {
+	_, err := in.EvalFile(filename)
+	if *stats {
+		in.WriteStatistics(os.Stderr)
+	}
+	if err != nil {
+		printError(err)
+		os.Exit(1)
+	}
+}
+
+func printError(err error) {
+	var serr *scm.SchemeError
+	if errors.As(err, &serr) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", serr)
+		if serr.Exp != nil {
+			fmt.Fprintf(os.Stderr, "  in expression: %s\n", serr.Exp)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+}
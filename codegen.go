@@ -0,0 +1,361 @@
+package scm
+
+// The second pass of the compiler, the instructions of a procedure are
+// generated from the nodes of its body. Every node leaves its value on
+// top of the stack, a node in tail position returns it instead and a
+// combination in tail position replaces the frame of the procedure.
+//
+// The errors signaled by the machine report the position of the last
+// expression evaluated, as the evaluator does. The generator knows it
+// for most instructions from the order the nodes are evaluated in and
+// records it with every instruction, it is only lost after a call,
+// which evaluates the body of another procedure, and after the branches
+// of a conditional join. A call and a return leave the last position
+// in the where register of the machine, and so does a where instruction
+// at the end of the branches, the instructions that follow use it.
+
+type generator struct {
+	fn    *function
+	proto *Prototype
+	// the position and the expression of the last expression
+	// evaluated, nil when they are in the registers of the machine
+	where *Position
+	exp   *Value
+
+	constants map[*Value]int
+	ok        *Value
+}
+
+// generate the instructions of fn and of the procedures made in its body
+func (c *compiler) generate(fn *function) *Prototype {
+	p := fn.proto
+	if p == nil {
+		p = &Prototype{name: fn.name, exp: fn.exp}
+		fn.proto = p
+	} else if fn.body == nil {
+		// compiled when it is first called
+		return p
+	}
+	if fn.exp != nil {
+		p.parameters = lambda_parameters(fn.exp)
+	}
+	p.nparams = len(fn.params)
+	p.rest = fn.rest
+	if p.rest {
+		p.nparams--
+	}
+
+	p.nslots = len(fn.params)
+	for i, v := range fn.variables {
+		if is_boxed(v) {
+			v.cell = p.ncells
+			p.ncells++
+		}
+		if i < len(fn.params) {
+			v.slot = i
+		} else if !is_boxed(v) {
+			v.slot = p.nslots
+			p.nslots++
+		}
+	}
+
+	g := &generator{
+		fn:        fn,
+		proto:     p,
+		constants: map[*Value]int{},
+	}
+	for _, v := range fn.params {
+		if is_boxed(v) {
+			g.emit(op_local, v.slot)
+			g.emit(op_box, v.cell)
+		}
+	}
+	g.enter(fn.scope)
+	c.node(g, fn.body, true)
+	return p
+}
+
+func (g *generator) emit(op opcode, arg int) int {
+	if arg > max_operand {
+		raise_error(SyntaxError, "procedure too large to compile", make_name(g.fn.name))
+	}
+	p := g.proto
+	p.code = append(p.code, instruction(op, arg))
+	p.where = append(p.where, g.where)
+	p.exps = append(p.exps, g.exp)
+	p.calls = append(p.calls, nil)
+	return len(p.code) - 1
+}
+
+// point the jump at ip to the next instruction
+func (g *generator) patch(ip int) {
+	p := g.proto
+	p.code[ip] = instruction(instruction_opcode(p.code[ip]), len(p.code))
+}
+
+func (g *generator) constant(v *Value) int {
+	if i, ok := g.constants[v]; ok {
+		return i
+	}
+	g.proto.constants = append(g.proto.constants, v)
+	g.constants[v] = len(g.proto.constants) - 1
+	return len(g.proto.constants) - 1
+}
+
+// the value of assignments and definitions
+func (g *generator) ok_constant() int {
+	if g.ok == nil {
+		g.ok = constant("ok")
+	}
+	return g.constant(g.ok)
+}
+
+// the node is evaluated, as the evaluator dispatches an expression
+func (g *generator) evaluate(n node) {
+	f := n.source()
+	if f.pos != nil {
+		g.where = f.pos
+	}
+	if f.exp != nil {
+		g.exp = f.exp
+	}
+}
+
+// the last expression evaluated is only known while the program runs
+func (g *generator) forget() {
+	g.where = nil
+	g.exp = nil
+}
+
+// before a jump to the end of a conditional, the last expression
+// evaluated is left in the registers of the machine
+func (g *generator) join() {
+	if g.where != nil || g.exp != nil {
+		g.emit(op_where, 0)
+	}
+}
+
+// the conditional definitions of a scope are undefined when it is entered
+func (g *generator) enter(s *block) {
+	if s == nil {
+		return
+	}
+	for _, v := range s.defines {
+		g.emit(op_new_cell, v.cell)
+	}
+}
+
+func (c *compiler) node(g *generator, n node, tail bool) {
+	g.evaluate(n)
+
+	switch n := n.(type) {
+	case *constantNode:
+		g.emit(op_const, g.constant(n.value))
+	case *referenceNode:
+		g.load(n.variable)
+	case *assignmentNode:
+		c.node(g, n.value, false)
+		g.store(n.variable, n.define)
+		g.emit(op_const, g.ok_constant())
+	case *ifNode:
+		c.if_node(g, n, tail)
+		return
+	case *lambdaNode:
+		c.lambda_node(g, n)
+	case *sequenceNode:
+		for i, exp := range n.nodes {
+			last := i == len(n.nodes)-1
+			c.node(g, exp, tail && last)
+			if !last {
+				g.emit(op_pop, 0)
+			}
+		}
+		return
+	case *logicalNode:
+		c.logical_node(g, n, tail)
+		return
+	case *applicationNode:
+		c.node(g, n.operator, false)
+		for _, operand := range n.operands {
+			c.node(g, operand, false)
+		}
+		op := op_call
+		if tail {
+			op = op_tail_call
+		}
+		call := g.emit(op, len(n.operands))
+		g.proto.calls[call] = n.source()
+		g.forget()
+		return
+	case *letNode:
+		if n.lambda != nil {
+			g.where = n.lambda
+		}
+		for _, init := range n.inits {
+			c.node(g, init, false)
+		}
+		for i := len(n.variables) - 1; i >= 0; i-- {
+			if v := n.variables[i]; is_boxed(v) {
+				g.emit(op_box, v.cell)
+			} else {
+				g.emit(op_set_local, v.slot)
+			}
+		}
+		g.enter(n.scope)
+		c.node(g, n.body, tail)
+		return
+	case *errorNode:
+		g.emit(op_signal, g.constant(make_error(n.err)))
+		return
+	}
+
+	if tail {
+		g.emit(op_return, 0)
+	}
+}
+
+func (c *compiler) if_node(g *generator, n *ifNode, tail bool) {
+	c.node(g, n.predicate, false)
+	alternative := g.emit(op_jump_false, 0)
+	where, exp := g.where, g.exp
+
+	c.node(g, n.consequent, tail)
+	end := -1
+	if !tail {
+		g.join()
+		end = g.emit(op_jump, 0)
+	}
+
+	g.patch(alternative)
+	g.where, g.exp = where, exp
+	c.node(g, n.alternative, tail)
+	if !tail {
+		g.join()
+		g.patch(end)
+		g.forget()
+	}
+}
+
+func (c *compiler) logical_node(g *generator, n *logicalNode, tail bool) {
+	if len(n.nodes) == 0 {
+		g.emit(op_const, g.constant(&Value{kind: Boolean, val: n.and}))
+		if tail {
+			g.emit(op_return, 0)
+		}
+		return
+	}
+
+	jump := op_jump_true_or_pop
+	if n.and {
+		jump = op_jump_false_or_pop
+	}
+	var exits []int
+	for i, operand := range n.nodes {
+		last := i == len(n.nodes)-1
+		c.node(g, operand, tail && last)
+		if !last {
+			g.join()
+			exits = append(exits, g.emit(jump, 0))
+		}
+	}
+	if !tail {
+		g.join()
+	}
+	for _, exit := range exits {
+		g.patch(exit)
+	}
+	g.forget()
+	if tail && len(exits) > 0 {
+		g.emit(op_return, 0)
+	}
+}
+
+func (c *compiler) lambda_node(g *generator, n *lambdaNode) {
+	fn := n.function
+	p := c.generate(fn)
+	p.captures = nil
+	for _, v := range fn.upvalues {
+		if v.fn == g.fn {
+			p.captures = append(p.captures, capture{cell: true, index: v.cell})
+		} else {
+			p.captures = append(p.captures, capture{index: g.upvalue(v)})
+		}
+	}
+	g.proto.prototypes = append(g.proto.prototypes, p)
+	g.emit(op_closure, len(g.proto.prototypes)-1)
+}
+
+func (g *generator) upvalue(v *variable) int {
+	for i, u := range g.fn.upvalues {
+		if u == v {
+			return i
+		}
+	}
+	panic("variable not captured " + v.name.String())
+}
+
+// push the value of v
+func (g *generator) load(v *variable) {
+	switch {
+	case is_global_variable(v):
+		g.emit(op_global, g.constant(v.name))
+		return
+	case v.fn != g.fn:
+		g.emit(op_upvalue, g.upvalue(v))
+	case is_boxed(v):
+		g.emit(op_cell, v.cell)
+	default:
+		g.emit(op_local, v.slot)
+	}
+
+	if v.unassigned {
+		g.emit(op_check, g.constant(v.name))
+	}
+	if v.conditional {
+		undefined := g.emit(op_jump_undefined, 0)
+		end := g.emit(op_jump, 0)
+		g.patch(undefined)
+		g.load(v.shadows)
+		g.patch(end)
+	}
+}
+
+// pop the value on top into v
+func (g *generator) store(v *variable, define bool) {
+	switch {
+	case is_global_variable(v):
+		if define {
+			g.emit(op_define_global, g.constant(v.name))
+		} else {
+			g.emit(op_set_global, g.constant(v.name))
+		}
+		return
+	case v.conditional && !define:
+		// the variable it shadows is assigned until it is defined
+		if v.fn != g.fn {
+			g.emit(op_upvalue, g.upvalue(v))
+		} else {
+			g.emit(op_cell, v.cell)
+		}
+		undefined := g.emit(op_jump_undefined, 0)
+		g.emit(op_pop, 0)
+		g.store_defined(v)
+		end := g.emit(op_jump, 0)
+		g.patch(undefined)
+		g.store(v.shadows, false)
+		g.patch(end)
+	default:
+		g.store_defined(v)
+	}
+}
+
+func (g *generator) store_defined(v *variable) {
+	switch {
+	case v.fn != g.fn:
+		g.emit(op_set_upvalue, g.upvalue(v))
+	case is_boxed(v):
+		g.emit(op_set_cell, v.cell)
+	default:
+		g.emit(op_set_local, v.slot)
+	}
+}
@@ -0,0 +1,732 @@
+package scm
+
+// The compiler of the virtual machine. An expression is compiled in
+// two passes. The first one expands the derived expressions and the
+// macros and resolves every variable to a local variable of a lambda
+// or let expression, or to a global variable, producing a tree of
+// nodes. Once the whole body of a procedure is known, and with it which
+// variables are captured by a closure or assigned, the second pass
+// generates the instructions of every procedure (see codegen.go).
+//
+// The derived expressions are transformed by the same procedures as
+// the evaluator uses. A combination whose operator is a lambda
+// expression, as a let becomes, does not make a procedure: its
+// variables are slots of the frame of the procedure around it.
+//
+// Macros are expanded while compiling, the transformers of the macros
+// defined at the top level run on the machine before the expressions
+// that follow them are compiled. The code of a macro use is not compiled
+// again when the macro is redefined, it keeps the old expansion. A procedure defined at the top level
+// is only compiled when it is first called, so its body can use a macro
+// defined after it like the evaluator allows. A macro defined with
+// define-macro in a body is evaluated in the global environment when
+// the body is compiled, the syntax-rules macros of define-syntax,
+// let-syntax and letrec-syntax are closed in the scope of their
+// definition.
+//
+// A syntax error is compiled into an instruction that signals it, so
+// it is signaled when the expression would be evaluated.
+
+// the expression a node was compiled from, and its position in
+// the source when the expression was read from one
+type form struct {
+	exp *Value
+	pos *Position
+}
+
+func (f *form) source() *form {
+	return f
+}
+
+type node interface {
+	source() *form
+}
+
+type constantNode struct {
+	form
+	value *Value
+}
+
+type referenceNode struct {
+	form
+	variable *variable
+}
+
+// an assignment, or a definition in the global environment or
+// of a variable that is not scanned out
+type assignmentNode struct {
+	form
+	variable *variable
+	value    node
+	define   bool
+}
+
+type ifNode struct {
+	form
+	predicate   node
+	consequent  node
+	alternative node
+}
+
+type lambdaNode struct {
+	form
+	function *function
+}
+
+type sequenceNode struct {
+	form
+	nodes []node
+}
+
+// and or or
+type logicalNode struct {
+	form
+	and   bool
+	nodes []node
+}
+
+type applicationNode struct {
+	form
+	operator node
+	operands []node
+}
+
+// the variables of a let, or of a combination whose operator is a
+// lambda expression, bound in the frame of the procedure around it
+type letNode struct {
+	form
+	// the position of the lambda expression, evaluated before the
+	// operands by the evaluator
+	lambda    *Position
+	variables []*variable
+	inits     []node
+	body      node
+	scope     *block
+}
+
+// a syntax error found while compiling
+type errorNode struct {
+	form
+	err *SchemeError
+}
+
+// a variable of a frame, or a global variable when fn is nil
+type variable struct {
+	name *Value
+	fn   *function
+
+	// captured by a closure of another procedure
+	captured bool
+	// assigned with set! or a definition
+	assigned bool
+	// bound to the unassigned value of a letrec or of the defines
+	// scanned out of a body, it is checked when it is looked up
+	unassigned bool
+	// defined by a definition that is not scanned out, before the
+	// definition runs the variable it shadows is used instead
+	conditional bool
+	shadows     *variable
+
+	// where it is stored, set by the second pass
+	slot int
+	cell int
+}
+
+func is_global_variable(v *variable) bool {
+	return v.fn == nil
+}
+
+// captured and assigned variables, and conditional definitions that
+// start out undefined, are kept in a cell
+func is_boxed(v *variable) bool {
+	return v.captured || v.assigned || v.conditional
+}
+
+// a procedure being compiled
+type function struct {
+	name   string
+	exp    *Value
+	parent *function
+	proto  *Prototype
+
+	// params first, then the variables of the let expressions
+	params    []*variable
+	rest      bool
+	variables []*variable
+	// the variables of the procedures around it used by its closures
+	upvalues []*variable
+	scope    *block
+	body     node
+}
+
+// the variables of a procedure, of a let expression or the macros
+// of a let-syntax
+type block struct {
+	fn       *function
+	parent   *block
+	bindings []blockBinding
+	// the variables defined by definitions that are not scanned out,
+	// they are undefined when the scope is entered
+	defines []*variable
+	// the environment the syntax-rules macros of the scope are closed in
+	env *Value
+}
+
+// a variable or a macro
+type blockBinding struct {
+	name     *Value
+	variable *variable
+	macro    *Value
+}
+
+type compiler struct {
+	in    *Interpreter
+	fn    *function
+	scope *block
+	// the position of the last expression compiled
+	where *Position
+	// the scopes of the environments that syntax-rules macros are
+	// closed in, see scope_environment
+	scopes map[*Value]*block
+}
+
+func (in *Interpreter) new_compiler() *compiler {
+	return &compiler{
+		in:     in,
+		scopes: map[*Value]*block{},
+	}
+}
+
+// compile an expression at the top level to a procedure without
+// parameters
+func (in *Interpreter) compile_toplevel(exp *Value) *Prototype {
+	c := in.new_compiler()
+	fn := &function{}
+	c.fn = fn
+	fn.body = c.compile(exp)
+	c.generate(fn)
+	return fn.proto
+}
+
+// compile the prototype of a procedure defined at the top level
+func (in *Interpreter) compile_lazily(p *Prototype) {
+	c := in.new_compiler()
+	fn := c.function(p.exp, p.name)
+	fn.proto = p
+	c.generate(fn)
+}
+
+func (c *compiler) compile(exp *Value) (n node) {
+	if exp.pos != nil {
+		c.where = exp.pos
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			// an error of a macro transformer was signaled where it ran
+			if err.Pos == nil {
+				err.Exp = exp
+				err.Pos = exp.pos
+				if err.Pos == nil {
+					err.Pos = c.where
+				}
+			}
+			n = &errorNode{form: c.form(exp), err: err}
+		}
+	}()
+
+	switch {
+	case test(is_self_evaluating(exp)):
+		return &constantNode{form: c.form(exp), value: exp}
+	case isName(exp):
+		return c.reference(exp)
+	case c.in.special_form(exp) != nil:
+		return c.special_form(exp)
+	case test(is_application(exp)):
+		return c.application(exp)
+	}
+	raise_error(UnknownExpError, "Unknown expression type")
+	return nil
+}
+
+func (c *compiler) form(exp *Value) form {
+	return form{exp: exp, pos: exp.pos}
+}
+
+// the car of pair compiled, a reference is at the
+// position where its name was read
+func (c *compiler) element(pair *Value) node {
+	pos := car_position(pair)
+	if !isName(car(pair)) || pos == nil {
+		return c.compile(car(pair))
+	}
+	c.where = pos
+	n := c.compile(car(pair))
+	n.source().pos = pos
+	return n
+}
+
+// the node of exp compiled from the expression it was transformed
+// into, the position of exp is kept when the expansion has none
+func (c *compiler) derived(exp *Value, expansion *Value) node {
+	n := c.compile(expansion)
+	if n.source().pos == nil {
+		n.source().pos = exp.pos
+	}
+	return n
+}
+
+// the alternative of an if expression, false when it has none
+func (c *compiler) alternative(exp *Value) node {
+	if !isNull(cdddr(exp)) {
+		return c.element(cdddr(exp))
+	}
+	return c.compile(if_alternative(exp))
+}
+
+func (c *compiler) special_form(exp *Value) node {
+	name := original_name(car(exp))
+	if form, ok := c.in.extensions[name]; ok {
+		expansion, err := form(exp)
+		if err != nil {
+			raise_error(SyntaxError, err.Error(), exp)
+		}
+		return c.derived(exp, expansion)
+	}
+
+	switch name.val.(string) {
+	case "quote":
+		return &constantNode{form: c.form(exp), value: text_of_quotation(exp)}
+	case "set!":
+		return c.assignment(exp)
+	case "define":
+		return c.definition(exp)
+	case "if":
+		return &ifNode{
+			form:        c.form(exp),
+			predicate:   c.element(cdr(exp)),
+			consequent:  c.element(cddr(exp)),
+			alternative: c.alternative(exp),
+		}
+	case "lambda":
+		return &lambdaNode{form: c.form(exp), function: c.lambda(exp, "")}
+	case "begin":
+		return c.sequence(c.form(exp), begin_actions(exp))
+	case "and", "or":
+		n := &logicalNode{form: c.form(exp), and: name.val.(string) == "and"}
+		for operands := logical_operands(exp); isPair(operands); operands = cdr(operands) {
+			n.nodes = append(n.nodes, c.element(operands))
+		}
+		return n
+	case "quasiquote":
+		return c.derived(exp, quasiquote_to_combination(exp))
+	case "let":
+		return c.derived(exp, let_to_combination(exp))
+	case "let*":
+		return c.derived(exp, let_star_to_nested_lets(exp))
+	case "letrec", "letrec*":
+		return c.derived(exp, letrec_to_let(exp))
+	case "cond":
+		return c.derived(exp, cond_to_if(exp))
+	case "when":
+		return c.derived(exp, when_to_if(exp))
+	case "unless":
+		return c.derived(exp, unless_to_if(exp))
+	case "case":
+		return c.derived(exp, case_to_cond(exp))
+	case "do":
+		return c.derived(exp, do_to_named_let(exp))
+	case "guard":
+		return c.derived(exp, guard_to_combination(
+			exp,
+			c.in.machine_procedure("call/cc"),
+			c.in.machine_procedure("with-exception-handler"),
+			c.in.machine_procedure("raise-continuable")))
+	case "define-macro":
+		return c.define_macro(exp, define_macro_to_definition(exp))
+	case "defmacro":
+		return c.define_macro(exp, defmacro_to_definition(exp))
+	case "define-syntax":
+		return c.define_syntax(exp)
+	case "let-syntax", "letrec-syntax":
+		return c.let_syntax(exp, name.val.(string) == "letrec-syntax")
+	}
+	raise_error(UnknownExpError, "Unknown expression type")
+	return nil
+}
+
+// the variable or the macro name refers to in scope s. An alias that
+// is not bound by the expansion that introduced it refers to its
+// original name where its macro was defined
+func (c *compiler) resolve(name *Value, s *block) (*variable, *Value) {
+	for ; s != nil; s = s.parent {
+		for i := len(s.bindings) - 1; i >= 0; i-- {
+			if b := s.bindings[i]; b.name == name {
+				return b.variable, b.macro
+			}
+		}
+	}
+	if name.ctx != nil {
+		if s, ok := c.scopes[name.ctx.env]; ok {
+			return c.resolve(name.ctx.name, s)
+		}
+	}
+
+	if v := binding_value(name, c.in.global); v != nil && test(is_macro(v)) {
+		return nil, v
+	}
+	// the alias of a global macro is looked up by the machine, it
+	// falls back to the original name when it is not defined
+	return &variable{name: name}, nil
+}
+
+// the lexical bindings of the names in scope s, the variable or the
+// macro a name refers to. nil for a name of the top level
+func (c *compiler) bindings(s *block) func(name *Value) interface{} {
+	return func(name *Value) interface{} {
+		return c.binding(name, s)
+	}
+}
+
+func (c *compiler) binding(name *Value, s *block) interface{} {
+	for ; s != nil; s = s.parent {
+		for i := len(s.bindings) - 1; i >= 0; i-- {
+			if b := s.bindings[i]; b.name == name {
+				if b.variable != nil {
+					return b.variable
+				}
+				return b.macro
+			}
+		}
+	}
+	if name.ctx != nil {
+		if s, ok := c.scopes[name.ctx.env]; ok {
+			return c.binding(name.ctx.name, s)
+		}
+		return lexical_binding(name.ctx.name, name.ctx.env)
+	}
+	return nil
+}
+
+// a reference to variable from the procedure being compiled, the
+// procedures in between capture it too
+func (c *compiler) use(v *variable) *variable {
+	if is_global_variable(v) || v.fn == c.fn {
+		return v
+	}
+	v.captured = true
+	for fn := c.fn; fn != v.fn; fn = fn.parent {
+		if !has_upvalue(fn, v) {
+			fn.upvalues = append(fn.upvalues, v)
+		}
+	}
+	if v.conditional {
+		c.use(v.shadows)
+	}
+	return v
+}
+
+func has_upvalue(fn *function, v *variable) bool {
+	for _, u := range fn.upvalues {
+		if u == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiler) reference(name *Value) node {
+	v, macro := c.resolve(name, c.scope)
+	if macro != nil {
+		// the value of a macro used as a variable
+		return &constantNode{form: c.form(name), value: macro}
+	}
+	return &referenceNode{form: c.form(name), variable: c.use(v)}
+}
+
+// a lambda expression assigned to a variable of the innermost scope,
+// as letrec and named let bind their procedures, is named after the
+// variable like a definition
+func (c *compiler) assignment(exp *Value) node {
+	name := assignment_variable(exp)
+	v, _ := c.resolve(name, c.scope)
+	if v == nil {
+		raise_error(SyntaxError, "set!: not a variable", name)
+	}
+	var value node
+	if lambda := assignment_value(exp); test(is_lambda(lambda)) && c.in.special_form(lambda) != nil && c.binds(v) {
+		value = &lambdaNode{form: c.form(lambda), function: c.lambda(lambda, name.val.(string))}
+	} else {
+		value = c.element(cddr(exp))
+	}
+	v.assigned = true
+	return &assignmentNode{form: c.form(exp), variable: c.use(v), value: value}
+}
+
+func (c *compiler) binds(v *variable) bool {
+	if c.scope == nil {
+		return is_global_variable(v)
+	}
+	for _, b := range c.scope.bindings {
+		if b.variable == v {
+			return true
+		}
+	}
+	return false
+}
+
+// a definition at the top level defines a global variable, one that
+// is not scanned out of a body defines a variable of the scope that
+// shadows the variable of the same name until the definition runs
+func (c *compiler) definition(exp *Value) node {
+	name := definition_variable(exp)
+	value := definition_value(exp)
+
+	var n node
+	if isName(name) && test(is_lambda(value)) && c.in.special_form(value) != nil {
+		n = &lambdaNode{form: c.form(value), function: c.lambda(value, name.val.(string))}
+	} else if isName(name) {
+		n = c.element(cddr(exp))
+	} else {
+		n = c.compile(value)
+	}
+
+	if c.scope == nil {
+		return &assignmentNode{form: c.form(exp), variable: &variable{name: name}, value: n, define: true}
+	}
+
+	v := c.conditional_variable(name)
+	return &assignmentNode{form: c.form(exp), variable: v, value: n, define: true}
+}
+
+func (c *compiler) conditional_variable(name *Value) *variable {
+	for _, v := range c.scope.defines {
+		if v.name == name {
+			return v
+		}
+	}
+	shadows, _ := c.resolve(name, c.scope)
+	if shadows == nil {
+		shadows = &variable{name: name}
+	}
+	v := &variable{
+		name:        name,
+		fn:          c.fn,
+		conditional: true,
+		shadows:     c.use(shadows),
+	}
+	c.declare(v)
+	c.scope.defines = append(c.scope.defines, v)
+	return v
+}
+
+func (c *compiler) declare(v *variable) {
+	v.fn.variables = append(v.fn.variables, v)
+	c.scope.bindings = append(c.scope.bindings, blockBinding{name: v.name, variable: v})
+}
+
+// the form of a body is empty, only a begin is an expression evaluated
+func (c *compiler) sequence(f form, exps *Value) node {
+	n := &sequenceNode{form: f}
+	for ; isPair(exps); exps = cdr(exps) {
+		n.nodes = append(n.nodes, c.element(exps))
+	}
+	if len(n.nodes) == 0 {
+		// an empty body or begin, the evaluator takes the first
+		// expression of the empty list
+		car(exps)
+	}
+	return n
+}
+
+// a lambda expression of the top level is compiled when its
+// procedure is first called
+func (c *compiler) lambda(exp *Value, name string) *function {
+	if c.scope == nil {
+		fn := &function{name: name, exp: exp}
+		fn.proto = lazy_prototype(exp, name)
+		return fn
+	}
+	return c.function(exp, name)
+}
+
+func lazy_prototype(exp *Value, name string) *Prototype {
+	return &Prototype{
+		name:       name,
+		exp:        exp,
+		parameters: lambda_parameters(exp),
+	}
+}
+
+func (c *compiler) function(exp *Value, name string) *function {
+	fn := &function{name: name, exp: exp, parent: c.fn}
+	saved_fn, saved_scope := c.fn, c.scope
+	c.fn = fn
+	c.scope = &block{fn: fn, parent: saved_scope}
+	defer func() {
+		c.fn, c.scope = saved_fn, saved_scope
+	}()
+
+	params := lambda_parameters(exp)
+	for ; isPair(params); params = cdr(params) {
+		fn.params = append(fn.params, c.parameter(car(params)))
+	}
+	if !isNull(params) {
+		fn.params = append(fn.params, c.parameter(params))
+		fn.rest = true
+	}
+
+	fn.scope = c.scope
+	fn.body = c.sequence(form{}, scan_out_defines(lambda_body(exp)))
+	return fn
+}
+
+func (c *compiler) parameter(name *Value) *variable {
+	if !isName(name) {
+		raise_error(SyntaxError, "lambda: the parameter is not a name", name)
+	}
+	v := &variable{name: name, fn: c.fn}
+	c.declare(v)
+	return v
+}
+
+func (c *compiler) application(exp *Value) node {
+	op := operator(exp)
+	if isName(op) {
+		if _, macro := c.resolve(op, c.scope); macro != nil {
+			return c.derived(exp, c.in.expand_macro(macro, exp, c.where, c.bindings(c.scope)))
+		}
+	}
+	if test(is_lambda(op)) && c.in.special_form(op) != nil && is_inline_lambda(op, operands(exp)) {
+		return c.let(exp, op)
+	}
+
+	n := &applicationNode{form: c.form(exp), operator: c.element(exp)}
+	for operands := operands(exp); isPair(operands); operands = cdr(operands) {
+		n.operands = append(n.operands, c.element(operands))
+	}
+	return n
+}
+
+// a lambda expression applied to as many operands as it has
+// parameters, which are all names
+func is_inline_lambda(lambda *Value, operands *Value) bool {
+	if !isPair(cdr(lambda)) {
+		return false
+	}
+	params := lambda_parameters(lambda)
+	for ; isPair(params) && isPair(operands); params, operands = cdr(params), cdr(operands) {
+		if !isName(car(params)) {
+			return false
+		}
+	}
+	return isNull(params) && isNull(operands)
+}
+
+func (c *compiler) let(exp *Value, lambda *Value) node {
+	n := &letNode{form: c.form(exp), lambda: lambda.pos}
+	if lambda.pos != nil {
+		c.where = lambda.pos
+	}
+	for operands := operands(exp); isPair(operands); operands = cdr(operands) {
+		n.inits = append(n.inits, c.element(operands))
+	}
+
+	saved := c.scope
+	c.scope = &block{fn: c.fn, parent: saved}
+	defer func() {
+		c.scope = saved
+	}()
+	for params, i := lambda_parameters(lambda), 0; isPair(params); params, i = cdr(params), i+1 {
+		v := &variable{name: car(params), fn: c.fn}
+		if init, ok := n.inits[i].(*constantNode); ok && init.value == unassigned_value {
+			v.unassigned = true
+		}
+		c.declare(v)
+		n.variables = append(n.variables, v)
+	}
+	n.scope = c.scope
+	n.body = c.sequence(form{}, scan_out_defines(lambda_body(lambda)))
+	return n
+}
+
+// a macro defined at the top level is defined when the definition
+// runs, one defined in a body is made while the body is compiled
+func (c *compiler) define_macro(exp *Value, definition *Value) node {
+	if c.scope == nil {
+		return c.derived(exp, definition)
+	}
+	macro := c.in.evaluate_at_compile_time(definition_value(definition), c.where)
+	c.scope.bindings = append(c.scope.bindings, blockBinding{name: definition_variable(definition), macro: macro})
+	return &constantNode{form: c.form(exp), value: constant("ok")}
+}
+
+func (c *compiler) define_syntax(exp *Value) node {
+	keyword := define_syntax_keyword(exp)
+	macro := c.in.make_syntax_rules(keyword, define_syntax_transformer(exp), c.scope_environment(c.scope), c.bindings(c.scope))
+	if c.scope == nil {
+		return &assignmentNode{
+			form:     c.form(exp),
+			variable: &variable{name: keyword},
+			value:    &constantNode{form: c.form(keyword), value: macro},
+			define:   true,
+		}
+	}
+	c.scope.bindings = append(c.scope.bindings, blockBinding{name: keyword, macro: macro})
+	return &constantNode{form: c.form(exp), value: constant("ok")}
+}
+
+// the body of a let-syntax is not a body, its definitions are not
+// scanned out and define variables of the scope of the macros
+func (c *compiler) let_syntax(exp *Value, recursive bool) node {
+	s := &block{fn: c.fn, parent: c.scope}
+	closed := c.scope
+	if recursive {
+		closed = s
+	}
+	env := c.scope_environment(closed)
+	for bindings := syntax_bindings(exp); isPair(bindings); bindings = cdr(bindings) {
+		binding := car(bindings)
+		macro := c.in.make_syntax_rules(car(binding), cadr(binding), env, c.bindings(closed))
+		s.bindings = append(s.bindings, blockBinding{name: car(binding), macro: macro})
+	}
+
+	saved := c.scope
+	c.scope = s
+	defer func() {
+		c.scope = saved
+	}()
+	n := &letNode{form: c.form(exp), scope: s}
+	n.body = c.sequence(form{}, syntax_body(exp))
+	return n
+}
+
+// the environment that the syntax-rules macros defined in scope s are
+// closed in. It is a frame of its own for every scope, the aliases of
+// their expansions are resolved in the scope
+func (c *compiler) scope_environment(s *block) *Value {
+	if s == nil {
+		return c.in.global
+	}
+	if s.env == nil {
+		global := first_frame(c.in.global)
+		s.env = make_environment(&Frame{enclosing: c.in.global, global: global})
+		c.scopes[s.env] = s
+	}
+	return s.env
+}
+
+// the macro definitions of the top level are evaluated by Disassemble
+func is_macro_definition(exp *Value) bool {
+	if !isPair(exp) || !isName(car(exp)) {
+		return false
+	}
+	switch original_name(car(exp)).val.(string) {
+	case "define-macro", "defmacro", "define-syntax":
+		return true
+	}
+	return false
+}
@@ -1,9 +1,9 @@
-package main
+package scm
 
 import (
 	"fmt"
 	"io"
-	"os"
+	"math/big"
 	"strings"
 )
 
@@ -14,16 +14,31 @@ const (
 	Float
 	Boolean
 	String
-	Symbol
 	Name
 	PairValue
 	Null
 	Function
+	ErrorObject
+	Character
+	BigInteger
+	Rational
+	Continuation
+	Environment
+	Address
+	Analyzed
+	Closure
+	Machine
+	MachineProcedure
+	CompiledProcedure
 )
 
 type Value struct {
 	kind ValueKind
 	val  interface{}
+	// where the value was read, only set for datums read from a source
+	pos *Position
+	// the syntactic context of a name introduced by a macro expansion
+	ctx *SyntacticContext
 }
 
 type Node struct {
@@ -34,6 +49,9 @@ type Node struct {
 type Pair struct {
 	first  *Value
 	second *Value
+	// where the car was read, names are interned and carry
+	// no position of their own
+	car_pos *Position
 }
 
 var nullValue *Value = &Value{
@@ -52,8 +70,6 @@ func (vk ValueKind) String() string {
 		kind = "Boolean"
 	case String:
 		kind = "String"
-	case Symbol:
-		kind = "Symbol"
 	case Name:
 		kind = "Name"
 	case PairValue:
@@ -62,6 +78,30 @@ func (vk ValueKind) String() string {
 		kind = "Function"
 	case Null:
 		kind = "Null"
+	case ErrorObject:
+		kind = "ErrorObject"
+	case Character:
+		kind = "Character"
+	case BigInteger:
+		kind = "BigInteger"
+	case Rational:
+		kind = "Rational"
+	case Continuation:
+		kind = "Continuation"
+	case Environment:
+		kind = "Environment"
+	case Address:
+		kind = "Address"
+	case Analyzed:
+		kind = "Analyzed"
+	case Closure:
+		kind = "Closure"
+	case Machine:
+		kind = "Machine"
+	case MachineProcedure:
+		kind = "MachineProcedure"
+	case CompiledProcedure:
+		kind = "CompiledProcedure"
 	}
 
 	return kind
@@ -72,32 +112,50 @@ func (v *Value) String() string {
 	case Integer:
 		return fmt.Sprintf("%d", v.val)
 	case Float:
-		return fmt.Sprintf("%f", v.val)
+		return formatFloat(v.val.(float64))
 	case Boolean:
-		return fmt.Sprintf("%v", v.val)
+		if v.val.(bool) {
+			return "#t"
+		}
+		return "#f"
 	case String:
 		return fmt.Sprintf("\"%s\"", v.val)
-	case Symbol:
-		return fmt.Sprintf("'%s", v.val)
 	case Name:
 		return fmt.Sprintf("%s", v.val)
 	case Function:
 		return fmt.Sprintf("%v", v.val)
 	case PairValue:
-		p := v.val.(*Pair)
-		f := p.first
-		s := p.second
-		if isPair(f) == false && isPair(s) == false {
-			return fmt.Sprintf("(%s . %s)", f, s)
-		} else {
-			var w strings.Builder
-			printList(&w, v)
-			return fmt.Sprintf("%s", w.String())
-		}
+		var w strings.Builder
+		printList(&w, v)
+		return w.String()
 	case Null:
-		return fmt.Sprintf("<null>")
+		return "()"
+	case ErrorObject:
+		return fmt.Sprintf("<error: %s>", v.val.(*SchemeError))
+	case Character:
+		return characterName(v.val.(rune))
+	case BigInteger:
+		return v.val.(*big.Int).String()
+	case Rational:
+		return v.val.(*big.Rat).String()
+	case Continuation:
+		return "#<continuation>"
+	case Environment:
+		return "#<environment>"
+	case Address:
+		return v.val.(*LexicalAddress).name.String()
+	case Analyzed:
+		return v.val.(*Analysis).exp.String()
+	case Closure:
+		return closure_name(v)
+	case Machine:
+		return "#<machine>"
+	case MachineProcedure:
+		return fmt.Sprintf("#<procedure %s>", machine_procedure_name(v))
+	case CompiledProcedure:
+		return "#<compiled-procedure>"
 	default:
-		panic(fmt.Sprintf("invalid value %v", v))
+		panic(fmt.Sprintf("invalid value of kind %s", v.kind))
 	}
 }
 
@@ -111,18 +169,35 @@ func cons(f *Value, s *Value) *Value {
 	}
 }
 
+// the position where the car of pair was read, nil when it was not
+// read or pair is not a pair
+func car_position(pair *Value) *Position {
+	if p, ok := pair.val.(*Pair); ok && pair.kind == PairValue {
+		return p.car_pos
+	}
+	return nil
+}
+
+// a new pair of the car of pair and rest, the car keeps the position
+// where it was read when a derived expression moves it to a new list
+func cons_car(pair *Value, rest *Value) *Value {
+	v := cons(car(pair), rest)
+	v.val.(*Pair).car_pos = car_position(pair)
+	return v
+}
+
 func car(v *Value) *Value {
 	if v == nil {
 		panic("car: value is nil")
 	}
 
 	if v.kind != PairValue {
-		panic("car: value is not a pair")
+		raise_error(WrongTypeError, "car: value is not a pair", v)
 	}
 
 	p, ok := v.val.(*Pair)
 	if !ok {
-		panic("car: value is not a proper pair")
+		raise_error(WrongTypeError, "car: value is not a proper pair", v)
 	}
 
 	return p.first
@@ -131,7 +206,7 @@ func car(v *Value) *Value {
 func setCar(p *Value, val *Value) {
 	pair, ok := p.val.(*Pair)
 	if !ok {
-		panic("setCar: p is not a pair")
+		raise_error(WrongTypeError, "setCar: p is not a pair", p)
 	}
 	set(&pair.first, val)
 }
@@ -142,12 +217,12 @@ func cdr(v *Value) *Value {
 	}
 
 	if v.kind != PairValue {
-		panic("cdr: value is not a pair")
+		raise_error(WrongTypeError, "cdr: value is not a pair", v)
 	}
 
 	p, ok := v.val.(*Pair)
 	if !ok {
-		panic("cdr: value is not a proper pair")
+		raise_error(WrongTypeError, "cdr: value is not a proper pair", v)
 	}
 
 	return p.second
@@ -156,7 +231,7 @@ func cdr(v *Value) *Value {
 func setCdr(p *Value, val *Value) {
 	pair, ok := p.val.(*Pair)
 	if !ok {
-		panic("setCar: p is not a pair")
+		raise_error(WrongTypeError, "setCdr: p is not a pair", p)
 	}
 	set(&pair.second, val)
 }
@@ -208,7 +283,7 @@ func set(dest **Value, src *Value) {
 
 func printList(output io.Writer, v *Value) {
 	if v.kind == Null {
-		fmt.Fprintf(output, "()\n")
+		fmt.Fprintf(output, "()")
 		return
 	}
 
@@ -228,6 +303,11 @@ func printList(output io.Writer, v *Value) {
 		fmt.Fprintf(output, "%v", current.val.(*Pair).first)
 		current = current.val.(*Pair).second
 
+		if current.kind != Null && current.kind != PairValue {
+			// an improper list
+			fmt.Fprintf(output, " . %v", current)
+			break
+		}
 		if current.kind != Null {
 			fmt.Fprintf(output, " ")
 		}
@@ -264,7 +344,8 @@ func isNumber(v *Value) bool {
 	if v == nil {
 		panic("not a value")
 	}
-	if v.kind == Integer || v.kind == Float {
+	switch v.kind {
+	case Integer, BigInteger, Rational, Float:
 		return true
 	}
 	return false
@@ -280,16 +361,6 @@ func isString(v *Value) bool {
 	return false
 }
 
-func isSymbol(v *Value) bool {
-	if v == nil {
-		panic("not a value")
-	}
-	if v.kind == Symbol {
-		return true
-	}
-	return false
-}
-
 func isName(v *Value) bool {
 	if v == nil {
 		panic("not a value")
@@ -320,27 +391,50 @@ func isEqual(v1 *Value, v2 *Value) bool {
 		return v1.val.(bool) == v2.val.(bool)
 	case String:
 		return v1.val.(string) == v2.val.(string)
-	case Symbol:
-		return v1.val.(string) == v2.val.(string)
 	case Name:
-		return v1.val.(string) == v2.val.(string)
+		// names are interned, aliases and uninterned
+		// names are only equal to themselves
+		return v1 == v2
 	case PairValue:
-		panic("cannot compare pairs")
+		return v1.val.(*Pair) == v2.val.(*Pair)
 	case Function:
 		return true
 	case Null:
 		return true
+	case ErrorObject:
+		return v1.val.(*SchemeError) == v2.val.(*SchemeError)
+	case Character:
+		return v1.val.(rune) == v2.val.(rune)
+	case BigInteger:
+		return v1.val.(*big.Int).Cmp(v2.val.(*big.Int)) == 0
+	case Rational:
+		return v1.val.(*big.Rat).Cmp(v2.val.(*big.Rat)) == 0
+	case Continuation:
+		return v1.val == v2.val
+	case Environment:
+		return v1.val.(*Frame) == v2.val.(*Frame)
+	case Address, Analyzed:
+		return v1 == v2
+	case Closure:
+		return v1.val.(*closure) == v2.val.(*closure)
+	case Machine:
+		return v1.val.(*registerMachine) == v2.val.(*registerMachine)
+	case MachineProcedure:
+		return v1.val.(*machineProcedure) == v2.val.(*machineProcedure)
+	case CompiledProcedure:
+		return v1.val.(*compiledProcedure) == v2.val.(*compiledProcedure)
 	}
 
 	panic("unreachable")
 }
 
+// every value but #f counts as true
 func isTrue(v *Value) bool {
 	if v == nil {
 		panic("not a value")
 	}
 	if v.kind != Boolean {
-		panic("not a Boolean")
+		return true
 	}
 	return v.val.(bool)
 }
@@ -359,7 +453,7 @@ func not(b *Value) bool {
 		panic("not a value")
 	}
 	if b.kind != Boolean {
-		panic("not a Boolean")
+		raise_error(WrongTypeError, "not a Boolean", b)
 	}
 	val := b.val.(bool)
 	return !val
@@ -393,222 +487,68 @@ func cdadr(v *Value) *Value {
 	return cdr(car(cdr(v)))
 }
 
-func gt(args *Value) *Value {
-	v1 := car(args)
-	v2 := cadr(args)
-	var a float64
-	var b float64
-
-	if v1.kind == Integer {
-		a = float64(v1.val.(int64))
-	} else if v1.kind == Float {
-		a = v1.val.(float64)
-	} else {
-		panic(fmt.Sprintf("comparison is only allowed on numbers %s", v1))
-	}
-
-	if v2.kind == Integer {
-		b = float64(v2.val.(int64))
-	} else if v2.kind == Float {
-		b = v2.val.(float64)
-	} else {
-		panic(fmt.Sprintf("comparison is only allowed on numbers %s", v2))
-	}
-
-	if a > b {
-		return make_true()
-	}
-	return make_false()
-}
-
-func lt(args *Value) *Value {
-	v1 := car(args)
-	v2 := cadr(args)
-	var a float64
-	var b float64
-
-	if v1.kind == Integer {
-		a = float64(v1.val.(int64))
-	} else if v1.kind == Float {
-		a = v1.val.(float64)
-	} else {
-		panic(fmt.Sprintf("comparison is only allowed on numbers %s", v1))
-	}
-
-	if v2.kind == Integer {
-		b = float64(v2.val.(int64))
-	} else if v2.kind == Float {
-		b = v2.val.(float64)
-	} else {
-		panic(fmt.Sprintf("comparison is only allowed on numbers %s", v2))
-	}
-
-	if a < b {
+func eq(args *Value) *Value {
+	if isEqual(car(args), cadr(args)) {
 		return make_true()
 	}
 	return make_false()
 }
 
-func or(args *Value) *Value {
-	var res bool
-
-	for {
-		if isNull(args) {
-			break
-		}
-		v := car(args)
-		if isTrue(v) {
-			res = true
-			break
+// the first sublist of the list whose car is eqv? to obj
+func memv(args *Value) *Value {
+	obj := car(args)
+	for l := cadr(args); isPair(l); l = cdr(l) {
+		if isEqual(obj, car(l)) {
+			return l
 		}
-		args = cdr(args)
-	}
-	if res {
-		return make_true()
 	}
 	return make_false()
 }
 
-func and(args *Value) *Value {
-	res := true
-
-	for {
-		if isNull(args) {
-			break
-		}
-		v := car(args)
-		if !isTrue(v) {
-			res = false
-			break
-		}
-		args = cdr(args)
-	}
-	if res {
-		return make_true()
-	}
-	return make_false()
+func _cons(args *Value) *Value {
+	return cons(car(args), cadr(args))
 }
 
-func eq(args *Value) *Value {
-	v1 := car(args)
-	v2 := cadr(args)
-
-	if isNumber(v1) && isNumber(v2) {
-		var a float64
-		var b float64
-
-		if v1.kind == Integer {
-			a = float64(v1.val.(int64))
-		} else {
-			a = v1.val.(float64)
-		}
-		if v2.kind == Integer {
-			b = float64(v2.val.(int64))
-		} else {
-			b = v2.val.(float64)
-		}
-
-		if a == b {
-			return make_true()
-		}
-		return make_false()
-	}
-
-	if isEqual(v1, v2) {
-		return make_true()
-	}
-	return make_false()
+func _car(args *Value) *Value {
+	return car(car(args))
 }
 
-func plus(args *Value) *Value {
-	var sum float64
-
-	for {
-		if isNull(args) {
-			break
-		}
-		v := car(args)
-		if v.kind == Integer {
-			sum += float64(v.val.(int64))
-		} else if v.kind == Float {
-			sum += v.val.(float64)
-		}
-
-		args = cdr(args)
-	}
-
-	return &Value{
-		kind: Float,
-		val:  sum,
-	}
+func _cdr(args *Value) *Value {
+	return cdr(car(args))
 }
 
-func minus(args *Value) *Value {
-	var res float64
-	first := car(args)
-	if first.kind == Integer {
-		res = float64(first.val.(int64))
-	} else {
-		res = first.val.(float64)
-	}
-
-	args = cdr(args)
-
-	for {
-		if isNull(args) {
-			break
-		}
-		v := car(args)
-		if v.kind == Integer {
-			res -= float64(v.val.(int64))
-		} else if v.kind == Float {
-			res -= v.val.(float64)
-		}
-
-		args = cdr(args)
-	}
-
-	return &Value{
-		kind: Float,
-		val:  res,
-	}
+func _list(args *Value) *Value {
+	return args
 }
 
-func mul(args *Value) *Value {
-	var res float64 = 1.0
-
-	for {
-		if isNull(args) {
-			break
-		}
-		v := car(args)
-		if v.kind == Integer {
-			res *= float64(v.val.(int64))
-		} else if v.kind == Float {
-			res *= v.val.(float64)
-		}
-
-		args = cdr(args)
+// append copies every list but the last one, which is shared
+func _append(args *Value) *Value {
+	if isNull(args) {
+		return nullValue
+	}
+	if isNull(cdr(args)) {
+		return car(args)
 	}
 
-	return &Value{
-		kind: Float,
-		val:  res,
+	first := car(args)
+	if !isNull(first) && !isPair(first) {
+		raise_error(WrongTypeError, "append: not a list", first)
 	}
+	return listAppend(first, _append(cdr(args)))
 }
 
-func display(args *Value) *Value {
+func (in *Interpreter) display(args *Value) *Value {
 	var v *Value
 	if isPair(args) {
 		v = car(args)
 	} else {
 		v = args
 	}
-	fmt.Fprintf(os.Stdout, "%s", v)
+	fmt.Fprintf(in.out, "%s", v)
 	return constant("ok")
 }
 
-func newline(args *Value) *Value {
-	fmt.Println()
+func (in *Interpreter) newline(args *Value) *Value {
+	fmt.Fprintln(in.out)
 	return constant("ok")
 }
@@ -0,0 +1,72 @@
+package scm
+
+import (
+	"strings"
+	"testing"
+)
+
+func listString(v *Value) string {
+	var b strings.Builder
+	printList(&b, v)
+	return b.String()
+}
+
+func TestLists(t *testing.T) {
+	one, two, three := make_integer(1), make_integer(2), make_integer(3)
+
+	p := cons(one, two)
+	if car(p) != one || cdr(p) != two {
+		t.Errorf("cons: got %s", p)
+	}
+
+	tests := []struct {
+		list *Value
+		want string
+	}{
+		{list(one, two), "(1 2)"},
+		{cons(one, cons(two, cons(three, nullValue))), "(1 2 3)"},
+		{list(), "()"},
+		{listAppend(list(one, two), list(three)), "(1 2 3)"},
+	}
+	for _, test := range tests {
+		if got := listString(test.list); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		list *Value
+		want int
+	}{
+		{list(), 0},
+		{list(three), 1},
+		{listAppend(list(one, two), list(three)), 3},
+	} {
+		if got := listLen(test.list); got != test.want {
+			t.Errorf("listLen %s: got %d, want %d", test.list, got, test.want)
+		}
+	}
+
+	plusOne := func(v *Value) *Value {
+		return make_integer(v.val.(int64) + 1)
+	}
+	if got := listString(_map(plusOne, list(one, two, three))); got != "(2 3 4)" {
+		t.Errorf("_map: got %s, want (2 3 4)", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	one, x := make_integer(1), make_integer(55)
+	p := cons(one, make_integer(2))
+
+	v := one
+	set(&v, x)
+	if v != x {
+		t.Errorf("set: got %s, want 55", v)
+	}
+
+	setCar(p, x)
+	if got := p.String(); got != "(55 . 2)" {
+		t.Errorf("setCar: got %s, want (55 . 2)", got)
+	}
+}
@@ -0,0 +1,349 @@
+package scm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A debugger for the explicit-control evaluator. The machine asks the
+// debugger before it runs every label, the debugger stops it at the
+// breakpoints, after a step and when an error is about to end the
+// program, then reads commands until one of them lets the machine go
+// on. A breakpoint is set on the expressions of a source line, on a
+// label of the machine or on the procedure bound to a name.
+type debugger struct {
+	in       *Interpreter
+	file     string
+	commands *bufio.Scanner
+	names    labelNames
+
+	// the machine stops before the first expression
+	started bool
+	// stop at the next expression dispatched, or at the next label
+	stepping       bool
+	stepping_label bool
+
+	breakpoints []*breakpoint
+	last_id     int
+}
+
+type breakpoint struct {
+	id   int
+	kind string // line, label or procedure
+	file string
+	line int
+	name string
+}
+
+func (b *breakpoint) String() string {
+	switch b.kind {
+	case "line":
+		return fmt.Sprintf("line %s:%d", b.file, b.line)
+	case "label":
+		return "label " + b.name
+	}
+	return "procedure " + b.name
+}
+
+// the debugger stops the machine by panicking with quit,
+// Debug recovers it
+type quitDebugger struct{}
+
+const debuggerPrompt = "(debug) "
+
+const debuggerHelp = `step, s            run to the next expression dispatched
+stepi, si          run the next label of the machine
+continue, c        run to the next breakpoint
+break LINE         stop at the expressions on line LINE of the file
+break FILE:LINE    stop at the expressions on line LINE of FILE
+break label NAME   stop at the label NAME, like ev-application
+break NAME         stop when the procedure bound to NAME is applied
+delete N           delete the breakpoint N
+breakpoints        list the breakpoints
+registers, r       print the registers
+stack              print the stack, the top first
+frames, env        print the frames of the environment in env
+where              print where the machine stopped
+help               print the commands
+quit, q            stop the program
+`
+
+// Debug evaluates every expression in the file named filename with the
+// explicit-control evaluator, under a debugger that reads its commands
+// from commands and writes to the output of the interpreter. The
+// machine stops before the first expression, the help command lists
+// the others. The analyzing evaluator, the compiler and the virtual
+// machine are not used while debugging.
+func (in *Interpreter) Debug(filename string, commands io.Reader) (err error) {
+	d := &debugger{
+		in:       in,
+		file:     filename,
+		commands: bufio.NewScanner(commands),
+		names:    labelNames{},
+	}
+
+	analyzing, bytecode, compiled := in.analyzing, in.bytecode, in.compiled
+	in.analyzing, in.bytecode, in.compiled = false, false, false
+	in.debugger = d
+	defer func() {
+		in.analyzing, in.bytecode, in.compiled = analyzing, bytecode, compiled
+		in.debugger = nil
+		if r := recover(); r != nil {
+			if _, ok := r.(quitDebugger); !ok {
+				panic(r)
+			}
+			err = nil
+		}
+	}()
+
+	if _, err := in.EvalFile(filename); err != nil {
+		return err
+	}
+	fmt.Fprintln(in.out, "program finished")
+	return nil
+}
+
+// called by the machine before it runs the label f
+func (d *debugger) before(f func()) {
+	name := d.names.of(f)
+	reason := ""
+	switch {
+	case !d.started && name == "eval-dispatch":
+		d.started = true
+		reason = "start"
+	case d.stepping_label:
+		reason = "step"
+	case d.stepping && name == "eval-dispatch":
+		reason = "step"
+	case name == "uncaught-exception":
+		if obj := reg(d.in.val); isErrorObject(obj) {
+			reason = fmt.Sprintf("error %s", obj.val.(*SchemeError))
+		} else {
+			reason = fmt.Sprintf("uncaught exception %s", describe(obj))
+		}
+	default:
+		if b := d.breakpoint(name); b != nil {
+			reason = fmt.Sprintf("breakpoint %d, %s", b.id, b)
+		}
+	}
+	if reason == "" {
+		return
+	}
+
+	d.stepping = false
+	d.stepping_label = false
+	fmt.Fprintf(d.in.out, "%s\n", reason)
+	d.where(name)
+	d.read_commands(name)
+}
+
+// the first breakpoint where the machine is about to run the label name
+func (d *debugger) breakpoint(name string) *breakpoint {
+	in := d.in
+	for _, b := range d.breakpoints {
+		switch b.kind {
+		case "line":
+			// stop once when the line is reached, not at
+			// every expression in it
+			if name != "eval-dispatch" || reg(in.exp) == nil {
+				continue
+			}
+			pos := reg(in.exp).pos
+			if pos == nil || pos.Line != b.line || pos.File != b.file {
+				continue
+			}
+			if in.where != nil && in.where.Line == b.line && in.where.File == b.file {
+				continue
+			}
+			return b
+		case "label":
+			if name == b.name {
+				return b
+			}
+		case "procedure":
+			if name != "compound-apply" {
+				continue
+			}
+			frame, i := find_binding(make_name(b.name), reg(in.env))
+			if frame != nil && frame.vals[i] == reg(in.proc) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+func (d *debugger) read_commands(label string) {
+	w := d.in.out
+	for {
+		fmt.Fprint(w, debuggerPrompt)
+		if !d.commands.Scan() {
+			fmt.Fprintln(w)
+			panic(quitDebugger{})
+		}
+		fields := strings.Fields(d.commands.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "step", "s":
+			d.stepping = true
+			return
+		case "stepi", "si":
+			d.stepping_label = true
+			return
+		case "continue", "c":
+			return
+		case "break", "b":
+			d.set_breakpoint(fields[1:])
+		case "delete", "d":
+			d.delete_breakpoint(fields[1:])
+		case "breakpoints":
+			if len(d.breakpoints) == 0 {
+				fmt.Fprintln(w, "no breakpoints")
+			}
+			for _, b := range d.breakpoints {
+				fmt.Fprintf(w, "%d: %s\n", b.id, b)
+			}
+		case "registers", "r":
+			d.registers()
+		case "stack":
+			d.stack()
+		case "frames", "env":
+			d.frames()
+		case "where":
+			d.where(label)
+		case "help", "h":
+			fmt.Fprint(w, debuggerHelp)
+		case "quit", "q":
+			panic(quitDebugger{})
+		default:
+			fmt.Fprintf(w, "unknown command %s, type help for the commands\n", fields[0])
+		}
+	}
+}
+
+func (d *debugger) set_breakpoint(args []string) {
+	b := &breakpoint{}
+	switch {
+	case len(args) == 2 && args[0] == "label":
+		b.kind = "label"
+		b.name = strings.ReplaceAll(args[1], "_", "-")
+	case len(args) == 1:
+		file, line := d.file, args[0]
+		if i := strings.LastIndex(args[0], ":"); i >= 0 {
+			file, line = args[0][:i], args[0][i+1:]
+		}
+		if n, err := strconv.Atoi(line); err == nil {
+			b.kind = "line"
+			b.file = file
+			b.line = n
+		} else {
+			b.kind = "procedure"
+			b.name = args[0]
+		}
+	default:
+		fmt.Fprintln(d.in.out, "usage: break LINE, break FILE:LINE, break label NAME or break NAME")
+		return
+	}
+
+	d.last_id++
+	b.id = d.last_id
+	d.breakpoints = append(d.breakpoints, b)
+	fmt.Fprintf(d.in.out, "breakpoint %d, %s\n", b.id, b)
+}
+
+func (d *debugger) delete_breakpoint(args []string) {
+	if len(args) == 1 {
+		id, _ := strconv.Atoi(args[0])
+		for i, b := range d.breakpoints {
+			if b.id == id {
+				d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+				return
+			}
+		}
+	}
+	fmt.Fprintln(d.in.out, "usage: delete N, where N is a breakpoint")
+}
+
+// the label about to run and the expression in the exp register
+func (d *debugger) where(label string) {
+	in := d.in
+	pos := in.where
+	if exp := reg(in.exp); exp != nil && exp.pos != nil {
+		pos = exp.pos
+	}
+	if pos != nil {
+		fmt.Fprintf(in.out, "  at %s in %s\n", pos, label)
+	} else {
+		fmt.Fprintf(in.out, "  in %s\n", label)
+	}
+	fmt.Fprintf(in.out, "  %s\n", describe(reg(in.exp)))
+}
+
+func (d *debugger) registers() {
+	in := d.in
+	for _, r := range []*Register{in.exp, in.env, in.val, in.argl, in.proc, in.unev, in.cont} {
+		fmt.Fprintf(in.out, "%s\n", r)
+	}
+}
+
+func (d *debugger) stack() {
+	in := d.in
+	if in.stack.items.Len() == 0 {
+		fmt.Fprintln(in.out, "the stack is empty")
+	}
+	for e := in.stack.items.Front(); e != nil; e = e.Next() {
+		fmt.Fprintf(in.out, "%s\n", describe(e.Value.(*Value)))
+	}
+}
+
+// the frames of the environment in env, the innermost first
+func (d *debugger) frames() {
+	in := d.in
+	env := reg(in.env)
+	if env == nil || env.kind != Environment {
+		fmt.Fprintln(in.out, "no environment")
+		return
+	}
+	for n := 0; env != the_empty_environment; env, n = enclosing_environment(env), n+1 {
+		frame := first_frame(env)
+		if is_global_frame(frame) {
+			fmt.Fprintf(in.out, "frame %d: the global environment\n", n)
+			continue
+		}
+		fmt.Fprintf(in.out, "frame %d:", n)
+		vals := frame_values(frame)
+		for i, v := range frame_variables(frame) {
+			fmt.Fprintf(in.out, " %s = %s", v, describe(vals[i]))
+		}
+		fmt.Fprintln(in.out)
+	}
+}
+
+// a value as the debugger prints it, labels by their name and
+// compound procedures without their body and environment
+func describe(v *Value) string {
+	switch {
+	case v == nil:
+		return "*unassigned*"
+	case v == unassigned_value:
+		return "*unassigned*"
+	case v.kind == Function:
+		if f, ok := v.val.(func()); ok {
+			return fmt.Sprintf("#<label %s>", label_name(reflect.ValueOf(f).Pointer()))
+		}
+	case test(is_compound_procedure(v)):
+		return fmt.Sprintf("#<procedure %s>", procedure_parameters(v))
+	case test(is_compiled_procedure(v)):
+		return "#<compiled-procedure>"
+	case test(is_primitive_procedure(v)):
+		return "#<primitive>"
+	}
+	return v.String()
+}
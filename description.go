@@ -0,0 +1,303 @@
+package scm
+
+import (
+	"io"
+	"os"
+)
+
+// A machine description is a file that holds a register machine as
+// data, (machine (registers name ...) (controller instruction ...)).
+// Its instructions apply the operations of the evaluator of SICP 5.4,
+// so the controller of an evaluator can be described in Scheme and run
+// programs in the global environment of the interpreter.
+
+// RunMachine assembles the machine described in the file named
+// description and evaluates every expression in the file named
+// filename with it. Each expression is placed in the exp register and
+// the global environment in the env register before the machine
+// starts, the machine leaves the value in the val register.
+func (in *Interpreter) RunMachine(description string, filename string) error {
+	text, err := read_description(description)
+	if err != nil {
+		return err
+	}
+
+	var m *registerMachine
+	if err := catch_error(func() {
+		m = in.make_described_machine(text)
+	}); err != nil {
+		if err.Pos == nil {
+			err.Pos = text.pos
+		}
+		return err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := newReader(file, filename)
+	for {
+		datum, err := reader.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := m.evaluate(datum, in.global); err != nil {
+			return err
+		}
+	}
+}
+
+func read_description(filename string) (*Value, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	text, err := newReader(file, filename).read()
+	if err == io.EOF {
+		return nil, newError(SyntaxError, "empty machine description")
+	}
+	return text, err
+}
+
+// run f and return the error it signals
+func catch_error(f func()) (err *SchemeError) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
+		}
+	}()
+
+	f()
+	return nil
+}
+
+func (in *Interpreter) make_described_machine(text *Value) *registerMachine {
+	if !is_tagged_list(text, "machine") {
+		raise_error(SyntaxError, "not a machine description", text)
+	}
+	var registers, controller *Value
+	for clauses := cdr(text); isPair(clauses); clauses = cdr(clauses) {
+		switch {
+		case is_tagged_list(car(clauses), "registers"):
+			registers = cdr(car(clauses))
+		case is_tagged_list(car(clauses), "controller"):
+			controller = cdr(car(clauses))
+		default:
+			raise_error(SyntaxError, "machine: unknown clause", car(clauses))
+		}
+	}
+	if controller == nil {
+		raise_error(SyntaxError, "machine: no controller", text)
+	}
+
+	m := make_register_machine(registers, evaluator_operations())
+	m.install_controller(controller)
+	m.get_register(make_name("exp"))
+	m.get_register(make_name("env"))
+	return m
+}
+
+// evaluate exp in env with a machine that has exp, env and val
+// registers, errors report the position of the last expression
+// in the exp register
+func (m *registerMachine) evaluate(exp *Value, env *Value) error {
+	exp_register := m.get_register(make_name("exp"))
+	assign(exp_register, exp)
+	assign(m.get_register(make_name("env")), env)
+	m.stack = newStack()
+
+	err := catch_error(m.start)
+	if err != nil && err.Pos == nil {
+		if pos := reg(exp_register).pos; pos != nil {
+			err.Pos = pos
+		} else {
+			err.Pos = exp.pos
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func truth(b bool) *Value {
+	if b {
+		return make_true()
+	}
+	return make_false()
+}
+
+// the operations of the evaluator of SICP 5.4, named as in the book
+func evaluator_operations() map[string]func(args []*Value) *Value {
+	return map[string]func(args []*Value) *Value{
+		"self-evaluating?": func(args []*Value) *Value {
+			return is_self_evaluating(args[0])
+		},
+		"variable?": func(args []*Value) *Value {
+			return is_variable(args[0])
+		},
+		"quoted?": func(args []*Value) *Value {
+			return is_quoted(args[0])
+		},
+		"text-of-quotation": func(args []*Value) *Value {
+			return text_of_quotation(args[0])
+		},
+		"assignment?": func(args []*Value) *Value {
+			return is_assignment(args[0])
+		},
+		"assignment-variable": func(args []*Value) *Value {
+			return assignment_variable(args[0])
+		},
+		"assignment-value": func(args []*Value) *Value {
+			return assignment_value(args[0])
+		},
+		"definition?": func(args []*Value) *Value {
+			return is_definition(args[0])
+		},
+		"definition-variable": func(args []*Value) *Value {
+			return definition_variable(args[0])
+		},
+		"definition-value": func(args []*Value) *Value {
+			return definition_value(args[0])
+		},
+		"if?": func(args []*Value) *Value {
+			return is_if(args[0])
+		},
+		"if-predicate": func(args []*Value) *Value {
+			return if_predicate(args[0])
+		},
+		"if-consequent": func(args []*Value) *Value {
+			return if_consequent(args[0])
+		},
+		"if-alternative": func(args []*Value) *Value {
+			return if_alternative(args[0])
+		},
+		"lambda?": func(args []*Value) *Value {
+			return is_lambda(args[0])
+		},
+		"lambda-parameters": func(args []*Value) *Value {
+			return lambda_parameters(args[0])
+		},
+		"lambda-body": func(args []*Value) *Value {
+			return lambda_body(args[0])
+		},
+		"begin?": func(args []*Value) *Value {
+			return is_begin(args[0])
+		},
+		"begin-actions": func(args []*Value) *Value {
+			return begin_actions(args[0])
+		},
+		"first-exp": func(args []*Value) *Value {
+			return first_exp(args[0])
+		},
+		"last-exp?": func(args []*Value) *Value {
+			return is_last_exp(args[0])
+		},
+		"rest-exps": func(args []*Value) *Value {
+			return rest_exps(args[0])
+		},
+		"cond?": func(args []*Value) *Value {
+			return truth(is_cond(args[0]))
+		},
+		"cond->if": func(args []*Value) *Value {
+			return cond_to_if(args[0])
+		},
+		"let?": func(args []*Value) *Value {
+			return truth(is_let(args[0]))
+		},
+		"let->combination": func(args []*Value) *Value {
+			if is_named_let(args[0]) {
+				return named_let_to_combination(args[0])
+			}
+			return let_to_combination(args[0])
+		},
+		"application?": func(args []*Value) *Value {
+			return is_application(args[0])
+		},
+		"operator": func(args []*Value) *Value {
+			return operator(args[0])
+		},
+		"operands": func(args []*Value) *Value {
+			return operands(args[0])
+		},
+		"no-operands?": func(args []*Value) *Value {
+			return has_no_operands(args[0])
+		},
+		"first-operand": func(args []*Value) *Value {
+			return first_operand(args[0])
+		},
+		"rest-operands": func(args []*Value) *Value {
+			return rest_operands(args[0])
+		},
+		"last-operand?": func(args []*Value) *Value {
+			return is_last_operand(args[0])
+		},
+		"empty-arglist": func(args []*Value) *Value {
+			return empty_arglist()
+		},
+		"adjoin-arg": func(args []*Value) *Value {
+			return adjoin_arg(args[0], args[1])
+		},
+		"true?": func(args []*Value) *Value {
+			return is_true(args[0])
+		},
+		"lookup-variable-value": func(args []*Value) *Value {
+			return lookup_variable_value(args[0], args[1])
+		},
+		"set-variable-value!": func(args []*Value) *Value {
+			set_variable_value(args[0], args[1], args[2])
+			return nil
+		},
+		"define-variable!": func(args []*Value) *Value {
+			define_variable(args[0], args[1], args[2])
+			return nil
+		},
+		"make-procedure": func(args []*Value) *Value {
+			return make_procedure(args[0], args[1], args[2])
+		},
+		"primitive-procedure?": func(args []*Value) *Value {
+			return is_primitive_procedure(args[0])
+		},
+		"compound-procedure?": func(args []*Value) *Value {
+			return is_compound_procedure(args[0])
+		},
+		"apply-primitive-procedure": func(args []*Value) *Value {
+			return apply_primitive_procedure(args[0], args[1])
+		},
+		"procedure-parameters": func(args []*Value) *Value {
+			return procedure_parameters(args[0])
+		},
+		"procedure-environment": func(args []*Value) *Value {
+			return procedure_environment(args[0])
+		},
+		"procedure-body": func(args []*Value) *Value {
+			return procedure_body(args[0])
+		},
+		"extend-environment": func(args []*Value) *Value {
+			return extend_environment(args[0], args[1], args[2])
+		},
+		"unknown-expression-type": func(args []*Value) *Value {
+			panic(&SchemeError{
+				Kind:    UnknownExpError,
+				Message: "Unknown expression type",
+				Exp:     args[0],
+			})
+		},
+		"unknown-procedure-type": func(args []*Value) *Value {
+			raise_error(UnknownProcError, "Unknown procedure type", args[0])
+			return nil
+		},
+	}
+}
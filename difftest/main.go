@@ -0,0 +1,86 @@
+// Command difftest runs every program given through the explicit-control
+// evaluator and through the virtual machine, each in a fresh
+// interpreter, and compares what they print, including the error that
+// stops a program. A program the machine is known to run differently
+// has what the machine prints recorded in a .vm.out file next to it,
+// the machine must print that instead. It reports the first line where
+// the outputs differ and exits with a non-zero status when any program
+// differs:
+//
+//	go run ./difftest tests/*.scm bench/*.scm
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jonathantorres/scm"
+)
+
+func main() {
+	flag.Parse()
+
+	failed := 0
+	for _, filename := range flag.Args() {
+		want := run(filename, false)
+		if recorded, err := os.ReadFile(strings.TrimSuffix(filename, ".scm") + ".vm.out"); err == nil {
+			want = string(recorded)
+		}
+		got := run(filename, true)
+		if want == got {
+			fmt.Printf("ok   %s\n", filename)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s\n", filename)
+		line, w, g := difference(want, got)
+		fmt.Printf("  line %d\n  evaluator: %s\n  vm:        %s\n", line, w, g)
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d programs differ\n", failed, flag.NArg())
+		os.Exit(1)
+	}
+}
+
+// the output of the program in filename followed by its error,
+// printed like scm does
+func run(filename string, vm bool) string {
+	var out bytes.Buffer
+	in := scm.New()
+	in.SetOutput(&out)
+	in.SetBytecode(vm)
+
+	if _, err := in.EvalFile(filename); err != nil {
+		var serr *scm.SchemeError
+		if errors.As(err, &serr) {
+			fmt.Fprintf(&out, "error: %s\n", serr)
+			if serr.Exp != nil {
+				fmt.Fprintf(&out, "  in expression: %s\n", serr.Exp)
+			}
+		} else {
+			fmt.Fprintf(&out, "error: %s\n", err)
+		}
+	}
+	return out.String()
+}
+
+// the first line where want and got differ
+func difference(want, got string) (int, string, string) {
+	w := strings.Split(want, "\n")
+	g := strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(w):
+			return i + 1, "", g[i]
+		case i >= len(g):
+			return i + 1, w[i], ""
+		case w[i] != g[i]:
+			return i + 1, w[i], g[i]
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package scm
+
+// Environments. An environment is a chain of frames ending in the
+// global frame. A frame keeps its variables and their values in two
+// slices, a binding is the same index in both, so a variable whose
+// lexical address is known is found without comparing names. The
+// global frame also indexes its variables by name, a program defines
+// most of its variables there and scanning it would be slow.
+
+var the_empty_environment *Value = nullValue
+
+// Frame is the first frame of an environment
+type Frame struct {
+	vars      []*Value
+	vals      []*Value
+	enclosing *Value
+
+	// the global frame of the environment
+	global *Frame
+	// the position of every variable, only in the global frame
+	index map[*Value]int
+	// the variable every compound or compiled procedure is bound to,
+	// only in the global frame. A traced call finds the name of its
+	// procedure there without scanning every variable
+	names map[*Value]*Value
+	// only in the global frame, incremented every time a definition
+	// adds a variable to another frame. The lexical addresses computed
+	// before may skip the new variable, they are checked again
+	shape int
+}
+
+func make_environment(frame *Frame) *Value {
+	return &Value{
+		kind: Environment,
+		val:  frame,
+	}
+}
+
+func enclosing_environment(env *Value) *Value {
+	return first_frame(env).enclosing
+}
+
+func first_frame(env *Value) *Frame {
+	return env.val.(*Frame)
+}
+
+func frame_variables(frame *Frame) []*Value {
+	return frame.vars
+}
+
+func frame_values(frame *Frame) []*Value {
+	return frame.vals
+}
+
+func is_global_frame(frame *Frame) bool {
+	return frame.index != nil
+}
+
+// the position of variable in the frame, -1 when it is not bound there
+func frame_offset(variable *Value, frame *Frame) int {
+	if is_global_frame(frame) {
+		if i, ok := frame.index[variable]; ok {
+			return i
+		}
+		return -1
+	}
+	for i, v := range frame.vars {
+		if v == variable {
+			return i
+		}
+	}
+	return -1
+}
+
+func add_binding_to_frame(variable *Value, val *Value, frame *Frame) {
+	if is_global_frame(frame) {
+		frame.index[variable] = len(frame.vars)
+		name_procedure(variable, val, frame)
+	} else {
+		frame.global.shape++
+	}
+	frame.vars = append(frame.vars, variable)
+	frame.vals = append(frame.vals, val)
+}
+
+// bind the variable at offset i in frame to val
+func set_frame_value(frame *Frame, i int, val *Value) {
+	if is_global_frame(frame) {
+		if old := frame.vals[i]; frame.names[old] == frame.vars[i] {
+			delete(frame.names, old)
+		}
+		name_procedure(frame.vars[i], val, frame)
+	}
+	frame.vals[i] = val
+}
+
+// a procedure bound to more than one variable keeps the first name
+func name_procedure(variable *Value, val *Value, frame *Frame) {
+	if !test(is_compound_procedure(val)) && !test(is_compiled_procedure(val)) {
+		return
+	}
+	if _, ok := frame.names[val]; !ok {
+		frame.names[val] = variable
+	}
+}
+
+// a new frame for vars on top of base_env, extending the empty
+// environment makes a global frame
+func extend_environment(vars *Value, vals *Value, base_env *Value) *Value {
+	if has_rest_parameter(vars) {
+		vars, vals = bind_rest_parameter(vars, vals)
+	}
+
+	varsLen := listLen(vars)
+	valsLen := listLen(vals)
+
+	if varsLen < valsLen {
+		raise_error(ArityError, "Too many arguments supplied", vars, vals)
+	} else if varsLen > valsLen {
+		raise_error(ArityError, "Too few arguments supplied", vars, vals)
+	}
+
+	frame := &Frame{
+		vars:      make([]*Value, 0, varsLen),
+		vals:      make([]*Value, 0, varsLen),
+		enclosing: base_env,
+	}
+	if base_env == the_empty_environment {
+		frame.global = frame
+		frame.index = map[*Value]int{}
+		frame.names = map[*Value]*Value{}
+	} else {
+		frame.global = first_frame(base_env).global
+	}
+
+	for ; isPair(vars); vars, vals = cdr(vars), cdr(vals) {
+		if is_global_frame(frame) {
+			define_in_frame(car(vars), car(vals), frame)
+			continue
+		}
+		frame.vars = append(frame.vars, car(vars))
+		frame.vals = append(frame.vals, car(vals))
+	}
+	return make_environment(frame)
+}
+
+// a parameter list ending in a name, like (a b . rest) or args
+func has_rest_parameter(params *Value) bool {
+	for isPair(params) {
+		params = cdr(params)
+	}
+	return !isNull(params)
+}
+
+// the rest parameter is bound to the list of the arguments
+// left after the required parameters
+func bind_rest_parameter(params *Value, args *Value) (*Value, *Value) {
+	var vars, vals []*Value
+	p, a := params, args
+	for ; isPair(p); p, a = cdr(p), cdr(a) {
+		if isNull(a) {
+			raise_error(ArityError, "Too few arguments supplied", params, args)
+		}
+		vars = append(vars, car(p))
+		vals = append(vals, car(a))
+	}
+	vars = append(vars, p)
+	vals = append(vals, a)
+	return list(vars...), list(vals...)
+}
+
+// the frame of env where variable is bound and its offset there,
+// nil when it is not bound
+func find_binding(variable *Value, env *Value) (*Frame, int) {
+	for ; env != the_empty_environment; env = enclosing_environment(env) {
+		frame := first_frame(env)
+		if i := frame_offset(variable, frame); i >= 0 {
+			return frame, i
+		}
+	}
+	return nil, -1
+}
+
+// variable operations, the variable is a name or the lexical
+// address of a name
+func lookup_variable_value(variable *Value, env *Value) *Value {
+	if is_lexical_address(variable) {
+		return lookup_lexical_address(variable, env)
+	}
+
+	frame, i := find_binding(variable, env)
+	if frame == nil {
+		if variable.ctx != nil {
+			// an alias refers to the binding where its macro was defined
+			return lookup_variable_value(variable.ctx.name, variable.ctx.env)
+		}
+		raise_error(UnboundError, "Unbound variable", variable)
+	}
+	if frame.vals[i] == unassigned_value {
+		raise_error(UnboundError, "Unassigned variable", variable)
+	}
+	return frame.vals[i]
+}
+
+func set_variable_value(variable *Value, val *Value, env *Value) {
+	if is_lexical_address(variable) {
+		set_lexical_address(variable, val, env)
+		return
+	}
+
+	frame, i := find_binding(variable, env)
+	if frame == nil {
+		if variable.ctx != nil {
+			set_variable_value(variable.ctx.name, val, variable.ctx.env)
+			return
+		}
+		raise_error(UnboundError, "Unbound variable -- SET!", variable)
+	}
+	set_frame_value(frame, i, val)
+}
+
+func define_variable(variable *Value, val *Value, env *Value) {
+	define_in_frame(variable, val, first_frame(env))
+}
+
+func define_in_frame(variable *Value, val *Value, frame *Frame) {
+	if i := frame_offset(variable, frame); i >= 0 {
+		set_frame_value(frame, i, val)
+		return
+	}
+	add_binding_to_frame(variable, val, frame)
+}
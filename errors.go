@@ -0,0 +1,140 @@
+package scm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kinds of errors signaled by the interpreter
+const (
+	UserError         = "error"
+	WrongTypeError    = "wrong-type"
+	ArityError        = "arity"
+	UnboundError      = "unbound-variable"
+	SyntaxError       = "syntax"
+	UnknownExpError   = "unknown-expression"
+	UnknownProcError  = "unknown-procedure"
+	DivideByZeroError = "divide-by-zero"
+	UncaughtError     = "uncaught-exception"
+	// a handler returned from a non-continuable exception
+	NonContinuableError = "non-continuable"
+)
+
+// SchemeError is an error signaled while evaluating a program.
+// It travels through the machine as an error object in the val
+// register until it reaches signal_error, which raises it like
+// the raise procedure.
+type SchemeError struct {
+	Kind      string
+	Message   string
+	Irritants *Value
+	Exp       *Value    // the offending expression
+	Pos       *Position // where in the source the error happened
+}
+
+func (e *SchemeError) Error() string {
+	var b strings.Builder
+	if e.Pos != nil {
+		fmt.Fprintf(&b, "%s: ", e.Pos)
+	}
+	b.WriteString(e.Message)
+
+	if e.Irritants != nil {
+		for irritants := e.Irritants; isPair(irritants); irritants = cdr(irritants) {
+			fmt.Fprintf(&b, " %s", car(irritants))
+		}
+	}
+
+	return b.String()
+}
+
+func newError(kind string, message string, irritants ...*Value) *SchemeError {
+	return &SchemeError{
+		Kind:      kind,
+		Message:   message,
+		Irritants: list(irritants...),
+	}
+}
+
+// stop the current primitive or machine operation and transfer
+// control to signal_error, the machine recovers the error in execute()
+func raise_error(kind string, message string, irritants ...*Value) {
+	panic(newError(kind, message, irritants...))
+}
+
+func make_error(err *SchemeError) *Value {
+	return &Value{
+		kind: ErrorObject,
+		val:  err,
+	}
+}
+
+// the error reported for an object raised without a handler
+func uncaught_error(obj *Value, pos *Position) *SchemeError {
+	if isErrorObject(obj) {
+		return obj.val.(*SchemeError)
+	}
+	return &SchemeError{
+		Kind:      UncaughtError,
+		Message:   "uncaught exception",
+		Irritants: list(obj),
+		Pos:       pos,
+	}
+}
+
+func isErrorObject(v *Value) bool {
+	if v == nil {
+		panic("not a value")
+	}
+	if v.kind == ErrorObject {
+		return true
+	}
+	return false
+}
+
+// error primitives
+func _error(args *Value) *Value {
+	if isNull(args) {
+		raise_error(ArityError, "error: a message is required")
+	}
+
+	var message string
+	msg := car(args)
+	if isString(msg) {
+		message = msg.val.(string)
+	} else {
+		message = msg.String()
+	}
+
+	panic(&SchemeError{
+		Kind:      UserError,
+		Message:   message,
+		Irritants: cdr(args),
+	})
+}
+
+func error_object_p(args *Value) *Value {
+	if isErrorObject(car(args)) {
+		return make_true()
+	}
+	return make_false()
+}
+
+func error_object_message(args *Value) *Value {
+	e := car(args)
+	if !isErrorObject(e) {
+		raise_error(WrongTypeError, "error-object-message: not an error object", e)
+	}
+	return &Value{
+		kind: String,
+		val:  e.val.(*SchemeError).Message,
+	}
+}
+
+func error_object_irritants(args *Value) *Value {
+	e := car(args)
+	if !isErrorObject(e) {
+		raise_error(WrongTypeError, "error-object-irritants: not an error object", e)
+	}
+	return e.val.(*SchemeError).Irritants
+}
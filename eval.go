@@ -1,309 +1,907 @@
-package main
+package scm
 
 import "fmt"
 
 type Register struct {
 	name     string
 	contents *Value
+	// the number of assignments since the statistics were reset
+	assignments int
 }
 
 func (r *Register) String() string {
-	return fmt.Sprintf("%s: %s", r.name, r.contents)
+	return fmt.Sprintf("%s: %s", r.name, describe(r.contents))
 }
 
-// the machine stack
-var stack *Stack
-
-// machine registers
-var exp *Register = &Register{name: "exp"}
-var env *Register = &Register{name: "env"}
-var unev *Register = &Register{name: "unev"}
-var argl *Register = &Register{name: "argl"}
-var proc *Register = &Register{name: "proc"}
-var cont *Register = &Register{name: "cont"}
-var val *Register = &Register{name: "val"}
-
-func startEval(v *Value) {
-	initialize_stack()
-	assign(exp, v)
-	assign(env, get_global_environment())
-	assign(cont, label(print_result))
-	go_to(label(eval_dispatch))
+func newRegister(name string) *Register {
+	return &Register{name: name}
 }
 
-func eval_dispatch() {
-	if test(is_self_evaluating(reg(exp))) {
-		ev_self_eval()
-		return
+// start the machine on the expression v in the environment e,
+// the result of the evaluation is left in the val register
+func (in *Interpreter) startEval(v *Value, e *Value) error {
+	in.initialize_stack()
+	in.err = nil
+	in.where = nil
+	in.combination = nil
+	exp, err := in.addressed(v, e)
+	if err != nil {
+		return err
 	}
-
-	if test(is_variable(reg(exp))) {
-		ev_variable()
-		return
+	assign(in.exp, exp)
+	assign(in.env, e)
+	assign(in.cont, label(in.done))
+	assign(in.winders, the_empty_extent)
+	assign(in.handlers, no_handlers)
+	in.go_to(in.eval_entry())
+	in.execute()
+
+	if in.err != nil {
+		return in.err
 	}
+	return nil
+}
+
+// the expression v with the lexical addresses of its references in e.
+// The pre-pass leaves the forms it does not recognize as they are, an
+// error it still signals is reported at v as the machine would
+func (in *Interpreter) addressed(v *Value, e *Value) (exp *Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			if serr.Exp == nil {
+				serr.Exp = v
+			}
+			if serr.Pos == nil {
+				serr.Pos = v.pos
+			}
+			err = serr
+		}
+	}()
+	return in.lexical_addresses(v, e), nil
+}
+
+// run the machine until a label does not jump anywhere else.
+// labels never call each other directly, they only set the pc,
+// so the Go stack stays the same size no matter how long the
+// program runs
+func (in *Interpreter) execute() {
+	for !in.run() {
+		// an error was signaled, keep going from signal_error
+	}
+}
 
-	if test(is_quoted(reg(exp))) {
-		ev_quoted()
-		return
+// run labels until the machine stops. A *SchemeError raised by a
+// primitive or a machine operation is placed in the val register and
+// control is transferred to signal_error, in that case run
+// returns false so that execute() can resume the machine
+func (in *Interpreter) run() (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			// the exp register is stale while a primitive runs
+			// or the arguments of a call are bound
+			exp := reg(in.exp)
+			if in.in_call && in.combination != nil {
+				exp = in.combination
+			}
+			if err.Exp == nil && !in.in_primitive {
+				err.Exp = exp
+			}
+			if err.Pos == nil {
+				switch {
+				case err.Exp != nil && err.Exp.pos != nil:
+					err.Pos = err.Exp.pos
+				case in.in_primitive && in.combination != nil && in.combination.pos != nil:
+					err.Pos = in.combination.pos
+				default:
+					err.Pos = in.where
+				}
+			}
+			in.in_primitive = false
+			in.in_call = false
+			assign(in.val, make_error(err))
+			in.go_to(label(in.signal_error))
+			stopped = false
+		}
+	}()
+
+	for reg(in.pc) != nil {
+		next := reg(in.pc)
+		assign(in.pc, nil)
+		f, ok := next.val.(func())
+		if !ok {
+			panic(fmt.Sprintf("not a valid label %s", next))
+		}
+		if in.stats.counting {
+			in.stats.executed_label(f)
+		}
+		if in.debugger != nil {
+			in.debugger.before(f)
+		}
+		f()
 	}
+	return true
+}
 
-	if test(is_assignment(reg(exp))) {
-		ev_assignment()
-		return
+func (in *Interpreter) eval_dispatch() {
+	// remember the last position reached in the source
+	if reg(in.exp).pos != nil {
+		in.where = reg(in.exp).pos
 	}
 
-	if test(is_definition(reg(exp))) {
-		ev_definition()
+	if is_analyzed(reg(in.exp)) {
+		in.go_to(analysis_of(reg(in.exp)).execute)
 		return
 	}
 
-	if test(is_if(reg(exp))) {
-		ev_if()
+	if test(is_self_evaluating(reg(in.exp))) {
+		in.go_to(label(in.ev_self_eval))
 		return
 	}
 
-	if test(is_lambda(reg(exp))) {
-		ev_lambda()
+	if test(is_variable(reg(in.exp))) {
+		in.go_to(label(in.ev_variable))
 		return
 	}
 
-	if test(is_begin(reg(exp))) {
-		ev_begin()
+	if form := in.special_form(reg(in.exp)); form != nil {
+		in.go_to(form)
 		return
 	}
 
-	if test(is_application(reg(exp))) {
-		ev_application()
+	if test(is_application(reg(in.exp))) {
+		in.go_to(label(in.ev_application))
 		return
 	}
 
-	go_to(label(unknown_expression_type))
+	in.go_to(label(in.unknown_expression_type))
 }
 
-func ev_lambda() {
-	assign(unev, lambda_parameters(reg(exp)))
-	assign(exp, lambda_body(reg(exp)))
-	assign(val, make_procedure(reg(unev), reg(exp), reg(env)))
-	go_to(reg(cont))
+func (in *Interpreter) ev_lambda() {
+	assign(in.unev, lambda_parameters(reg(in.exp)))
+	assign(in.exp, lambda_body(reg(in.exp)))
+	assign(in.val, make_procedure(reg(in.unev), reg(in.exp), reg(in.env)))
+	in.go_to(reg(in.cont))
 }
 
-func ev_application() {
-	save(*cont)
-	save(*env)
-	assign(unev, operands(reg(exp)))
-	save(*unev)
-	assign(exp, operator(reg(exp)))
-	assign(cont, label(ev_appl_did_operator))
-	go_to(label(eval_dispatch))
+// derived expressions are transformed and evaluated again
+func (in *Interpreter) ev_let() {
+	assign(in.exp, let_to_combination(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_appl_did_operator() {
-	restore(unev)
-	restore(env)
-	assign(argl, empty_arglist())
-	assign(proc, reg(val))
-	if test(has_no_operands(reg(unev))) {
-		apply_dispatch()
-		return
-	}
-	save(*proc)
-	go_to(label(ev_appl_operand_loop))
+func (in *Interpreter) ev_let_star() {
+	assign(in.exp, let_star_to_nested_lets(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_appl_operand_loop() {
-	save(*argl)
-	assign(exp, first_operand(reg(unev)))
-	if test(is_last_operand(reg(unev))) {
-		ev_appl_last_arg()
-		return
-	}
-	save(*env)
-	save(*unev)
-	assign(cont, label(ev_appl_accumulate_arg))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_letrec() {
+	assign(in.exp, letrec_to_let(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_cond() {
+	assign(in.exp, cond_to_if(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_when() {
+	assign(in.exp, when_to_if(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_unless() {
+	assign(in.exp, unless_to_if(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_appl_accumulate_arg() {
-	restore(unev)
-	restore(env)
-	restore(argl)
-	assign(argl, adjoin_arg(reg(val), reg(argl)))
-	assign(unev, rest_operands(reg(unev)))
-	go_to(label(ev_appl_operand_loop))
+func (in *Interpreter) ev_case() {
+	assign(in.exp, case_to_cond(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_appl_last_arg() {
-	assign(cont, label(ev_appl_accum_last_arg))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_do() {
+	assign(in.exp, do_to_named_let(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_appl_accum_last_arg() {
-	restore(argl)
-	assign(argl, adjoin_arg(reg(val), reg(argl)))
-	restore(proc)
-	go_to(label(apply_dispatch))
+func (in *Interpreter) ev_define_syntax() {
+	assign(in.unev, define_syntax_keyword(reg(in.exp)))
+	assign(in.val, in.make_syntax_rules(reg(in.unev), define_syntax_transformer(reg(in.exp)), reg(in.env), lexical_bindings(reg(in.env))))
+	define_variable(reg(in.unev), reg(in.val), reg(in.env))
+	assign(in.val, constant("ok"))
+	in.go_to(reg(in.cont))
 }
 
-func apply_dispatch() {
-	if test(is_primitive_procedure(reg(proc))) {
-		primitive_apply()
+func (in *Interpreter) ev_let_syntax() {
+	assign(in.env, in.let_syntax_environment(reg(in.exp), reg(in.env)))
+	assign(in.unev, syntax_body(reg(in.exp)))
+	in.save(in.cont)
+	in.go_to(label(in.ev_sequence))
+}
+
+func (in *Interpreter) ev_letrec_syntax() {
+	assign(in.env, in.letrec_syntax_environment(reg(in.exp), reg(in.env)))
+	assign(in.unev, syntax_body(reg(in.exp)))
+	in.save(in.cont)
+	in.go_to(label(in.ev_sequence))
+}
+
+func (in *Interpreter) ev_define_macro() {
+	assign(in.exp, define_macro_to_definition(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_defmacro() {
+	assign(in.exp, defmacro_to_definition(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_guard() {
+	assign(in.exp, guard_to_combination(
+		reg(in.exp),
+		in.machine_procedure("call/cc"),
+		in.machine_procedure("with-exception-handler"),
+		in.machine_procedure("raise-continuable")))
+	in.go_to(label(in.eval_dispatch))
+}
+
+// (and) is true, otherwise the operands are evaluated until one
+// of them is false, the last one is evaluated in tail position
+func (in *Interpreter) ev_and() {
+	assign(in.unev, logical_operands(reg(in.exp)))
+	if test(has_no_operands(reg(in.unev))) {
+		assign(in.val, make_true())
+		in.go_to(reg(in.cont))
 		return
 	}
-	if test(is_compound_procedure(reg(proc))) {
-		compound_apply()
+	in.save(in.cont)
+	in.go_to(label(in.ev_and_loop))
+}
+
+func (in *Interpreter) ev_and_loop() {
+	assign(in.exp, first_operand(reg(in.unev)))
+	if test(is_last_operand(reg(in.unev))) {
+		in.restore(in.cont)
+		in.go_to(label(in.eval_dispatch))
 		return
 	}
-	go_to(label(unknown_procedure_type))
+	in.save(in.unev)
+	in.save(in.env)
+	assign(in.cont, label(in.ev_and_decide))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_and_decide() {
+	in.restore(in.env)
+	in.restore(in.unev)
+	if test(is_false(reg(in.val))) {
+		in.restore(in.cont)
+		in.go_to(reg(in.cont))
+		return
+	}
+	assign(in.unev, rest_operands(reg(in.unev)))
+	in.go_to(label(in.ev_and_loop))
 }
 
-func primitive_apply() {
-	assign(val, apply_primitive_procedure(reg(proc), reg(argl)))
-	restore(cont)
-	go_to(reg(cont))
+// (or) is false, otherwise the operands are evaluated until one
+// of them is true, the last one is evaluated in tail position
+func (in *Interpreter) ev_or() {
+	assign(in.unev, logical_operands(reg(in.exp)))
+	if test(has_no_operands(reg(in.unev))) {
+		assign(in.val, make_false())
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.save(in.cont)
+	in.go_to(label(in.ev_or_loop))
 }
 
-func compound_apply() {
-	assign(unev, procedure_parameters(reg(proc)))
-	assign(env, procedure_environment(reg(proc)))
-	assign(env, extend_environment(reg(unev), reg(argl), reg(env)))
-	assign(unev, procedure_body(reg(proc)))
-	go_to(label(ev_sequence))
+func (in *Interpreter) ev_or_loop() {
+	assign(in.exp, first_operand(reg(in.unev)))
+	if test(is_last_operand(reg(in.unev))) {
+		in.restore(in.cont)
+		in.go_to(label(in.eval_dispatch))
+		return
+	}
+	in.save(in.unev)
+	in.save(in.env)
+	assign(in.cont, label(in.ev_or_decide))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_or_decide() {
+	in.restore(in.env)
+	in.restore(in.unev)
+	if test(is_true(reg(in.val))) {
+		in.restore(in.cont)
+		in.go_to(reg(in.cont))
+		return
+	}
+	assign(in.unev, rest_operands(reg(in.unev)))
+	in.go_to(label(in.ev_or_loop))
+}
+
+// the combination is saved under the operator and the operands, it
+// is restored when its procedure is applied and a primitive reports
+// errors at its position
+func (in *Interpreter) ev_application() {
+	in.save(in.cont)
+	in.save(in.exp)
+	in.save(in.env)
+	assign(in.unev, operands(reg(in.exp)))
+	in.save(in.unev)
+	assign(in.exp, operator(reg(in.exp)))
+	assign(in.cont, label(in.ev_appl_did_operator))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_appl_did_operator() {
+	in.restore(in.unev)
+	in.restore(in.env)
+	assign(in.argl, empty_arglist())
+	assign(in.proc, reg(in.val))
+	if test(is_macro(reg(in.proc))) {
+		in.restore_combination(in.exp)
+		in.go_to(label(in.ev_macro))
+		return
+	}
+	if test(has_no_operands(reg(in.unev))) {
+		in.restore_combination(in.unev)
+		in.go_to(label(in.apply_dispatch))
+		return
+	}
+	in.save(in.proc)
+	in.go_to(label(in.ev_appl_operand_loop))
+}
+
+// the operator is a macro, its transformer is applied to the
+// unevaluated operands and the expansion is evaluated instead. exp
+// holds the combination, which is expanded only the first time
+func (in *Interpreter) ev_macro() {
+	if exp := in.expansion_of(reg(in.exp), reg(in.proc)); exp != nil {
+		in.restore(in.cont)
+		assign(in.exp, exp)
+		in.go_to(label(in.eval_dispatch))
+		return
+	}
+	in.save(in.exp)
+	in.save(in.proc)
+	in.save(in.env)
+	in.use_binding = lexical_bindings(reg(in.env))
+	assign(in.proc, macro_transformer(reg(in.proc)))
+	assign(in.argl, strip_lexical_addresses(reg(in.unev)))
+	assign(in.cont, label(in.ev_macro_expanded))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) ev_macro_expanded() {
+	in.use_binding = nil
+	in.restore(in.env)
+	in.restore(in.proc)
+	in.restore(in.exp)
+	in.restore(in.cont)
+	assign(in.exp, in.keep_expansion(reg(in.exp), reg(in.proc), reg(in.val), reg(in.env)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_appl_operand_loop() {
+	in.save(in.argl)
+	assign(in.exp, first_operand(reg(in.unev)))
+	if test(is_last_operand(reg(in.unev))) {
+		in.go_to(label(in.ev_appl_last_arg))
+		return
+	}
+	in.save(in.env)
+	in.save(in.unev)
+	assign(in.cont, label(in.ev_appl_accumulate_arg))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_begin() {
-	assign(unev, begin_actions(reg(exp)))
-	save(*cont)
-	go_to(label(ev_sequence))
+func (in *Interpreter) ev_appl_accumulate_arg() {
+	in.restore(in.unev)
+	in.restore(in.env)
+	in.restore(in.argl)
+	assign(in.argl, adjoin_arg(reg(in.val), reg(in.argl)))
+	assign(in.unev, rest_operands(reg(in.unev)))
+	in.go_to(label(in.ev_appl_operand_loop))
 }
 
-func ev_sequence() {
-	assign(exp, first_exp(reg(unev)))
-	if test(is_last_exp(reg(unev))) {
-		ev_sequence_last_exp()
-		return
-	}
-	save(*unev)
-	save(*env)
-	assign(cont, label(ev_sequence_continue))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_appl_last_arg() {
+	assign(in.cont, label(in.ev_appl_accum_last_arg))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_appl_accum_last_arg() {
+	in.restore(in.argl)
+	assign(in.argl, adjoin_arg(reg(in.val), reg(in.argl)))
+	in.restore(in.proc)
+	in.restore_combination(in.unev)
+	in.go_to(label(in.apply_dispatch))
 }
 
-func ev_sequence_continue() {
-	restore(env)
-	restore(unev)
-	assign(unev, rest_exps(reg(unev)))
-	go_to(label(ev_sequence))
+// restore the combination saved by ev_application in the register r
+func (in *Interpreter) restore_combination(r *Register) {
+	in.restore(r)
+	in.combination = reg(r)
 }
 
-func ev_sequence_last_exp() {
-	restore(cont)
-	go_to(label(eval_dispatch))
+func (in *Interpreter) apply_dispatch() {
+	if test(is_primitive_procedure(reg(in.proc))) {
+		in.go_to(label(in.primitive_apply))
+		return
+	}
+	if test(is_compound_procedure(reg(in.proc))) {
+		if in.is_traced(reg(in.proc)) {
+			in.go_to(label(in.trace_apply))
+			return
+		}
+		in.go_to(label(in.compound_apply))
+		return
+	}
+	if test(is_compiled_procedure(reg(in.proc))) {
+		if in.is_traced(reg(in.proc)) {
+			in.go_to(label(in.trace_apply))
+			return
+		}
+		in.go_to(label(in.compiled_apply))
+		return
+	}
+	if test(is_continuation(reg(in.proc))) {
+		in.go_to(label(in.continuation_apply))
+		return
+	}
+	if test(is_machine_procedure(reg(in.proc))) {
+		in.go_to(machine_procedure_entry(reg(in.proc)))
+		return
+	}
+	in.go_to(label(in.unknown_procedure_type))
+}
+
+func (in *Interpreter) primitive_apply() {
+	in.in_primitive = true
+	assign(in.val, apply_primitive_procedure(reg(in.proc), reg(in.argl)))
+	in.in_primitive = false
+	in.restore(in.cont)
+	in.go_to(reg(in.cont))
+}
+
+// the after thunks of the extents being left and the before thunks of
+// the extents being entered run first, then the stack and the cont
+// register are replaced by the ones captured with the continuation,
+// which receives the argument
+func (in *Interpreter) continuation_apply() {
+	in.check_arguments("continuation", 1)
+	assign(in.val, car(reg(in.argl)))
+	in.save(in.proc)
+	in.save(in.val)
+	assign(in.unev, continuation_winders(reg(in.proc)))
+	assign(in.cont, label(in.continuation_apply_1))
+	in.go_to(label(in.wind_to))
+}
+
+func (in *Interpreter) continuation_apply_1() {
+	in.restore(in.val)
+	in.restore(in.proc)
+	in.stack = continuation_stack(reg(in.proc))
+	assign(in.handlers, continuation_handlers(reg(in.proc)))
+	assign(in.cont, continuation_cont(reg(in.proc)))
+	in.go_to(reg(in.cont))
+}
+
+// (call/cc f) applies f to the continuation of the call
+func (in *Interpreter) callcc_apply() {
+	in.check_arguments("call/cc", 1)
+	assign(in.proc, car(reg(in.argl)))
+	in.restore(in.cont)
+	assign(in.val, make_continuation(reg(in.cont), in.stack, reg(in.winders), reg(in.handlers)))
+	assign(in.argl, list(reg(in.val)))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+// (dynamic-wind before thunk after) calls the three procedures in
+// order, the thunk runs with the extent on the winders list
+func (in *Interpreter) dynamic_wind_apply() {
+	in.check_arguments("dynamic-wind", 3)
+	in.save(in.argl)
+	assign(in.proc, wind_before(reg(in.argl)))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.dynamic_wind_thunk))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) dynamic_wind_thunk() {
+	in.restore(in.argl)
+	assign(in.winders, cons(make_extent(reg(in.argl)), reg(in.winders)))
+	in.save(in.argl)
+	assign(in.proc, wind_thunk(reg(in.argl)))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.dynamic_wind_after))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) dynamic_wind_after() {
+	in.restore(in.argl)
+	assign(in.winders, cdr(reg(in.winders)))
+	in.save(in.val)
+	assign(in.proc, wind_after(reg(in.argl)))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.dynamic_wind_done))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) dynamic_wind_done() {
+	in.restore(in.val)
+	in.restore(in.cont)
+	in.go_to(reg(in.cont))
+}
+
+// move from the current extent to the one in unev and go to cont,
+// the extents that are left are removed from the winders list
+// before their after thunk runs, and the extents that are entered
+// are added after their before thunk runs
+func (in *Interpreter) wind_to() {
+	if test(is_inner_extent(reg(in.winders), reg(in.unev))) {
+		in.go_to(label(in.rewind))
+		return
+	}
+	in.save(in.cont)
+	in.save(in.unev)
+	assign(in.proc, extent_after(car(reg(in.winders))))
+	assign(in.winders, cdr(reg(in.winders)))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.unwind_next))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
 }
 
-func ev_if() {
-	save(*exp)
-	save(*env)
-	save(*cont)
-	assign(cont, label(ev_if_decide))
-	assign(exp, if_predicate(reg(exp)))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) unwind_next() {
+	in.restore(in.unev)
+	in.restore(in.cont)
+	in.go_to(label(in.wind_to))
 }
 
-func ev_if_decide() {
-	restore(cont)
-	restore(env)
-	restore(exp)
-	if test(is_true(reg(val))) {
-		ev_if_consequent()
+func (in *Interpreter) rewind() {
+	if reg(in.winders) == reg(in.unev) {
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.save(in.cont)
+	in.save(in.unev)
+	assign(in.exp, next_extent(reg(in.winders), reg(in.unev)))
+	in.save(in.exp)
+	assign(in.proc, extent_before(car(reg(in.exp))))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.rewind_next))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) rewind_next() {
+	in.restore(in.exp)
+	assign(in.winders, reg(in.exp))
+	in.restore(in.unev)
+	in.restore(in.cont)
+	in.go_to(label(in.rewind))
+}
+
+func (in *Interpreter) compound_apply() {
+	assign(in.unev, procedure_parameters(reg(in.proc)))
+	assign(in.env, procedure_environment(reg(in.proc)))
+	in.in_call = true
+	assign(in.env, extend_environment(reg(in.unev), reg(in.argl), reg(in.env)))
+	in.in_call = false
+	assign(in.unev, procedure_body(reg(in.proc)))
+	in.go_to(label(in.ev_sequence))
+}
+
+// the entry of a compiled procedure returns to the label in cont
+func (in *Interpreter) compiled_apply() {
+	in.restore(in.cont)
+	in.go_to(compiled_procedure_entry(reg(in.proc)))
+}
+
+func (in *Interpreter) ev_begin() {
+	assign(in.unev, begin_actions(reg(in.exp)))
+	in.save(in.cont)
+	in.go_to(label(in.ev_sequence))
+}
+
+func (in *Interpreter) ev_sequence() {
+	assign(in.exp, first_exp(reg(in.unev)))
+	if test(is_last_exp(reg(in.unev))) {
+		in.go_to(label(in.ev_sequence_last_exp))
 		return
 	}
-	go_to(label(ev_if_alternative))
+	in.save(in.unev)
+	in.save(in.env)
+	assign(in.cont, label(in.ev_sequence_continue))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_if_alternative() {
-	assign(exp, if_alternative(reg(exp)))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_sequence_continue() {
+	in.restore(in.env)
+	in.restore(in.unev)
+	assign(in.unev, rest_exps(reg(in.unev)))
+	in.go_to(label(in.ev_sequence))
 }
 
-func ev_if_consequent() {
-	assign(exp, if_consequent(reg(exp)))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_sequence_last_exp() {
+	in.restore(in.cont)
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_assignment() {
-	assign(unev, assignment_variable(reg(exp)))
-	save(*unev)
-	assign(exp, assignment_value(reg(exp)))
-	save(*env)
-	save(*cont)
-	assign(cont, label(ev_assignment_1))
-	go_to(label(eval_dispatch))
+func (in *Interpreter) ev_if() {
+	in.save(in.exp)
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ev_if_decide))
+	assign(in.exp, if_predicate(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func ev_assignment_1() {
-	restore(cont)
-	restore(env)
-	restore(unev)
-	set_variable_value(reg(unev), reg(val), reg(env))
-	assign(val, constant("ok"))
-	go_to(reg(cont))
+func (in *Interpreter) ev_if_decide() {
+	in.restore(in.cont)
+	in.restore(in.env)
+	in.restore(in.exp)
+	if test(is_true(reg(in.val))) {
+		in.go_to(label(in.ev_if_consequent))
+		return
+	}
+	in.go_to(label(in.ev_if_alternative))
+}
+
+func (in *Interpreter) ev_if_alternative() {
+	assign(in.exp, if_alternative(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_if_consequent() {
+	assign(in.exp, if_consequent(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_assignment() {
+	assign(in.unev, assignment_variable(reg(in.exp)))
+	in.save(in.unev)
+	assign(in.exp, assignment_value(reg(in.exp)))
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ev_assignment_1))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_assignment_1() {
+	in.restore(in.cont)
+	in.restore(in.env)
+	in.restore(in.unev)
+	set_variable_value(reg(in.unev), reg(in.val), reg(in.env))
+	assign(in.val, constant("ok"))
+	in.go_to(reg(in.cont))
+}
+
+func (in *Interpreter) ev_definition() {
+	assign(in.unev, definition_variable(reg(in.exp)))
+	in.save(in.unev)
+	assign(in.exp, definition_value(reg(in.exp)))
+	in.save(in.env)
+	in.save(in.cont)
+	assign(in.cont, label(in.ev_definition_1))
+	in.go_to(label(in.eval_dispatch))
+}
+
+func (in *Interpreter) ev_definition_1() {
+	in.restore(in.cont)
+	in.restore(in.env)
+	in.restore(in.unev)
+	define_variable(reg(in.unev), reg(in.val), reg(in.env))
+	assign(in.val, constant("ok"))
+	in.go_to(reg(in.cont))
+}
+
+func (in *Interpreter) unknown_expression_type() {
+	assign(in.val, make_error(&SchemeError{
+		Kind:    UnknownExpError,
+		Message: "Unknown expression type",
+		Exp:     reg(in.exp),
+		Pos:     in.where,
+	}))
+	in.go_to(label(in.signal_error))
+}
+
+// the error is reported at the combination whose procedure is applied
+func (in *Interpreter) unknown_procedure_type() {
+	in.restore(in.cont)
+	exp, where := reg(in.exp), in.where
+	if in.combination != nil {
+		exp = in.combination
+		if in.combination.pos != nil {
+			where = in.combination.pos
+		}
+	}
+	assign(in.val, make_error(&SchemeError{
+		Kind:      UnknownProcError,
+		Message:   "Unknown procedure type",
+		Irritants: list(reg(in.proc)),
+		Exp:       exp,
+		Pos:       where,
+	}))
+	in.go_to(label(in.signal_error))
+}
+
+// the object in val is raised, errors signaled by the machine and
+// by primitives end up here too. The current handler is called with
+// the object while the outer handlers are installed, if it returns
+// a secondary error is raised in the same dynamic environment
+func (in *Interpreter) signal_error() {
+	if test(has_no_handlers(reg(in.handlers))) {
+		in.go_to(label(in.uncaught_exception))
+		return
+	}
+	in.save(in.val)
+	assign(in.proc, current_handler(reg(in.handlers)))
+	assign(in.handlers, outer_handlers(reg(in.handlers)))
+	assign(in.argl, list(reg(in.val)))
+	assign(in.cont, label(in.handler_returned))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) handler_returned() {
+	in.restore(in.val)
+	assign(in.val, make_error(&SchemeError{
+		Kind:      NonContinuableError,
+		Message:   "exception handler returned",
+		Irritants: list(reg(in.val)),
+		Pos:       in.where,
+	}))
+	in.go_to(label(in.signal_error))
+}
+
+// an exception without a handler stops the machine, the error is
+// returned by startEval and the stack is discarded. The after
+// thunks of the active dynamic-wind calls run first
+func (in *Interpreter) uncaught_exception() {
+	in.save(in.val)
+	assign(in.unev, the_empty_extent)
+	assign(in.cont, label(in.uncaught_exception_1))
+	in.go_to(label(in.wind_to))
+}
+
+func (in *Interpreter) uncaught_exception_1() {
+	in.restore(in.val)
+	in.err = uncaught_error(reg(in.val), in.where)
+	in.go_to(label(in.done))
+}
+
+// (raise obj) never returns to its continuation
+func (in *Interpreter) raise_apply() {
+	in.check_arguments("raise", 1)
+	assign(in.val, car(reg(in.argl)))
+	in.go_to(label(in.signal_error))
+}
+
+// (raise-continuable obj) returns the value of the handler
+func (in *Interpreter) raise_continuable_apply() {
+	in.check_arguments("raise-continuable", 1)
+	assign(in.val, car(reg(in.argl)))
+	if test(has_no_handlers(reg(in.handlers))) {
+		in.go_to(label(in.uncaught_exception))
+		return
+	}
+	in.save(in.handlers)
+	assign(in.proc, current_handler(reg(in.handlers)))
+	assign(in.handlers, outer_handlers(reg(in.handlers)))
+	assign(in.argl, list(reg(in.val)))
+	assign(in.cont, label(in.raise_continuable_return))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
+}
+
+func (in *Interpreter) raise_continuable_return() {
+	in.restore(in.handlers)
+	in.restore(in.cont)
+	in.go_to(reg(in.cont))
+}
+
+// (macroexpand-1 form) expands form once when its operator is a
+// macro bound in the global environment, the transformer is
+// applied in tail position
+func (in *Interpreter) macroexpand_1_apply() {
+	in.check_arguments("macroexpand-1", 1)
+	assign(in.exp, car(reg(in.argl)))
+	assign(in.proc, macro_of(reg(in.exp), in.global))
+	if reg(in.proc) == nil {
+		assign(in.val, reg(in.exp))
+		in.restore(in.cont)
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.use_binding = nil
+	assign(in.proc, macro_transformer(reg(in.proc)))
+	assign(in.argl, operands(reg(in.exp)))
+	in.go_to(label(in.apply_dispatch))
 }
 
-func ev_definition() {
-	assign(unev, definition_variable(reg(exp)))
-	save(*unev)
-	assign(exp, definition_value(reg(exp)))
-	save(*env)
-	save(*cont)
-	assign(cont, label(ev_definition_1))
-	go_to(label(eval_dispatch))
+// (macroexpand form) expands form until its operator is not a macro
+func (in *Interpreter) macroexpand_apply() {
+	in.check_arguments("macroexpand", 1)
+	assign(in.exp, car(reg(in.argl)))
+	in.go_to(label(in.macroexpand_loop))
 }
 
-func ev_definition_1() {
-	restore(cont)
-	restore(env)
-	restore(unev)
-	define_variable(reg(unev), reg(val), reg(env))
-	assign(val, constant("ok"))
-	go_to(reg(cont))
+func (in *Interpreter) macroexpand_loop() {
+	assign(in.proc, macro_of(reg(in.exp), in.global))
+	if reg(in.proc) == nil {
+		assign(in.val, reg(in.exp))
+		in.restore(in.cont)
+		in.go_to(reg(in.cont))
+		return
+	}
+	in.use_binding = nil
+	assign(in.proc, macro_transformer(reg(in.proc)))
+	assign(in.argl, operands(reg(in.exp)))
+	assign(in.cont, label(in.macroexpand_next))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
 }
 
-func unknown_expression_type() {
-	assign(val, constant("unknown expression type error"))
-	go_to(label(signal_error))
+func (in *Interpreter) macroexpand_next() {
+	assign(in.exp, reg(in.val))
+	in.go_to(label(in.macroexpand_loop))
 }
 
-func unknown_procedure_type() {
-	restore(cont)
-	assign(val, constant("unknown procedure type error"))
-	go_to(label(signal_error))
+// (with-exception-handler handler thunk) calls thunk with
+// handler installed as the current exception handler
+func (in *Interpreter) with_exception_handler_apply() {
+	in.check_arguments("with-exception-handler", 2)
+	in.save(in.handlers)
+	assign(in.handlers, install_handler(car(reg(in.argl)), reg(in.handlers)))
+	assign(in.proc, cadr(reg(in.argl)))
+	assign(in.argl, empty_arglist())
+	assign(in.cont, label(in.with_exception_handler_done))
+	in.save(in.cont)
+	in.go_to(label(in.apply_dispatch))
 }
 
-func signal_error() {
-	user_print(reg(val))
-	go_to(label(done))
+func (in *Interpreter) with_exception_handler_done() {
+	in.restore(in.handlers)
+	in.restore(in.cont)
+	in.go_to(reg(in.cont))
 }
 
-func ev_self_eval() {
-	assign(val, reg(exp))
-	go_to(reg(cont))
+func (in *Interpreter) ev_self_eval() {
+	assign(in.val, reg(in.exp))
+	in.go_to(reg(in.cont))
 }
 
-func ev_variable() {
-	assign(val, lookup_variable_value(reg(exp), reg(env)))
-	go_to(reg(cont))
+func (in *Interpreter) ev_variable() {
+	assign(in.val, lookup_variable_value(reg(in.exp), reg(in.env)))
+	in.go_to(reg(in.cont))
 }
 
-func ev_quoted() {
-	assign(val, text_of_quotation(reg(exp)))
-	go_to(reg(cont))
+func (in *Interpreter) ev_quoted() {
+	assign(in.val, text_of_quotation(reg(in.exp)))
+	in.go_to(reg(in.cont))
 }
 
-func done() {
-	// nothing to do
+func (in *Interpreter) ev_quasiquote() {
+	assign(in.exp, quasiquote_to_combination(reg(in.exp)))
+	in.go_to(label(in.eval_dispatch))
 }
 
-func print_result() {
-	user_print(reg(val))
-	go_to(label(done))
+func (in *Interpreter) done() {
+	// nothing to do, the pc is left empty and the machine stops
 }
@@ -1,39 +1,87 @@
-package main
+package scm
 
 import (
 	"fmt"
 )
 
-var the_empty_environment *Value = nullValue
-
 // some primitives
-var primitive_procedures *Value = list(
-	list(make_name("+"), make_prim(plus)),
-	list(make_name("-"), make_prim(minus)),
-	list(make_name("*"), make_prim(mul)),
-	list(make_name("="), make_prim(eq)),
-	list(make_name("eq?"), make_prim(eq)),
-	list(make_name(">"), make_prim(gt)),
-	list(make_name("<"), make_prim(lt)),
-	list(make_name("or"), make_prim(or)),
-	list(make_name("and"), make_prim(and)),
-	list(make_name("display"), make_prim(display)),
-	list(make_name("newline"), make_prim(newline)),
-)
+func (in *Interpreter) primitive_procedures() *Value {
+	return list(
+		list(make_name("+"), make_prim(plus)),
+		list(make_name("-"), make_prim(minus)),
+		list(make_name("*"), make_prim(mul)),
+		list(make_name("/"), make_prim(div)),
+		list(make_name("="), make_prim(num_eq)),
+		list(make_name("eq?"), make_prim(eq)),
+		list(make_name("symbol?"), make_prim(symbol_p)),
+		list(make_name("symbol->string"), make_prim(symbol_to_string)),
+		list(make_name("string->symbol"), make_prim(string_to_symbol)),
+		list(make_name("gensym"), make_prim(gensym)),
+		list(make_name("generate-uninterned-symbol"), make_prim(gensym)),
+		list(make_name(">"), make_prim(gt)),
+		list(make_name("<"), make_prim(lt)),
+		list(make_name("quotient"), make_prim(quotient)),
+		list(make_name("remainder"), make_prim(remainder)),
+		list(make_name("modulo"), make_prim(modulo)),
+		list(make_name("abs"), make_prim(abs)),
+		list(make_name("min"), make_prim(_min)),
+		list(make_name("max"), make_prim(_max)),
+		list(make_name("gcd"), make_prim(gcd)),
+		list(make_name("lcm"), make_prim(lcm)),
+		list(make_name("exact->inexact"), make_prim(exact_to_inexact)),
+		list(make_name("inexact->exact"), make_prim(inexact_to_exact)),
+		list(make_name("exact"), make_prim(inexact_to_exact)),
+		list(make_name("inexact"), make_prim(exact_to_inexact)),
+		list(make_name("cons"), make_prim(_cons)),
+		list(make_name("car"), make_prim(_car)),
+		list(make_name("cdr"), make_prim(_cdr)),
+		list(make_name("list"), make_prim(_list)),
+		list(make_name("append"), make_prim(_append)),
+		list(make_name("display"), make_prim(in.display)),
+		list(make_name("newline"), make_prim(in.newline)),
+		list(make_name("error"), make_prim(_error)),
+		list(make_name("error-object?"), make_prim(error_object_p)),
+		list(make_name("error-object-message"), make_prim(error_object_message)),
+		list(make_name("error-object-irritants"), make_prim(error_object_irritants)),
+		list(make_name("make-machine"), make_prim(make_machine)),
+		list(make_name("start"), make_prim(start_machine)),
+		list(make_name("get-register-contents"), make_prim(get_register_contents)),
+		list(make_name("set-register-contents!"), make_prim(set_register_contents)),
+		list(make_name("machine-statistics"), make_prim(in.machine_statistics)),
+		list(make_name("reset-statistics!"), make_prim(in.reset_statistics)),
+		list(make_name("trace"), make_prim(in.trace)),
+		list(make_name("untrace"), make_prim(in.untrace)),
+	)
+}
+
+// procedures implemented by the machine itself, they
+// need access to the registers and the stack
+func (in *Interpreter) machine_procedures() *Value {
+	return list(
+		list(make_name("call-with-current-continuation"), label(in.callcc_apply)),
+		list(make_name("call/cc"), label(in.callcc_apply)),
+		list(make_name("dynamic-wind"), label(in.dynamic_wind_apply)),
+		list(make_name("raise"), label(in.raise_apply)),
+		list(make_name("raise-continuable"), label(in.raise_continuable_apply)),
+		list(make_name("with-exception-handler"), label(in.with_exception_handler_apply)),
+		list(make_name("macroexpand-1"), label(in.macroexpand_1_apply)),
+		list(make_name("macroexpand"), label(in.macroexpand_apply)),
+	)
+}
 
 // initial setup of the environment
-func get_global_environment() *Value {
+func (in *Interpreter) get_global_environment() *Value {
+	primitives := in.primitive_procedures()
 	initial_env := extend_environment(
-		primitive_procedure_names(),
-		primitive_procedure_objs(),
+		primitive_procedure_names(primitives),
+		primitive_procedure_objs(primitives),
 		the_empty_environment)
-	tname := &Value{
-		kind: Name,
-		val:  "true",
-	}
-	fname := &Value{
-		kind: Name,
-		val:  "false",
+	tname := make_name("true")
+	fname := make_name("false")
+
+	machine := in.machine_procedures()
+	for ; !isNull(machine); machine = cdr(machine) {
+		define_variable(car(car(machine)), make_machine_procedure(car(car(machine)), cadr(car(machine))), initial_env)
 	}
 
 	define_variable(tname, make_true(), initial_env)
@@ -42,178 +90,285 @@ func get_global_environment() *Value {
 	return initial_env
 }
 
-func primitive_procedure_names() *Value {
-	return _map(car, primitive_procedures)
+func primitive_procedure_names(primitives *Value) *Value {
+	return _map(car, primitives)
 }
 
-func primitive_procedure_objs() *Value {
+func primitive_procedure_objs(primitives *Value) *Value {
 	f := func(proc *Value) *Value {
-		n := &Value{
-			kind: Name,
-			val:  "primitive",
-		}
-		return list(n, cadr(proc))
+		return make_primitive_procedure(cadr(proc))
 	}
-	return _map(f, primitive_procedures)
+	return _map(f, primitives)
 }
 
-// representing procedures
-func make_procedure(parameters *Value, body *Value, env *Value) *Value {
-	proc_name := &Value{
-		kind: Name,
-		val:  "procedure",
+func make_primitive_procedure(implementation *Value) *Value {
+	n := make_name("primitive")
+	return list(n, implementation)
+}
+
+// machineProcedure is a procedure implemented by a label of the machine
+type machineProcedure struct {
+	name  *Value
+	entry *Value
+}
+
+// a machine procedure jumps to its entry label with the procedure in
+// proc, the arguments in argl and the continuation on top of the stack.
+// The virtual machine finds its own implementation by the name. It has
+// a kind of its own, a list a program builds is never taken for one
+func make_machine_procedure(name *Value, entry *Value) *Value {
+	return &Value{
+		kind: MachineProcedure,
+		val: &machineProcedure{
+			name:  name,
+			entry: entry,
+		},
 	}
-	return list(proc_name, parameters, body, env)
 }
 
-func procedure_parameters(p *Value) *Value {
-	return cadr(p)
+func is_machine_procedure(proc *Value) *Value {
+	if proc.kind == MachineProcedure {
+		return make_true()
+	}
+	return make_false()
 }
 
-func procedure_body(p *Value) *Value {
-	return caddr(p)
+func machine_procedure_entry(proc *Value) *Value {
+	return proc.val.(*machineProcedure).entry
 }
 
-func procedure_environment(p *Value) *Value {
-	return cadddr(p)
+func machine_procedure_name(proc *Value) *Value {
+	return proc.val.(*machineProcedure).name
 }
 
-func is_primitive_procedure(proc *Value) *Value {
-	if is_tagged_list(proc, "primitive") {
-		return make_true()
+// the machine procedure called name, used by derived
+// expressions that cannot refer to it by a variable
+func (in *Interpreter) machine_procedure(name string) *Value {
+	for procs := in.machine_procedures(); !isNull(procs); procs = cdr(procs) {
+		if isEqual(car(car(procs)), make_name(name)) {
+			return make_machine_procedure(car(car(procs)), cadr(car(procs)))
+		}
 	}
-	return make_false()
+	panic(fmt.Sprintf("unknown machine procedure %s", name))
 }
 
-func is_compound_procedure(proc *Value) *Value {
-	if is_tagged_list(proc, "procedure") {
+// the arguments of a machine procedure are checked like the
+// ones of a primitive, the error does not blame the exp register
+func (in *Interpreter) check_arguments(name string, n int) {
+	in.in_primitive = true
+	if got := listLen(reg(in.argl)); got != n {
+		raise_error(ArityError, name+": wrong number of arguments", make_integer(int64(got)))
+	}
+	in.in_primitive = false
+}
+
+// ContinuationState is the state of the machine captured by call/cc
+type ContinuationState struct {
+	cont     *Value
+	stack    *Stack
+	winders  *Value
+	handlers *Value
+}
+
+// a continuation holds the cont register, a copy of the stack, the
+// dynamic-wind extents and the exception handlers, invoking it
+// restores all of them and returns to cont
+func make_continuation(cont *Value, stack *Stack, winders *Value, handlers *Value) *Value {
+	return &Value{
+		kind: Continuation,
+		val: &ContinuationState{
+			cont:     cont,
+			stack:    stack.copy(),
+			winders:  winders,
+			handlers: handlers,
+		},
+	}
+}
+
+func is_continuation(proc *Value) *Value {
+	if proc.kind == Continuation {
 		return make_true()
 	}
 	return make_false()
 }
 
-func primitive_implementation(proc *Value) *Value {
-	return cadr(proc)
+func continuation_cont(k *Value) *Value {
+	return k.val.(*ContinuationState).cont
 }
 
-func apply_primitive_procedure(proc *Value, args *Value) *Value {
-	p := primitive_implementation(proc)
-	if p.kind != Function {
-		panic(fmt.Sprintf("not a function %s", p))
+// a fresh copy every time, so the continuation can be
+// invoked any number of times
+func continuation_stack(k *Value) *Stack {
+	return k.val.(*ContinuationState).stack.copy()
+}
+
+func continuation_winders(k *Value) *Value {
+	return k.val.(*ContinuationState).winders
+}
+
+func continuation_handlers(k *Value) *Value {
+	return k.val.(*ContinuationState).handlers
+}
+
+// dynamic extents, the winders register is a list of extents
+// that share their tails, so the extents common to two lists
+// are the same pairs
+var the_empty_extent *Value = nullValue
+
+func wind_before(args *Value) *Value {
+	return car(args)
+}
+
+func wind_thunk(args *Value) *Value {
+	return cadr(args)
+}
+
+func wind_after(args *Value) *Value {
+	return caddr(args)
+}
+
+func make_extent(args *Value) *Value {
+	return cons(wind_before(args), wind_after(args))
+}
+
+func extent_before(extent *Value) *Value {
+	return car(extent)
+}
+
+func extent_after(extent *Value) *Value {
+	return cdr(extent)
+}
+
+// is the winders list inner a tail of the winders list outer
+func is_inner_extent(inner *Value, outer *Value) *Value {
+	for l := outer; ; l = cdr(l) {
+		if l == inner {
+			return make_true()
+		}
+		if isNull(l) {
+			return make_false()
+		}
 	}
+}
 
-	fun, ok := p.val.(func(args *Value) *Value)
-	if !ok {
-		panic(fmt.Sprintf("incorrect primitive function signature %s", fun))
+// the tail of target that adds one extent to current
+func next_extent(current *Value, target *Value) *Value {
+	l := target
+	for cdr(l) != current {
+		l = cdr(l)
 	}
+	return l
+}
 
-	return fun(args)
+// exception handlers, the handlers register is a list of
+// procedures with the current handler first
+var no_handlers *Value = nullValue
+
+func has_no_handlers(handlers *Value) *Value {
+	if isNull(handlers) {
+		return make_true()
+	}
+	return make_false()
 }
 
-// environments
-func enclosing_environment(env *Value) *Value {
-	return cdr(env)
+func current_handler(handlers *Value) *Value {
+	return car(handlers)
 }
 
-func first_frame(env *Value) *Value {
-	return car(env)
+func outer_handlers(handlers *Value) *Value {
+	return cdr(handlers)
 }
 
-func make_frame(variables *Value, values *Value) *Value {
-	return cons(variables, values)
+func install_handler(handler *Value, handlers *Value) *Value {
+	return cons(handler, handlers)
 }
 
-func frame_variables(frame *Value) *Value {
-	return car(frame)
+// representing procedures
+func make_procedure(parameters *Value, body *Value, env *Value) *Value {
+	return make_scanned_procedure(parameters, scan_out_defines(body), env)
 }
 
-func frame_values(frame *Value) *Value {
-	return cdr(frame)
+// a procedure whose body has its defines scanned out already
+func make_scanned_procedure(parameters *Value, body *Value, env *Value) *Value {
+	proc_name := make_name("procedure")
+	return list(proc_name, parameters, body, env)
 }
 
-func add_binding_to_frame(variable *Value, val *Value, frame *Value) {
-	setCar(frame, cons(variable, car(frame)))
-	setCdr(frame, cons(val, cdr(frame)))
+// compiledProcedure is a procedure made by code of the register compiler
+type compiledProcedure struct {
+	entry *Value
+	env   *Value
 }
 
-func extend_environment(vars *Value, vals *Value, base_env *Value) *Value {
-	varsLen := listLen(vars)
-	valsLen := listLen(vals)
+// a compiled procedure, entry is the label of the code of its body.
+// Like a machine procedure it has a kind of its own
+func make_compiled_procedure(entry *Value, env *Value) *Value {
+	return &Value{
+		kind: CompiledProcedure,
+		val: &compiledProcedure{
+			entry: entry,
+			env:   env,
+		},
+	}
+}
 
-	if varsLen == valsLen {
-		return cons(make_frame(vars, vals), base_env)
+func is_compiled_procedure(proc *Value) *Value {
+	if proc.kind == CompiledProcedure {
+		return make_true()
 	}
+	return make_false()
+}
 
-	if varsLen < valsLen {
-		panic(fmt.Sprintf("Too many arguments supplied %s %s", vars, vals))
-	} else {
-		panic(fmt.Sprintf("Too few arguments supplied %s %s", vars, vals))
+func compiled_procedure_entry(p *Value) *Value {
+	return p.val.(*compiledProcedure).entry
+}
+
+func compiled_procedure_env(p *Value) *Value {
+	return p.val.(*compiledProcedure).env
+}
+
+func procedure_parameters(p *Value) *Value {
+	return cadr(p)
+}
+
+func procedure_body(p *Value) *Value {
+	return caddr(p)
+}
+
+func procedure_environment(p *Value) *Value {
+	return cadddr(p)
+}
+
+func is_primitive_procedure(proc *Value) *Value {
+	if is_tagged_list(proc, "primitive") {
+		return make_true()
 	}
+	return make_false()
 }
 
-// variable operations
-func lookup_variable_value(variable *Value, env *Value) *Value {
-	var envLoop func(env *Value) *Value
-	envLoop = func(env *Value) *Value {
-		var scan func(vars *Value, vals *Value) *Value
-		scan = func(vars *Value, vals *Value) *Value {
-			if isNull(vars) {
-				return envLoop(enclosing_environment(env))
-			} else if isEqual(variable, car(vars)) {
-				return car(vals)
-			} else {
-				return scan(cdr(vars), cdr(vals))
-			}
-		}
-		if isEqual(env, the_empty_environment) {
-			panic(fmt.Sprintf("Unbound variable %s", variable))
-		} else {
-			frame := first_frame(env)
-			return scan(frame_variables(frame), frame_values(frame))
-		}
+func is_compound_procedure(proc *Value) *Value {
+	if is_tagged_list(proc, "procedure") {
+		return make_true()
 	}
-	return envLoop(env)
-}
-
-func set_variable_value(variable *Value, val *Value, env *Value) {
-	var envLoop func(env *Value) *Value
-	envLoop = func(env *Value) *Value {
-		var scan func(vars *Value, vals *Value) *Value
-		scan = func(vars *Value, vals *Value) *Value {
-			if isNull(vars) {
-				return envLoop(enclosing_environment(env))
-			} else if isEqual(variable, car(vars)) {
-				setCar(vals, variable)
-				return nullValue
-			} else {
-				return scan(cdr(vars), cdr(vals))
-			}
-		}
-		if isEqual(env, the_empty_environment) {
-			panic(fmt.Sprintf("Unbound variable -- SET! %s", variable))
-		} else {
-			frame := first_frame(env)
-			return scan(frame_variables(frame), frame_values(frame))
-		}
+	return make_false()
+}
+
+func primitive_implementation(proc *Value) *Value {
+	return cadr(proc)
+}
+
+func apply_primitive_procedure(proc *Value, args *Value) *Value {
+	p := primitive_implementation(proc)
+	if p.kind != Function {
+		raise_error(WrongTypeError, "not a function", p)
 	}
-	envLoop(env)
-}
-
-func define_variable(variable *Value, val *Value, env *Value) {
-	var scan func(vars *Value, vals *Value) *Value
-	frame := first_frame(env)
-	scan = func(vars *Value, vals *Value) *Value {
-		if isNull(vars) {
-			add_binding_to_frame(variable, val, frame)
-			return nullValue
-		} else if isEqual(variable, car(vars)) {
-			setCar(vals, variable)
-			return nullValue
-		} else {
-			return scan(cdr(vars), cdr(vals))
-		}
+
+	fun, ok := p.val.(func(args *Value) *Value)
+	if !ok {
+		panic(fmt.Sprintf("incorrect primitive function signature %s", p))
 	}
-	scan(frame_variables(frame), frame_values(frame))
+
+	return fun(args)
 }
 
 func empty_arglist() *Value {
@@ -224,31 +379,36 @@ func adjoin_arg(arg *Value, arglist *Value) *Value {
 	return listAppend(arglist, list(arg))
 }
 
-func user_print(val *Value) {
+func (in *Interpreter) user_print(val *Value) {
+	if test(is_macro(val)) {
+		val = macro_transformer(val)
+	}
 	res := is_compound_procedure(val)
 	if res.val.(bool) == true {
 		n := constant("compound_procedure")
 		l := list(n, procedure_parameters(val), procedure_body(val), constant("<procedure_env>"))
-		display(l)
+		fmt.Fprintf(in.out, "%s", l)
 	} else {
-		display(val)
+		fmt.Fprintf(in.out, "%s", val)
 	}
 }
 
 func assign(register *Register, value *Value) {
 	register.contents = value
+	register.assignments++
 }
 
-func save(register Register) {
-	stack.push(register)
+func (in *Interpreter) save(register *Register) {
+	in.stack.push(reg(register))
+	in.stats.pushed(in.stack.items.Len())
 }
 
-func restore(register *Register) {
-	*register = stack.pop().(Register)
+func (in *Interpreter) restore(register *Register) {
+	assign(register, in.stack.pop().(*Value))
 }
 
-func initialize_stack() {
-	stack = newStack()
+func (in *Interpreter) initialize_stack() {
+	in.stack = newStack()
 }
 
 func reg(r *Register) *Value {
@@ -278,10 +438,9 @@ func label(fun func()) *Value {
 	}
 }
 
-func go_to(fun *Value) {
-	f, ok := fun.val.(func())
-	if !ok {
+func (in *Interpreter) go_to(fun *Value) {
+	if _, ok := fun.val.(func()); !ok {
 		panic(fmt.Sprintf("not a valid function %s", fun))
 	}
-	f()
+	assign(in.pc, fun)
 }
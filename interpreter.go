@@ -0,0 +1,396 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// Interpreter is an instance of the explicit-control evaluator.
+// It owns its registers, its stack and its global environment, so
+// independent interpreters can run concurrently in separate goroutines.
+// A single Interpreter must not be used by several goroutines at once.
+type Interpreter struct {
+	stack *Stack
+
+	// machine registers
+	exp  *Register
+	env  *Register
+	unev *Register
+	argl *Register
+	proc *Register
+	cont *Register
+	val  *Register
+
+	// the before and after thunks of the active dynamic-wind calls,
+	// innermost first
+	winders *Register
+	// the installed exception handlers, the current one first
+	handlers *Register
+
+	// the program counter, holds the next label to execute
+	pc *Register
+
+	global *Value
+	out    io.Writer
+
+	// the labels of the special forms by name, and the
+	// forms registered with DefineSpecialForm
+	special_forms map[*Value]*Value
+	extensions    map[*Value]SpecialForm
+	// the expansions of the macro uses and of the forms registered
+	// with DefineSpecialForm, by the form they were made from
+	expansions map[*Value]*expansion
+	// the lexical binding of a name where the macro being expanded is
+	// used, nil when no name is bound there
+	use_binding func(name *Value) interface{}
+
+	// the error signaled by the last evaluation
+	err *SchemeError
+	// set while a primitive procedure is running or the arguments
+	// of a machine procedure are checked, the exp register is stale then
+	in_primitive bool
+	// set while the arguments of a call are bound to the parameters of
+	// the procedure, the exp register is stale then too
+	in_call bool
+	// the position of the last expression dispatched
+	where *Position
+	// the combination whose procedure is applied, the errors signaled
+	// by a primitive report its position, the errors of the call itself
+	// report the combination
+	combination *Value
+
+	// variables are looked up by the lexical addresses of the
+	// pre-pass, otherwise by name in every frame
+	lexical_addressing bool
+	// expressions are analyzed before they are executed
+	analyzing bool
+	// expressions are compiled to bytecode run by the virtual machine
+	bytecode bool
+	// expressions are compiled to instructions of the register machine
+	compiled bool
+	// the test instructions of compiled code set the flag register
+	flag *Register
+	// the number of the last label made by the compiler
+	label_counter int
+	// the pushes, the depth of the stack and the instructions executed
+	stats *statistics
+	// asked before every label while a program is debugged
+	debugger *debugger
+	// every procedure is traced, or the procedures passed to trace
+	trace_all bool
+	traced    map[interface{}]bool
+	// the continuation of the traced calls
+	trace_return_label *Value
+}
+
+// New creates an interpreter with a fresh global environment
+// that writes its output to stdout.
+func New() *Interpreter {
+	in := &Interpreter{
+		exp:  newRegister("exp"),
+		env:  newRegister("env"),
+		unev: newRegister("unev"),
+		argl: newRegister("argl"),
+		proc: newRegister("proc"),
+		cont: newRegister("cont"),
+		val:  newRegister("val"),
+		pc:   newRegister("pc"),
+		flag: newRegister("flag"),
+		out:  os.Stdout,
+
+		stats: newStatistics(),
+
+		winders:  newRegister("winders"),
+		handlers: newRegister("handlers"),
+
+		lexical_addressing: true,
+		traced:             map[interface{}]bool{},
+		expansions:         map[*Value]*expansion{},
+	}
+	in.trace_return_label = label(in.trace_return)
+	in.install_special_forms()
+	in.global = in.get_global_environment()
+	in.initialize_stack()
+
+	return in
+}
+
+// SetOutput sets the writer used by display and newline.
+func (in *Interpreter) SetOutput(w io.Writer) {
+	in.out = w
+}
+
+// SetLexicalAddressing turns the lexical addressing of variables on or
+// off. It is on by default, when it is off every variable reference is
+// looked up by name in each frame of the environment, as the evaluator
+// of SICP 4.1.3 does. Programs behave the same either way.
+func (in *Interpreter) SetLexicalAddressing(on bool) {
+	in.lexical_addressing = on
+}
+
+// SetAnalyzing selects the analyzing evaluator, every expression is
+// analyzed once before it is executed instead of being examined again
+// each time it is evaluated. It is off by default, programs behave the
+// same either way but a syntax error in a procedure body is signaled
+// when the procedure is defined rather than when it is called. An error
+// in an operand of a combination, which may turn out to be the operand
+// of a macro, is still signaled when the operand is evaluated.
+func (in *Interpreter) SetAnalyzing(on bool) {
+	in.analyzing = on
+}
+
+// SetBytecode selects the virtual machine, every expression read is
+// compiled to bytecode that the machine runs instead of being evaluated
+// by the explicit-control evaluator. It is off by default, programs
+// behave the same either way but a macro use is expanded once, when it
+// is compiled: code compiled before a macro is redefined keeps the
+// expansion of the old macro.
+func (in *Interpreter) SetBytecode(on bool) {
+	in.bytecode = on
+}
+
+// SetCompiled selects the compiler of SICP 5.5, every expression read is
+// compiled to instructions of the register machine that run with the
+// registers and the stack of the evaluator. Compiled procedures and the
+// procedures of the evaluator can call each other. It is off by default,
+// programs behave the same either way.
+func (in *Interpreter) SetCompiled(on bool) {
+	in.compiled = on
+}
+
+// EvalString evaluates every expression in src in the global
+// environment and returns the value of the last one. Errors signaled
+// by the program are returned as a *SchemeError, and errors reading
+// it as a *ParseError.
+func (in *Interpreter) EvalString(src string) (*Value, error) {
+	return in.eval(strings.NewReader(src), "")
+}
+
+// EvalReader evaluates every expression read from r in the global
+// environment and returns the value of the last one. Expressions are
+// evaluated as soon as they are read.
+func (in *Interpreter) EvalReader(r io.Reader) (*Value, error) {
+	return in.eval(r, "")
+}
+
+// EvalFile evaluates every expression in the file named filename,
+// errors report their position in the file.
+func (in *Interpreter) EvalFile(filename string) (*Value, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return in.eval(file, filename)
+}
+
+func (in *Interpreter) eval(r io.Reader, file string) (*Value, error) {
+	reader := newReader(r, file)
+	result := nullValue
+
+	for {
+		datum, err := reader.read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if err := in.evaluate(datum); err != nil {
+			return nil, err
+		}
+		result = reg(in.val)
+	}
+
+	return result, nil
+}
+
+// evaluate datum in the global environment, the value is left in the
+// val register
+func (in *Interpreter) evaluate(datum *Value) error {
+	if in.bytecode {
+		return in.eval_bytecode(datum)
+	}
+	if in.compiled {
+		return in.eval_compiled(datum)
+	}
+	return in.startEval(datum, in.global)
+}
+
+// Define binds name to value in the global environment. The value
+// can be a *Value, a bool, an int, an int64, a float64, a string or
+// a primitive procedure with the signature func(args *Value) *Value.
+func (in *Interpreter) Define(name string, value interface{}) error {
+	v, err := toValue(value)
+	if err != nil {
+		return err
+	}
+
+	define_variable(make_name(name), v, in.global)
+	return nil
+}
+
+// Lookup returns the value bound to name in the global environment.
+// The error is a *SchemeError when name is not bound.
+func (in *Interpreter) Lookup(name string) (v *Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
+		}
+	}()
+
+	return lookup_variable_value(make_name(name), in.global), nil
+}
+
+// ValueOf converts a Go value to a Scheme value, it accepts the same
+// values as Define.
+func ValueOf(value interface{}) (*Value, error) {
+	return toValue(value)
+}
+
+// Cons returns a new pair.
+func Cons(first *Value, rest *Value) *Value {
+	return cons(first, rest)
+}
+
+// List returns a proper list of items.
+func List(items ...*Value) *Value {
+	return list(items...)
+}
+
+// Car returns the first element of a pair. Like Cdr, it must only be
+// called by primitives and special forms while a program runs, the
+// wrong-type error for a value that is not a pair is signaled to the
+// program.
+func Car(v *Value) *Value {
+	return car(v)
+}
+
+// Cdr returns the second element of a pair.
+func Cdr(v *Value) *Value {
+	return cdr(v)
+}
+
+// MakeName returns the name, the identifier of a variable or a
+// symbol when quoted, spelled name.
+func MakeName(name string) *Value {
+	return make_name(name)
+}
+
+// Kind returns the type of the value.
+func (v *Value) Kind() ValueKind {
+	return v.kind
+}
+
+// Int64 returns the value of an exact integer that fits in an int64,
+// ok is false for any other value.
+func (v *Value) Int64() (n int64, ok bool) {
+	switch v.kind {
+	case Integer:
+		return v.val.(int64), true
+	case BigInteger:
+		if b := v.val.(*big.Int); b.IsInt64() {
+			return b.Int64(), true
+		}
+	}
+	return 0, false
+}
+
+// Float64 returns the value of a real number as a float64, exact
+// numbers are converted. Ok is false for a value that is not a number.
+func (v *Value) Float64() (f float64, ok bool) {
+	switch v.kind {
+	case Float:
+		return v.val.(float64), true
+	case Integer:
+		return float64(v.val.(int64)), true
+	case BigInteger:
+		f, _ := new(big.Float).SetInt(v.val.(*big.Int)).Float64()
+		return f, true
+	case Rational:
+		f, _ := v.val.(*big.Rat).Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+// Bool returns the value of a boolean, ok is false for any other
+// value.
+func (v *Value) Bool() (b bool, ok bool) {
+	if v.kind != Boolean {
+		return false, false
+	}
+	return v.val.(bool), true
+}
+
+// Str returns the characters of a string, or the spelling of a name,
+// ok is false for any other value.
+func (v *Value) Str() (s string, ok bool) {
+	switch v.kind {
+	case String:
+		return v.val.(string), true
+	case Name:
+		return original_name(v).val.(string), true
+	}
+	return "", false
+}
+
+// Interface returns the value as a Go value: an int64, a *big.Int or
+// a *big.Rat for exact numbers, a float64, a bool, a string for strings
+// and names, a rune for characters, nil for the empty list and a
+// []interface{} of the converted elements for a proper list. Any other
+// value, like a procedure, is returned as the *Value itself.
+func (v *Value) Interface() interface{} {
+	switch v.kind {
+	case Integer, Float, Boolean, BigInteger, Rational, Character:
+		return v.val
+	case String, Name:
+		s, _ := v.Str()
+		return s
+	case Null:
+		return nil
+	case PairValue:
+		if test(is_compound_procedure(v)) {
+			return v
+		}
+		var items []interface{}
+		l := v
+		for ; l.kind == PairValue; l = cdr(l) {
+			items = append(items, car(l).Interface())
+		}
+		if l.kind == Null {
+			return items
+		}
+	}
+	return v
+}
+
+func toValue(value interface{}) (*Value, error) {
+	switch v := value.(type) {
+	case *Value:
+		return v, nil
+	case bool:
+		return &Value{kind: Boolean, val: v}, nil
+	case int:
+		return &Value{kind: Integer, val: int64(v)}, nil
+	case int64:
+		return &Value{kind: Integer, val: v}, nil
+	case float64:
+		return &Value{kind: Float, val: v}, nil
+	case string:
+		return &Value{kind: String, val: v}, nil
+	case func(args *Value) *Value:
+		return make_primitive_procedure(make_prim(v)), nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("cannot convert %T to a scheme value", value))
+}
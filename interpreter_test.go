@@ -0,0 +1,222 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestDefineLookup(t *testing.T) {
+	in := New()
+	for name, value := range map[string]interface{}{
+		"an-int":    42,
+		"an-int64":  int64(-7),
+		"a-float":   2.5,
+		"a-bool":    true,
+		"a-string":  "hello",
+		"a-pair":    Cons(MakeName("a"), MakeName("b")),
+		"twice-all": func(args *Value) *Value { return args },
+	} {
+		if err := in.Define(name, value); err != nil {
+			t.Fatalf("Define %s: %v", name, err)
+		}
+	}
+	if err := in.Define("a-chan", make(chan int)); err == nil {
+		t.Error("Define accepted a channel")
+	}
+
+	lookup := func(name string) *Value {
+		t.Helper()
+		v, err := in.Lookup(name)
+		if err != nil {
+			t.Fatalf("Lookup %s: %v", name, err)
+		}
+		return v
+	}
+
+	if n, ok := lookup("an-int").Int64(); !ok || n != 42 {
+		t.Errorf("an-int: got %d, %t", n, ok)
+	}
+	if n, ok := lookup("an-int64").Int64(); !ok || n != -7 {
+		t.Errorf("an-int64: got %d, %t", n, ok)
+	}
+	if f, ok := lookup("a-float").Float64(); !ok || f != 2.5 {
+		t.Errorf("a-float: got %g, %t", f, ok)
+	}
+	if f, ok := lookup("an-int").Float64(); !ok || f != 42 {
+		t.Errorf("an-int as a float: got %g, %t", f, ok)
+	}
+	if b, ok := lookup("a-bool").Bool(); !ok || !b {
+		t.Errorf("a-bool: got %t, %t", b, ok)
+	}
+	if s, ok := lookup("a-string").Str(); !ok || s != "hello" {
+		t.Errorf("a-string: got %q, %t", s, ok)
+	}
+	if _, ok := lookup("a-string").Int64(); ok {
+		t.Error("a string converted to an integer")
+	}
+	if _, ok := lookup("an-int").Bool(); ok {
+		t.Error("an integer converted to a boolean")
+	}
+	if k := lookup("a-pair").Kind(); k != PairValue {
+		t.Errorf("a-pair: got kind %s", k)
+	}
+
+	if _, err := in.Lookup("not-defined"); err == nil {
+		t.Error("Lookup of an unbound name succeeded")
+	}
+}
+
+func TestInterface(t *testing.T) {
+	in := New()
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"12", int64(12)},
+		{"1.5", 1.5},
+		{"#f", false},
+		{`"text"`, "text"},
+		{"'sym", "sym"},
+		{`#\a`, 'a'},
+		{"'()", nil},
+		{"(list 1 \"two\" '(3))", []interface{}{int64(1), "two", []interface{}{int64(3)}}},
+		{"(/ 1 3)", big.NewRat(1, 3)},
+		{"(* 99999999999 99999999999)", new(big.Int).Mul(big.NewInt(99999999999), big.NewInt(99999999999))},
+	}
+	for _, test := range tests {
+		v, err := in.EvalString(test.src)
+		if err != nil {
+			t.Fatalf("%s: %v", test.src, err)
+		}
+		if got := v.Interface(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.src, got, test.want)
+		}
+	}
+
+	v, err := in.EvalString("(lambda (x) x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Interface(); got != v {
+		t.Errorf("a procedure: got %#v, want the value itself", got)
+	}
+}
+
+// every interpreter owns its machine, several run at once without
+// sharing their registers, stack or global environment
+func TestConcurrentInterpreters(t *testing.T) {
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			in := New()
+			in.SetAnalyzing(i%2 == 1)
+			in.SetBytecode(i%4 == 2)
+			if err := in.Define("n", i); err != nil {
+				errs <- err
+				return
+			}
+			v, err := in.EvalString(`
+				(define (fact k) (if (= k 0) 1 (* k (fact (- k 1)))))
+				(define (loop k acc) (if (= k 0) acc (loop (- k 1) (+ acc n))))
+				(define-syntax twice (syntax-rules () ((_ e) (begin e e))))
+				(define count 0)
+				(twice (set! count (+ count 1)))
+				(list (fact 20) (loop 1000 0) count 'done)`)
+			if err != nil {
+				errs <- err
+				return
+			}
+			want := fmt.Sprintf("(2432902008176640000 %d 2 done)", 1000*i)
+			if v.String() != want {
+				errs <- fmt.Errorf("interpreter %d: got %s, want %s", i, v, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// an error in the reference to a variable is reported where its
+// name was read, also inside the expressions derived forms become
+func TestReferencePositions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		// the expression reported, when it is checked
+		exp string
+	}{
+		{"(let ((a 1))\n  (+ a nope))", "2:8", ""},
+		{"(let ((a nope)) a)", "1:10", ""},
+		{"(letrec ((a b) (b 1)) a)", "1:13", ""},
+		{"(define (f) (define x nope) x) (f)", "1:23", ""},
+		{"(cond ((= 1 2) 1)\n      (nope 2))", "2:8", ""},
+		{"(cond (#f 1) (else nope))", "1:20", ""},
+		{"(when nope 1)", "1:7", ""},
+		{"(case nope ((1) 1))", "1:7", ""},
+		{"(do ((i 0 (+ i 1))) (nope 1))", "1:22", ""},
+		{"(if #f 1 nope)", "1:10", ""},
+		{"`(1 ,nope)", "1:6", ""},
+		{"(define x nope)", "1:11", ""},
+		{"(define (f x) x) (f 1\n (+ 2 3))", "1:18", "(f 1 (+ 2 3))"},
+		{"(5 3)", "1:1", "(5 3)"},
+	}
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := New()
+			mode.set(in)
+			for _, test := range tests {
+				_, err := in.EvalString(test.src)
+				var serr *SchemeError
+				if !errors.As(err, &serr) {
+					t.Errorf("%s: got %v, want a *SchemeError", test.src, err)
+					continue
+				}
+				if serr.Pos == nil || serr.Pos.String() != test.want {
+					t.Errorf("%s: got the error at %v, want %s", test.src, serr.Pos, test.want)
+				}
+				if test.exp != "" && (serr.Exp == nil || serr.Exp.String() != test.exp) {
+					t.Errorf("%s: got the error in %v, want %s", test.src, serr.Exp, test.exp)
+				}
+			}
+		})
+	}
+}
+
+// a procedure that used a macro expands the use again after the macro
+// is redefined, the virtual machine keeps the expansion it compiled
+func TestRedefinedMacro(t *testing.T) {
+	src := "(define-macro (twice x) `(list ,x ,x))\n" +
+		"(define (f) (twice 1))\n" +
+		"(define before (f))\n" +
+		"(define-macro (twice x) x)\n" +
+		"(list before (f))"
+	for _, mode := range modes {
+		want := "((1 1) 1)"
+		if mode.name == "vm" {
+			want = "((1 1) (1 1))"
+		}
+		t.Run(mode.name, func(t *testing.T) {
+			in := New()
+			mode.set(in)
+			v, err := in.EvalString(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.String(); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
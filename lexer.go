@@ -0,0 +1,484 @@
+package scm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Position is a location in the source of a program
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// ParseError is returned when the source of a program cannot be read.
+// Err is io.ErrUnexpectedEOF when the input ended in the middle of a datum.
+type ParseError struct {
+	Pos     Position
+	Message string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+type TokenKind int
+
+const (
+	LeftParenToken TokenKind = iota
+	RightParenToken
+	QuoteToken
+	QuasiquoteToken
+	UnquoteToken
+	UnquoteSplicingToken
+	DotToken
+	StringToken
+	CharacterToken
+	BooleanToken
+	AtomToken
+)
+
+func (tk TokenKind) String() string {
+	var kind string
+	switch tk {
+	case LeftParenToken:
+		kind = "("
+	case RightParenToken:
+		kind = ")"
+	case QuoteToken:
+		kind = "'"
+	case QuasiquoteToken:
+		kind = "`"
+	case UnquoteToken:
+		kind = ","
+	case UnquoteSplicingToken:
+		kind = ",@"
+	case DotToken:
+		kind = "."
+	case StringToken:
+		kind = "string"
+	case CharacterToken:
+		kind = "character"
+	case BooleanToken:
+		kind = "boolean"
+	case AtomToken:
+		kind = "atom"
+	}
+
+	return kind
+}
+
+type Token struct {
+	kind TokenKind
+	// the text of the token, strings and characters are already unescaped
+	text string
+	pos  Position
+}
+
+// Lexer splits its input into tokens, keeping track of the
+// line and column where each one of them starts
+type Lexer struct {
+	in     *bufio.Reader
+	file   string
+	line   int
+	column int
+
+	// position before the last rune read, restored by unreadRune()
+	prevLine   int
+	prevColumn int
+}
+
+func newLexer(r io.Reader, file string) *Lexer {
+	return &Lexer{
+		in:     bufio.NewReader(r),
+		file:   file,
+		line:   1,
+		column: 1,
+	}
+}
+
+func (l *Lexer) position() Position {
+	return Position{
+		File:   l.file,
+		Line:   l.line,
+		Column: l.column,
+	}
+}
+
+func (l *Lexer) readRune() (rune, error) {
+	c, _, err := l.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+
+	l.prevLine = l.line
+	l.prevColumn = l.column
+	if c == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
+	return c, nil
+}
+
+func (l *Lexer) unreadRune() {
+	if err := l.in.UnreadRune(); err == nil {
+		l.line = l.prevLine
+		l.column = l.prevColumn
+	}
+}
+
+func (l *Lexer) errorf(pos Position, format string, args ...interface{}) error {
+	return &ParseError{
+		Pos:     pos,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// the input ended in the middle of a token or a datum
+func (l *Lexer) unexpectedEOF(pos Position) error {
+	return &ParseError{
+		Pos:     pos,
+		Message: "unexpected end of input",
+		Err:     io.ErrUnexpectedEOF,
+	}
+}
+
+// skip whitespace and comments, io.EOF is returned when the input ends
+func (l *Lexer) skipSpace() error {
+	for {
+		c, err := l.readRune()
+		if err != nil {
+			return err
+		}
+
+		if unicode.IsSpace(c) {
+			continue
+		}
+		if c == ';' {
+			// this is a line comment
+			// consume the input until the newline
+			if err := l.skipLine(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l.unreadRune()
+		return nil
+	}
+}
+
+// discard the rest of the current line
+func (l *Lexer) skipLine() error {
+	_, err := l.readLine()
+	return err
+}
+
+// read the rest of the current line, without the newline
+func (l *Lexer) readLine() (string, error) {
+	var line strings.Builder
+
+	for {
+		c, err := l.readRune()
+		if err != nil {
+			return line.String(), err
+		}
+		if c == '\n' {
+			return line.String(), nil
+		}
+		line.WriteRune(c)
+	}
+}
+
+// read the next token, io.EOF is returned when the
+// input ends before a token starts
+func (l *Lexer) next() (*Token, error) {
+	if err := l.skipSpace(); err != nil {
+		return nil, err
+	}
+
+	pos := l.position()
+	c, err := l.readRune()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case '(':
+		return &Token{kind: LeftParenToken, text: "(", pos: pos}, nil
+	case ')':
+		return &Token{kind: RightParenToken, text: ")", pos: pos}, nil
+	case '\'':
+		return &Token{kind: QuoteToken, text: "'", pos: pos}, nil
+	case '`':
+		return &Token{kind: QuasiquoteToken, text: "`", pos: pos}, nil
+	case ',':
+		next, err := l.readRune()
+		if err == nil {
+			if next == '@' {
+				return &Token{kind: UnquoteSplicingToken, text: ",@", pos: pos}, nil
+			}
+			l.unreadRune()
+		}
+		return &Token{kind: UnquoteToken, text: ",", pos: pos}, nil
+	case '"':
+		return l.readString(pos)
+	case '#':
+		return l.readHash(pos)
+	}
+
+	if !isDelimiter(c) {
+		l.unreadRune()
+		text, err := l.readAtom()
+		if err != nil {
+			return nil, err
+		}
+		if text == "." {
+			return &Token{kind: DotToken, text: text, pos: pos}, nil
+		}
+		return &Token{kind: AtomToken, text: text, pos: pos}, nil
+	}
+
+	return nil, l.errorf(pos, "unexpected character %q", c)
+}
+
+// read the characters of an identifier or a number
+func (l *Lexer) readAtom() (string, error) {
+	var atom strings.Builder
+
+	for {
+		c, err := l.readRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+
+		if isDelimiter(c) {
+			l.unreadRune()
+			break
+		}
+		atom.WriteRune(c)
+	}
+
+	return atom.String(), nil
+}
+
+// reading a string constant, the opening quote was already read
+func (l *Lexer) readString(pos Position) (*Token, error) {
+	var str strings.Builder
+
+	for {
+		c, err := l.readRune()
+		if err == io.EOF {
+			return nil, l.unexpectedEOF(pos)
+		} else if err != nil {
+			return nil, err
+		}
+
+		if c == '"' {
+			break
+		}
+		if c != '\\' {
+			str.WriteRune(c)
+			continue
+		}
+
+		escPos := l.position()
+		c, err = l.readRune()
+		if err == io.EOF {
+			return nil, l.unexpectedEOF(pos)
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch c {
+		case 'a':
+			str.WriteRune('\a')
+		case 'b':
+			str.WriteRune('\b')
+		case 't':
+			str.WriteRune('\t')
+		case 'n':
+			str.WriteRune('\n')
+		case 'r':
+			str.WriteRune('\r')
+		case '"', '\\', '|':
+			str.WriteRune(c)
+		case 'x':
+			// a hex scalar value terminated by a semicolon
+			var hex strings.Builder
+			for {
+				h, err := l.readRune()
+				if err == io.EOF {
+					return nil, l.unexpectedEOF(pos)
+				} else if err != nil {
+					return nil, err
+				}
+				if h == ';' {
+					break
+				}
+				hex.WriteRune(h)
+			}
+			n, err := strconv.ParseInt(hex.String(), 16, 32)
+			if err != nil {
+				return nil, l.errorf(escPos, "invalid hex escape \\x%s;", hex.String())
+			}
+			str.WriteRune(rune(n))
+		case '\n', ' ', '\t':
+			// a line continuation, skip the leading whitespace of the next line
+			for unicode.IsSpace(c) {
+				c, err = l.readRune()
+				if err == io.EOF {
+					return nil, l.unexpectedEOF(pos)
+				} else if err != nil {
+					return nil, err
+				}
+			}
+			l.unreadRune()
+		default:
+			return nil, l.errorf(escPos, "unknown escape sequence \\%c", c)
+		}
+	}
+
+	return &Token{kind: StringToken, text: str.String(), pos: pos}, nil
+}
+
+var characterNames = map[string]rune{
+	"alarm":     '\a',
+	"backspace": '\b',
+	"delete":    '\x7f',
+	"escape":    '\x1b',
+	"newline":   '\n',
+	"null":      '\x00',
+	"return":    '\r',
+	"space":     ' ',
+	"tab":       '\t',
+}
+
+// the external representation of a character
+func characterName(c rune) string {
+	for name, ch := range characterNames {
+		if ch == c {
+			return "#\\" + name
+		}
+	}
+	return "#\\" + string(c)
+}
+
+// read a token starting with #, booleans and characters
+func (l *Lexer) readHash(pos Position) (*Token, error) {
+	c, err := l.readRune()
+	if err == io.EOF {
+		return nil, l.unexpectedEOF(pos)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if c == '\\' {
+		// a character, the first rune is always part of it
+		first, err := l.readRune()
+		if err == io.EOF {
+			return nil, l.unexpectedEOF(pos)
+		} else if err != nil {
+			return nil, err
+		}
+		rest, err := l.readAtom()
+		if err != nil {
+			return nil, err
+		}
+		if rest == "" {
+			return &Token{kind: CharacterToken, text: string(first), pos: pos}, nil
+		}
+
+		name := string(first) + rest
+		if ch, ok := characterNames[name]; ok {
+			return &Token{kind: CharacterToken, text: string(ch), pos: pos}, nil
+		}
+		if first == 'x' {
+			n, err := strconv.ParseInt(rest, 16, 32)
+			if err == nil {
+				return &Token{kind: CharacterToken, text: string(rune(n)), pos: pos}, nil
+			}
+		}
+		return nil, l.errorf(pos, "unknown character #\\%s", name)
+	}
+
+	l.unreadRune()
+	name, err := l.readAtom()
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "t", "true":
+		return &Token{kind: BooleanToken, text: "#t", pos: pos}, nil
+	case "f", "false":
+		return &Token{kind: BooleanToken, text: "#f", pos: pos}, nil
+	}
+
+	if strings.ContainsRune("eEiIxXoObBdD", c) {
+		// a number with a radix or exactness prefix
+		return &Token{kind: AtomToken, text: "#" + name, pos: pos}, nil
+	}
+
+	return nil, l.errorf(pos, "unknown syntax #%s", name)
+}
+
+// characters that end an atom
+func isDelimiter(c rune) bool {
+	if unicode.IsSpace(c) {
+		return true
+	}
+	switch c {
+	case '(', ')', '"', ';', '\'', '`', ',':
+		return true
+	}
+	return false
+}
+
+// does the atom have the syntax of a number
+func isNumeric(atom string) bool {
+	if atom == "" {
+		return false
+	}
+	if atom[0] == '#' {
+		return true
+	}
+	switch atom {
+	case "+inf.0", "-inf.0", "+nan.0", "-nan.0":
+		return true
+	}
+
+	c := atom[0]
+	if c == '+' || c == '-' || c == '.' {
+		if len(atom) == 1 {
+			return false
+		}
+		c = atom[1]
+		if c == '.' && len(atom) > 2 {
+			c = atom[2]
+		}
+	}
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,608 @@
+package scm
+
+// Lexical addressing. Before an expression is evaluated a pre-pass
+// replaces every variable reference with its lexical address: the
+// number of frames to go up from the environment of the reference and
+// the offset of the variable in that frame, or the global frame for a
+// free variable. The pre-pass keeps a compile-time environment, the
+// variables of the frames the machine will create, the same way the
+// compiler of SICP 5.5.6 does.
+//
+// The frames of derived expressions are the frames of the expressions
+// they are transformed into, and the defines at the start of a body
+// are scanned out into a frame of their own. Macros are expanded while
+// the program runs, their expansions are addressed against the frames
+// of the environment they are evaluated in.
+//
+// A definition may still add a variable to a frame that the pre-pass
+// could not see, in the expansion of a macro or inside a when. Such a
+// definition changes the shape of the environment, and an address
+// computed before is looked up again by name the next time it is used.
+// An address is also looked up again when the variable at its offset
+// is not the one it refers to, the address then records where the
+// variable was found.
+//
+// Without lexical addressing the pre-pass still runs, every reference
+// becomes an address that is looked up by name in each frame. The
+// references keep the position of their name either way.
+
+// LexicalAddress is a reference to a variable
+type LexicalAddress struct {
+	name *Value
+	// frames to go up, or global_address
+	frame  int
+	offset int
+	// the shape of the environment the address was computed for
+	shape int
+}
+
+// the frame of a free variable, its offset is only known
+// once the variable is defined
+const global_address = -1
+
+// the frame of a reference that is always looked up by name
+const name_address = -2
+
+// the compile-time environment, the variables of a frame
+// of the machine and the enclosing frames. nil is the
+// global environment
+type scope struct {
+	vars      []*Value
+	enclosing *scope
+}
+
+// the frame of a temporary variable introduced by a derived
+// expression, no variable of the program is found there
+var temporary_frame = []*Value{nil}
+
+func extend_scope(vars []*Value, s *scope) *scope {
+	return &scope{vars: vars, enclosing: s}
+}
+
+// the compile-time environment of the frames of env
+func environment_scope(env *Value) *scope {
+	if env == the_empty_environment || is_global_frame(first_frame(env)) {
+		return nil
+	}
+	return extend_scope(frame_variables(first_frame(env)), environment_scope(enclosing_environment(env)))
+}
+
+func make_lexical_address(name *Value, frame int, offset int, shape int, pos *Position) *Value {
+	return &Value{
+		kind: Address,
+		pos:  pos,
+		val: &LexicalAddress{
+			name:   name,
+			frame:  frame,
+			offset: offset,
+			shape:  shape,
+		},
+	}
+}
+
+func is_lexical_address(exp *Value) bool {
+	return exp.kind == Address
+}
+
+func address_name(exp *Value) *Value {
+	return exp.val.(*LexicalAddress).name
+}
+
+// the frame where the variable of the address is bound in env, the
+// address is looked up again by name when it may be wrong. nil when
+// the variable is not bound
+func address_frame(addr *LexicalAddress, env *Value) *Frame {
+	frame := first_frame(env)
+	if addr.shape == frame.global.shape {
+		if addr.frame == global_address {
+			frame = frame.global
+		} else {
+			for i := 0; i < addr.frame && frame != nil; i++ {
+				if frame.enclosing == the_empty_environment {
+					frame = nil
+				} else {
+					frame = first_frame(frame.enclosing)
+				}
+			}
+		}
+		if frame != nil && addr.offset >= 0 && addr.offset < len(frame.vars) && frame.vars[addr.offset] == addr.name {
+			return frame
+		}
+	}
+
+	frame = first_frame(env)
+	depth := 0
+	for {
+		if i := frame_offset(addr.name, frame); i >= 0 {
+			addr.frame = depth
+			if is_global_frame(frame) {
+				addr.frame = global_address
+			}
+			addr.offset = i
+			addr.shape = frame.global.shape
+			return frame
+		}
+		if frame.enclosing == the_empty_environment {
+			return nil
+		}
+		frame = first_frame(frame.enclosing)
+		depth++
+	}
+}
+
+func lookup_lexical_address(variable *Value, env *Value) *Value {
+	addr := variable.val.(*LexicalAddress)
+	if addr.frame == name_address {
+		return lookup_variable_value(addr.name, env)
+	}
+	frame := address_frame(addr, env)
+	if frame == nil {
+		// an alias found in no frame, or an unbound variable
+		return lookup_variable_value(addr.name, env)
+	}
+	if frame.vals[addr.offset] == unassigned_value {
+		raise_error(UnboundError, "Unassigned variable", addr.name)
+	}
+	return frame.vals[addr.offset]
+}
+
+func set_lexical_address(variable *Value, val *Value, env *Value) {
+	addr := variable.val.(*LexicalAddress)
+	if addr.frame == name_address {
+		set_variable_value(addr.name, val, env)
+		return
+	}
+	frame := address_frame(addr, env)
+	if frame == nil {
+		set_variable_value(addr.name, val, env)
+		return
+	}
+	set_frame_value(frame, addr.offset, val)
+}
+
+// the expression exp to be evaluated in env with its
+// variable references replaced by lexical addresses, or by addresses
+// looked up by name without lexical addressing
+func (in *Interpreter) lexical_addresses(exp *Value, env *Value) *Value {
+	p := &addressing{
+		in:      in,
+		shape:   first_frame(env).global.shape,
+		lexical: in.lexical_addressing,
+	}
+	return p.expression(exp, environment_scope(env))
+}
+
+// the datum without lexical addresses, the operands of a macro
+// and of a special form are passed as they were written, analyzed
+// operands as they were before the analysis
+func strip_lexical_addresses(datum *Value) *Value {
+	switch {
+	case is_lexical_address(datum):
+		return address_name(datum)
+	case is_analyzed(datum):
+		return strip_lexical_addresses(analyzed_expression(datum))
+	case isPair(datum):
+		first := strip_lexical_addresses(car(datum))
+		rest := strip_lexical_addresses(cdr(datum))
+		return rebuild(datum, first, rest)
+	}
+	return datum
+}
+
+// the pair with first and rest, datum itself when they did not
+// change. A new pair keeps the positions of datum
+func rebuild(datum *Value, first *Value, rest *Value) *Value {
+	if first == car(datum) && rest == cdr(datum) {
+		return datum
+	}
+	pair := cons(first, rest)
+	pair.pos = datum.pos
+	pair.val.(*Pair).car_pos = car_position(datum)
+	return pair
+}
+
+// a pass of the lexical addressing over one expression
+type addressing struct {
+	in    *Interpreter
+	shape int
+	// the addresses are looked up by name when it is false
+	lexical bool
+}
+
+// the lexical address of the reference to name in s at pos, an alias
+// that is not bound in s refers to the environment of its macro and
+// is left as it is. Without lexical addressing every reference is
+// looked up by name
+func (p *addressing) address(name *Value, pos *Position, s *scope) *Value {
+	if !p.lexical {
+		return make_lexical_address(name, name_address, -1, p.shape, pos)
+	}
+	for depth := 0; s != nil; s, depth = s.enclosing, depth+1 {
+		for i, v := range s.vars {
+			if v == name {
+				return make_lexical_address(name, depth, i, p.shape, pos)
+			}
+		}
+	}
+	if name.ctx != nil {
+		return name
+	}
+	return make_lexical_address(name, global_address, -1, p.shape, pos)
+}
+
+// the pre-pass never signals an error, a form it does not
+// recognize is left as it is and fails when it is evaluated
+func (p *addressing) expression(exp *Value, s *scope) *Value {
+	if isName(exp) {
+		return p.address(exp, nil, s)
+	}
+	if !isPair(exp) || !isList(exp) {
+		return exp
+	}
+
+	if isName(car(exp)) && p.in.special_forms[original_name(car(exp))] != nil {
+		return p.special_form(exp, s)
+	}
+	return p.sequence(exp, s)
+}
+
+// every element of the list exps
+func (p *addressing) sequence(exps *Value, s *scope) *Value {
+	if !isPair(exps) {
+		return exps
+	}
+	return rebuild(exps, p.element(exps, s), p.sequence(cdr(exps), s))
+}
+
+// the car of the pair exps, a reference is at the
+// position where its name was read
+func (p *addressing) element(exps *Value, s *scope) *Value {
+	if isName(car(exps)) {
+		return p.address(car(exps), car_position(exps), s)
+	}
+	return p.expression(car(exps), s)
+}
+
+// the operands of a special form, the ones after the first n
+func (p *addressing) operands(exp *Value, n int, s *scope) *Value {
+	if n == 0 {
+		return p.sequence(exp, s)
+	}
+	return rebuild(exp, car(exp), p.operands(cdr(exp), n-1, s))
+}
+
+func (p *addressing) special_form(exp *Value, s *scope) *Value {
+	n := listLen(exp)
+	switch original_name(car(exp)).val.(string) {
+	case "quasiquote":
+		if n == 2 {
+			return rebuild_form(exp, car(exp), p.template(cadr(exp), 1, s), cddr(exp))
+		}
+	case "set!":
+		if n == 3 && isName(cadr(exp)) {
+			return rebuild(exp, car(exp), p.sequence(cdr(exp), s))
+		}
+	case "define", "define-macro":
+		if n >= 2 && isName(cadr(exp)) {
+			return p.operands(exp, 2, s)
+		}
+		if n >= 3 && isPair(cadr(exp)) && isName(caadr(exp)) {
+			return p.lambda(exp, cdadr(exp), cddr(exp), s)
+		}
+	case "defmacro":
+		if n >= 4 && isName(cadr(exp)) {
+			return rebuild(exp, car(exp), p.lambda(cdr(exp), caddr(exp), cdddr(exp), s))
+		}
+	case "lambda":
+		if n >= 3 {
+			return p.lambda(exp, cadr(exp), cddr(exp), s)
+		}
+	case "let":
+		return p.let(exp, s)
+	case "let*":
+		return p.let_star(exp, s)
+	case "letrec", "letrec*":
+		return p.letrec(exp, s)
+	case "cond":
+		return rebuild(exp, car(exp), p.clauses(cdr(exp), s))
+	case "case":
+		return p._case(exp, s)
+	case "do":
+		return p._do(exp, s)
+	case "guard":
+		return p.guard(exp, s)
+	case "let-syntax", "letrec-syntax":
+		if n < 3 {
+			break
+		}
+		if keywords, ok := binding_names(cadr(exp)); ok {
+			return p.operands(exp, 2, extend_scope(keywords, s))
+		}
+	case "if", "and", "or", "when", "unless", "begin":
+		return p.operands(exp, 1, s)
+	}
+
+	// quote, define-syntax, special forms defined with
+	// DefineSpecialForm and malformed forms
+	return exp
+}
+
+// the parameters and the body of a lambda expression that starts
+// at exp, the body is the cdr of the pair where it starts
+func (p *addressing) lambda(exp *Value, params *Value, body *Value, s *scope) *Value {
+	vars, ok := parameter_names(params)
+	if !ok {
+		return exp
+	}
+	return p.replace_tail(exp, body, p.body(body, extend_scope(vars, s)))
+}
+
+// a copy of the list exp where the tail that starts at the
+// pair old is new, exp itself when they are the same
+func (p *addressing) replace_tail(exp *Value, old *Value, new *Value) *Value {
+	if exp == old {
+		return new
+	}
+	return rebuild(exp, car(exp), p.replace_tail(cdr(exp), old, new))
+}
+
+// the body of a procedure, its definitions are scanned out
+// into a frame of their own by make_procedure
+func (p *addressing) body(body *Value, s *scope) *Value {
+	if defines := scanned_out_names(body); len(defines) > 0 {
+		s = extend_scope(defines, s)
+	}
+	return p.sequence(body, s)
+}
+
+// the names defined at the top of body, in the order
+// scan_out_defines makes their bindings
+func scanned_out_names(body *Value) []*Value {
+	var names []*Value
+	for seq := body; isPair(seq); seq = cdr(seq) {
+		exp := car(seq)
+		if test(is_definition(exp)) && isList(exp) && listLen(exp) >= 2 {
+			switch {
+			case isName(cadr(exp)):
+				names = append(names, cadr(exp))
+			case isPair(cadr(exp)):
+				names = append(names, caadr(exp))
+			}
+		}
+	}
+	return names
+}
+
+// the names of a parameter list, a rest parameter is last
+func parameter_names(params *Value) ([]*Value, bool) {
+	var names []*Value
+	for ; isPair(params); params = cdr(params) {
+		if !isName(car(params)) {
+			return nil, false
+		}
+		names = append(names, car(params))
+	}
+	if isName(params) {
+		names = append(names, params)
+	} else if !isNull(params) {
+		return nil, false
+	}
+	return names, true
+}
+
+// the names bound by a list of (name init ...) bindings
+func binding_names(bindings *Value) ([]*Value, bool) {
+	if !isList(bindings) {
+		return nil, false
+	}
+	var names []*Value
+	for ; isPair(bindings); bindings = cdr(bindings) {
+		binding := car(bindings)
+		if !isPair(binding) || !isList(binding) || !isName(car(binding)) {
+			return nil, false
+		}
+		names = append(names, car(binding))
+	}
+	return names, true
+}
+
+// the inits of a list of (name init) bindings
+func (p *addressing) inits(bindings *Value, s *scope) *Value {
+	return _map(func(binding *Value) *Value {
+		return rebuild(binding, car(binding), p.sequence(cdr(binding), s))
+	}, bindings)
+}
+
+// (let ((var init) ...) body) is ((lambda (var ...) body) init ...),
+// and (let name ((var init) ...) body) binds name in a frame of its
+// own around the procedure
+func (p *addressing) let(exp *Value, s *scope) *Value {
+	if listLen(exp) < 3 {
+		return exp
+	}
+	if is_named_let(exp) {
+		vars, ok := binding_names(caddr(exp))
+		if !ok || listLen(exp) < 4 {
+			return exp
+		}
+		inner := extend_scope(vars, extend_scope([]*Value{cadr(exp)}, s))
+		return rebuild_form(exp, car(exp), cadr(exp), p.inits(caddr(exp), s), p.body(cdddr(exp), inner))
+	}
+
+	vars, ok := binding_names(cadr(exp))
+	if !ok {
+		return exp
+	}
+	return rebuild_form(exp, car(exp), p.inits(cadr(exp), s), p.body(cddr(exp), extend_scope(vars, s)))
+}
+
+// let* is a let for every binding, nested
+func (p *addressing) let_star(exp *Value, s *scope) *Value {
+	if listLen(exp) < 3 {
+		return exp
+	}
+	vars, ok := binding_names(cadr(exp))
+	if !ok {
+		return exp
+	}
+	var inits []*Value
+	i := 0
+	for bindings := cadr(exp); isPair(bindings); bindings = cdr(bindings) {
+		binding := car(bindings)
+		inits = append(inits, rebuild(binding, car(binding), p.sequence(cdr(binding), s)))
+		s = extend_scope(vars[i:i+1], s)
+		i++
+	}
+	if len(vars) == 0 {
+		s = extend_scope(nil, s)
+	}
+	return rebuild_form(exp, car(exp), list(inits...), p.body(cddr(exp), s))
+}
+
+// letrec is a let that binds every variable before the inits are
+// evaluated in the body, so the inits are in the scope of the
+// scanned out defines of the body too
+func (p *addressing) letrec(exp *Value, s *scope) *Value {
+	if listLen(exp) < 3 {
+		return exp
+	}
+	vars, ok := binding_names(cadr(exp))
+	if !ok {
+		return exp
+	}
+	s = extend_scope(vars, s)
+	if defines := scanned_out_names(cddr(exp)); len(defines) > 0 {
+		s = extend_scope(defines, s)
+	}
+	return rebuild_form(exp, car(exp), p.inits(cadr(exp), s), p.sequence(cddr(exp), s))
+}
+
+// the clauses of a cond, a clause (test => receiver) or (test) keeps
+// the value of its test in a frame around the clauses that follow it
+func (p *addressing) clauses(clauses *Value, s *scope) *Value {
+	if !isPair(clauses) {
+		return clauses
+	}
+	clause := car(clauses)
+	if !isPair(clause) || !isList(clause) {
+		return clauses
+	}
+
+	switch {
+	case is_cond_else_clause(clause):
+		clause = rebuild(clause, car(clause), p.sequence(cdr(clause), s))
+	case is_cond_arrow_clause(clause):
+		test := p.element(clause, s)
+		s = extend_scope(temporary_frame, s)
+		clause = rebuild(clause, test, p.operands(cdr(clause), 1, s))
+	case isNull(cdr(clause)):
+		clause = rebuild(clause, p.element(clause, s), cdr(clause))
+		s = extend_scope(temporary_frame, s)
+	default:
+		clause = p.sequence(clause, s)
+	}
+	return rebuild(clauses, clause, p.clauses(cdr(clauses), s))
+}
+
+// (case key clause ...) keeps the value of the key in a frame
+// around the clauses, the data of a clause are not evaluated
+func (p *addressing) _case(exp *Value, s *scope) *Value {
+	if listLen(exp) < 2 {
+		return exp
+	}
+	key := p.element(cdr(exp), s)
+	inner := extend_scope(temporary_frame, s)
+	clauses := _map(func(clause *Value) *Value {
+		if !isPair(clause) || !isList(clause) {
+			return clause
+		}
+		if is_cond_arrow_clause(clause) {
+			return rebuild(clause, car(clause), p.operands(cdr(clause), 1, inner))
+		}
+		return rebuild(clause, car(clause), p.sequence(cdr(clause), inner))
+	}, cddr(exp))
+	return rebuild_form(exp, car(exp), key, clauses)
+}
+
+// (do ((var init step) ...) (test result ...) command ...) is a named
+// let, only the inits are evaluated outside of its frames
+func (p *addressing) _do(exp *Value, s *scope) *Value {
+	if listLen(exp) < 3 || !isList(caddr(exp)) {
+		return exp
+	}
+	vars, ok := binding_names(cadr(exp))
+	if !ok {
+		return exp
+	}
+	inner := extend_scope(vars, extend_scope(temporary_frame, s))
+	specs := _map(func(spec *Value) *Value {
+		if !isPair(cdr(spec)) {
+			return spec
+		}
+		return rebuild(spec, car(spec), rebuild(cdr(spec), p.element(cdr(spec), s), p.sequence(cddr(spec), inner)))
+	}, cadr(exp))
+	return rebuild_form(exp, car(exp), specs, p.sequence(caddr(exp), inner), p.sequence(cdddr(exp), inner))
+}
+
+// guard is evaluated as the combination made by guard_to_combination,
+// the body is two procedures deep and the clauses five
+func (p *addressing) guard(exp *Value, s *scope) *Value {
+	if listLen(exp) < 3 || !isPair(cadr(exp)) || !isList(cadr(exp)) || !isName(caadr(exp)) {
+		return exp
+	}
+	body := extend_scope(nil, extend_scope(temporary_frame, s))
+	handler := s
+	for i := 0; i < 3; i++ {
+		handler = extend_scope(temporary_frame, handler)
+	}
+	handler = extend_scope([]*Value{caadr(exp)}, extend_scope(nil, handler))
+
+	spec := rebuild(cadr(exp), caadr(exp), p.clauses(cdadr(exp), handler))
+	return rebuild_form(exp, car(exp), spec, p.sequence(cddr(exp), body))
+}
+
+// a quasiquote template nested inside depth quasiquotes, only the
+// unquotes at depth 1 are expressions
+func (p *addressing) template(template *Value, depth int, s *scope) *Value {
+	if !isPair(template) {
+		return template
+	}
+	if is_tagged_list(template, "unquote") && isList(template) && listLen(template) == 2 {
+		if depth == 1 {
+			return rebuild_form(template, car(template), p.element(cdr(template), s), cddr(template))
+		}
+		return rebuild_form(template, car(template), p.template(cadr(template), depth-1, s), cddr(template))
+	}
+	if is_tagged_list(template, "quasiquote") && isList(template) && listLen(template) == 2 {
+		return rebuild_form(template, car(template), p.template(cadr(template), depth+1, s), cddr(template))
+	}
+
+	first := car(template)
+	if is_tagged_list(first, "unquote-splicing") && isList(first) && listLen(first) == 2 {
+		if depth == 1 {
+			first = rebuild_form(first, car(first), p.element(cdr(first), s), cddr(first))
+		} else {
+			first = rebuild_form(first, car(first), p.template(cadr(first), depth-1, s), cddr(first))
+		}
+	} else {
+		first = p.template(first, depth, s)
+	}
+	return rebuild(template, first, p.template(cdr(template), depth, s))
+}
+
+// the list exp with its first elements replaced by items, the
+// last item replaces the rest of the list
+func rebuild_form(exp *Value, items ...*Value) *Value {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return rebuild(exp, items[0], rebuild_form(cdr(exp), items[1:]...))
+}
+
+// a proper list
+func isList(v *Value) bool {
+	for isPair(v) {
+		v = cdr(v)
+	}
+	return isNull(v)
+}
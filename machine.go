@@ -0,0 +1,172 @@
+package scm
+
+// Register machines in the style of SICP 5.2. A machine is made from
+// the names of its registers, the operations its instructions apply
+// and a controller text, the instructions of the text are assembled
+// once by the same assembler that assembles compiled code. A machine
+// runs in its own loop, with its own registers and stack, until it
+// reaches the end of the controller text.
+type registerMachine struct {
+	registers map[*Value]*Register
+	order     []*Register
+	stack     *Stack
+	pc        *Register
+	flag      *Register
+
+	operations map[string]func(args []*Value) *Value
+	// the label of the first instruction
+	entry *Value
+	// every instruction is counted
+	stats *statistics
+}
+
+func make_register_machine(names *Value, operations map[string]func(args []*Value) *Value) *registerMachine {
+	m := &registerMachine{
+		registers:  map[*Value]*Register{},
+		stack:      newStack(),
+		pc:         newRegister("pc"),
+		flag:       newRegister("flag"),
+		operations: operations,
+		stats:      newStatistics(),
+	}
+	m.stats.counting = true
+	for ; isPair(names); names = cdr(names) {
+		m.allocate_register(car(names))
+	}
+	assign(m.flag, make_false())
+	m.operations["initialize-stack"] = func(args []*Value) *Value {
+		m.stack = newStack()
+		return nil
+	}
+	return m
+}
+
+func (m *registerMachine) allocate_register(name *Value) {
+	if !isName(name) {
+		raise_error(WrongTypeError, "make-machine: not a register name", name)
+	}
+	if _, ok := m.registers[name]; ok {
+		raise_error(SyntaxError, "Multiply-defined register", name)
+	}
+	r := newRegister(name.val.(string))
+	assign(r, make_name("*unassigned*"))
+	m.registers[name] = r
+	m.order = append(m.order, r)
+}
+
+// the registers in the order they were allocated
+func (m *registerMachine) register_list() []*Register {
+	return m.order
+}
+
+func (m *registerMachine) get_register(name *Value) *Register {
+	r, ok := m.registers[name]
+	if !ok {
+		raise_error(SyntaxError, "Unknown register", name)
+	}
+	return r
+}
+
+// assemble the controller text, a machine without instructions
+// stops as soon as it starts
+func (m *registerMachine) install_controller(text *Value) {
+	m.entry = assemble(controller_statements(text), &machineModel{
+		register:   m.get_register,
+		operations: m.operations,
+		labels:     map[string]*Value{},
+		stats:      m.stats,
+		flag:       m.flag,
+		go_to:      m.go_to,
+		save:       m.save,
+		restore:    m.restore,
+	})
+}
+
+func (m *registerMachine) go_to(l *Value) {
+	if l != nil && l.kind != Function {
+		raise_error(WrongTypeError, "goto: not a label", l)
+	}
+	assign(m.pc, l)
+}
+
+// the stack of a machine holds the contents of the registers saved,
+// restoring one register does not affect the others
+func (m *registerMachine) save(r *Register) {
+	m.stack.push(reg(r))
+	m.stats.pushed(m.stack.items.Len())
+}
+
+func (m *registerMachine) restore(r *Register) {
+	if m.stack.items.Len() == 0 {
+		raise_error(UserError, "restore: empty stack", make_name(r.name))
+	}
+	assign(r, m.stack.pop().(*Value))
+}
+
+// run the instructions from the start of the controller text until
+// the machine goes past its last instruction
+func (m *registerMachine) start() {
+	m.go_to(m.entry)
+	for reg(m.pc) != nil {
+		next := reg(m.pc)
+		assign(m.pc, nil)
+		next.val.(func())()
+	}
+}
+
+// the operations of a machine made with make-machine, every operation
+// is a primitive procedure applied to the values of its operands
+func primitive_operations(ops *Value) map[string]func(args []*Value) *Value {
+	operations := map[string]func(args []*Value) *Value{}
+	for ; isPair(ops); ops = cdr(ops) {
+		op := car(ops)
+		if !isPair(op) || !isName(car(op)) || !isPair(cdr(op)) {
+			raise_error(WrongTypeError, "make-machine: not an operation", op)
+		}
+		proc := cadr(op)
+		if !test(is_primitive_procedure(proc)) {
+			raise_error(WrongTypeError, "make-machine: not a primitive procedure", proc)
+		}
+		operations[car(op).val.(string)] = func(args []*Value) *Value {
+			return apply_primitive_procedure(proc, list(args...))
+		}
+	}
+	return operations
+}
+
+func make_machine_value(m *registerMachine) *Value {
+	return &Value{
+		kind: Machine,
+		val:  m,
+	}
+}
+
+func machine_of(name string, v *Value) *registerMachine {
+	if v.kind != Machine {
+		raise_error(WrongTypeError, name+": not a machine", v)
+	}
+	return v.val.(*registerMachine)
+}
+
+// machine primitives
+func make_machine(args *Value) *Value {
+	m := make_register_machine(car(args), primitive_operations(cadr(args)))
+	m.install_controller(caddr(args))
+	return make_machine_value(m)
+}
+
+func start_machine(args *Value) *Value {
+	machine_of("start", car(args)).start()
+	return make_name("done")
+}
+
+func get_register_contents(args *Value) *Value {
+	m := machine_of("get-register-contents", car(args))
+	return reg(m.get_register(cadr(args)))
+}
+
+func set_register_contents(args *Value) *Value {
+	m := machine_of("set-register-contents!", car(args))
+	assign(m.get_register(cadr(args)), caddr(args))
+	return make_name("done")
+}
@@ -0,0 +1,33 @@
+;; a program for the evaluator in evaluator.scm
+(define (fact n)
+  (if (= n 1)
+      1
+      (* n (fact (- n 1)))))
+(display (fact 10))
+(newline)
+
+(define (count n)
+  (cond ((= n 0) 'done)
+        (else (count (- n 1)))))
+(display (count 10000))
+(newline)
+
+(define (make-counter)
+  (let ((n 0))
+    (lambda ()
+      (set! n (+ n 1))
+      n)))
+(define counter (make-counter))
+(counter)
+(display (list (counter) (quote (a b)) "text"))
+(newline)
+
+(define (sum-list items)
+  (define (iter items total)
+    (if (eq? items '())
+        total
+        (iter (cdr items) (+ total (car items)))))
+  (iter items 0))
+(display (sum-list '(1 2 3 4 5)))
+(newline)
+(car 5)
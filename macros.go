@@ -0,0 +1,119 @@
+package scm
+
+// Macros are procedures that transform expressions. A macro is bound
+// in the environment like any other value, when the operator of a
+// combination evaluates to a macro its transformer is applied to the
+// unevaluated operands and the expansion is evaluated in place of the
+// combination. Macros are not hygienic, the expansion can capture
+// the names used where the macro is called.
+//
+// A combination is expanded the first time it is evaluated, the next
+// times its expansion is evaluated right away. It is expanded again
+// only when its operator is no longer the macro that expanded it. The
+// virtual machine is the exception, it expands a combination once when
+// it compiles it (see compiler.go).
+
+func make_macro(transformer *Value) *Value {
+	return list(make_name("macro"), transformer)
+}
+
+func is_macro(v *Value) *Value {
+	if is_tagged_list(v, "macro") {
+		return make_true()
+	}
+	return make_false()
+}
+
+func macro_transformer(macro *Value) *Value {
+	return cadr(macro)
+}
+
+// the expansion of a form, ready to be evaluated: its references
+// are addressed and it is analyzed when the analyzing evaluator is
+// used. macro is nil for a special form registered with
+// DefineSpecialForm
+type expansion struct {
+	macro *Value
+	exp   *Value
+}
+
+// the expansion of form by macro, nil when it was not expanded yet
+func (in *Interpreter) expansion_of(form *Value, macro *Value) *Value {
+	if e, ok := in.expansions[form]; ok && e.macro == macro {
+		return e.exp
+	}
+	return nil
+}
+
+// keep the expansion of form by macro, made ready to be evaluated in env
+func (in *Interpreter) keep_expansion(form *Value, macro *Value, exp *Value, env *Value) *Value {
+	exp = in.lexical_addresses(exp, env)
+	if in.analyzing {
+		exp = in.analyze(exp)
+	}
+	in.expansions[form] = &expansion{macro: macro, exp: exp}
+	return exp
+}
+
+// the primitive used by the expansion of define-macro
+func _make_macro(args *Value) *Value {
+	transformer := car(args)
+	if !test(is_compound_procedure(transformer)) && !test(is_primitive_procedure(transformer)) && !is_closure(transformer) && !test(is_compiled_procedure(transformer)) {
+		raise_error(WrongTypeError, "define-macro: the transformer is not a procedure", transformer)
+	}
+	return make_macro(transformer)
+}
+
+// (define-macro (name . params) body ...) or (define-macro name transformer)
+func is_define_macro(exp *Value) bool {
+	return is_tagged_list(exp, "define-macro")
+}
+
+// (defmacro name params body ...)
+func is_defmacro(exp *Value) bool {
+	return is_tagged_list(exp, "defmacro")
+}
+
+func define_macro_to_definition(exp *Value) *Value {
+	var transformer *Value
+	if isName(cadr(exp)) {
+		transformer = caddr(exp)
+	} else {
+		transformer = make_lambda(cdadr(exp), cddr(exp))
+	}
+	return make_macro_definition(definition_variable(exp), transformer)
+}
+
+func defmacro_to_definition(exp *Value) *Value {
+	return make_macro_definition(cadr(exp), make_lambda(caddr(exp), cdddr(exp)))
+}
+
+// (define name (make-macro transformer))
+func make_macro_definition(name *Value, transformer *Value) *Value {
+	make := make_quote(make_primitive_procedure(make_prim(_make_macro)))
+	return list(make_name("define"), name, make_application(make, list(transformer)))
+}
+
+// the macro named by the operator of exp, nil when exp
+// is not the use of a macro bound in env
+func macro_of(exp *Value, env *Value) *Value {
+	if !isPair(exp) || !isName(car(exp)) {
+		return nil
+	}
+	v := binding_value(car(exp), env)
+	if v == nil || !test(is_macro(v)) {
+		return nil
+	}
+	return v
+}
+
+// the value bound to variable in env, nil when it is not bound
+func binding_value(variable *Value, env *Value) *Value {
+	if frame, i := find_binding(variable, env); frame != nil {
+		return frame.vals[i]
+	}
+	if variable.ctx != nil {
+		return binding_value(variable.ctx.name, variable.ctx.env)
+	}
+	return nil
+}
@@ -0,0 +1,637 @@
+package scm
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// the numeric tower, from the bottom up:
+//
+//	Integer     exact, fits in an int64
+//	BigInteger  exact, an integer that overflowed an int64
+//	Rational    exact, a ratio of integers that is not an integer
+//	Float       inexact
+//
+// operations on exact numbers give exact results, a single inexact
+// operand makes the result inexact. Exact results are always stored
+// in the lowest kind that can represent them.
+
+func make_integer(n int64) *Value {
+	return &Value{
+		kind: Integer,
+		val:  n,
+	}
+}
+
+func make_float(f float64) *Value {
+	return &Value{
+		kind: Float,
+		val:  f,
+	}
+}
+
+// an exact integer, demoted to an Integer when it fits in an int64
+func make_big_integer(n *big.Int) *Value {
+	if n.IsInt64() {
+		return make_integer(n.Int64())
+	}
+	return &Value{
+		kind: BigInteger,
+		val:  n,
+	}
+}
+
+// an exact ratio, demoted to an integer when the denominator is one
+func make_rational(r *big.Rat) *Value {
+	if r.IsInt() {
+		return make_big_integer(new(big.Int).Set(r.Num()))
+	}
+	return &Value{
+		kind: Rational,
+		val:  r,
+	}
+}
+
+func isExact(v *Value) bool {
+	return v.kind == Integer || v.kind == BigInteger || v.kind == Rational
+}
+
+func isInteger(v *Value) bool {
+	switch v.kind {
+	case Integer, BigInteger:
+		return true
+	case Float:
+		f := v.val.(float64)
+		return !math.IsInf(f, 0) && f == math.Trunc(f)
+	}
+	return false
+}
+
+func toFloat(v *Value) float64 {
+	switch v.kind {
+	case Integer:
+		return float64(v.val.(int64))
+	case BigInteger:
+		f, _ := new(big.Float).SetInt(v.val.(*big.Int)).Float64()
+		return f
+	case Rational:
+		f, _ := v.val.(*big.Rat).Float64()
+		return f
+	case Float:
+		return v.val.(float64)
+	}
+	panic("not a number")
+}
+
+func toBigInt(v *Value) *big.Int {
+	switch v.kind {
+	case Integer:
+		return big.NewInt(v.val.(int64))
+	case BigInteger:
+		return v.val.(*big.Int)
+	case Float:
+		n, _ := big.NewFloat(v.val.(float64)).Int(nil)
+		return n
+	}
+	panic("not an integer")
+}
+
+func toRat(v *Value) *big.Rat {
+	switch v.kind {
+	case Integer:
+		return new(big.Rat).SetInt64(v.val.(int64))
+	case BigInteger:
+		return new(big.Rat).SetInt(v.val.(*big.Int))
+	case Rational:
+		return v.val.(*big.Rat)
+	case Float:
+		return new(big.Rat).SetFloat64(v.val.(float64))
+	}
+	panic("not a number")
+}
+
+// the external representation of an inexact number
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf.0"
+	case math.IsInf(f, -1):
+		return "-inf.0"
+	case math.IsNaN(f):
+		return "+nan.0"
+	}
+
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// check that every argument of the primitive name is a number
+func numberArgs(name string, args *Value) []*Value {
+	var nums []*Value
+
+	for ; !isNull(args); args = cdr(args) {
+		v := car(args)
+		if !isNumber(v) {
+			raise_error(WrongTypeError, name+": not a number", v)
+		}
+		nums = append(nums, v)
+	}
+	return nums
+}
+
+func integerArgs(name string, args *Value) []*Value {
+	nums := numberArgs(name, args)
+	for _, v := range nums {
+		if !isInteger(v) {
+			raise_error(WrongTypeError, name+": not an integer", v)
+		}
+	}
+	return nums
+}
+
+func arity(name string, nums []*Value, n int) {
+	if len(nums) != n {
+		raise_error(ArityError, name+": wrong number of arguments", make_integer(int64(len(nums))))
+	}
+}
+
+// the operation to use for a pair of operands, the
+// highest kind of the two in the tower
+func contagion(a *Value, b *Value) ValueKind {
+	if a.kind == Float || b.kind == Float {
+		return Float
+	}
+	if a.kind == Rational || b.kind == Rational {
+		return Rational
+	}
+	if a.kind == BigInteger || b.kind == BigInteger {
+		return BigInteger
+	}
+	return Integer
+}
+
+// binary operations, int64 operations fall back to big
+// integers when they overflow
+func add(a *Value, b *Value) *Value {
+	switch contagion(a, b) {
+	case Integer:
+		x := a.val.(int64)
+		y := b.val.(int64)
+		r := x + y
+		if (r > x) == (y > 0) {
+			return make_integer(r)
+		}
+		return make_big_integer(new(big.Int).Add(toBigInt(a), toBigInt(b)))
+	case BigInteger:
+		return make_big_integer(new(big.Int).Add(toBigInt(a), toBigInt(b)))
+	case Rational:
+		return make_rational(new(big.Rat).Add(toRat(a), toRat(b)))
+	}
+	return make_float(toFloat(a) + toFloat(b))
+}
+
+func sub(a *Value, b *Value) *Value {
+	switch contagion(a, b) {
+	case Integer:
+		x := a.val.(int64)
+		y := b.val.(int64)
+		r := x - y
+		if (r < x) == (y > 0) {
+			return make_integer(r)
+		}
+		return make_big_integer(new(big.Int).Sub(toBigInt(a), toBigInt(b)))
+	case BigInteger:
+		return make_big_integer(new(big.Int).Sub(toBigInt(a), toBigInt(b)))
+	case Rational:
+		return make_rational(new(big.Rat).Sub(toRat(a), toRat(b)))
+	}
+	return make_float(toFloat(a) - toFloat(b))
+}
+
+func multiply(a *Value, b *Value) *Value {
+	switch contagion(a, b) {
+	case Integer:
+		x := a.val.(int64)
+		y := b.val.(int64)
+		if x == 0 || y == 0 {
+			return make_integer(0)
+		}
+		r := x * y
+		if r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+			return make_integer(r)
+		}
+		return make_big_integer(new(big.Int).Mul(toBigInt(a), toBigInt(b)))
+	case BigInteger:
+		return make_big_integer(new(big.Int).Mul(toBigInt(a), toBigInt(b)))
+	case Rational:
+		return make_rational(new(big.Rat).Mul(toRat(a), toRat(b)))
+	}
+	return make_float(toFloat(a) * toFloat(b))
+}
+
+// the division of exact numbers is an exact rational
+func divide(a *Value, b *Value) *Value {
+	if contagion(a, b) == Float {
+		return make_float(toFloat(a) / toFloat(b))
+	}
+	if isZero(b) {
+		raise_error(DivideByZeroError, "/: division by zero", a)
+	}
+	if a.kind == Integer && b.kind == Integer {
+		x := a.val.(int64)
+		y := b.val.(int64)
+		if x%y == 0 && !(x == math.MinInt64 && y == -1) {
+			return make_integer(x / y)
+		}
+	}
+	return make_rational(new(big.Rat).Quo(toRat(a), toRat(b)))
+}
+
+// compare two numbers, the result is negative, zero or positive
+func compare(a *Value, b *Value) int {
+	switch contagion(a, b) {
+	case Integer:
+		x := a.val.(int64)
+		y := b.val.(int64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+		return 0
+	case BigInteger:
+		return toBigInt(a).Cmp(toBigInt(b))
+	case Rational:
+		return toRat(a).Cmp(toRat(b))
+	}
+
+	x := toFloat(a)
+	y := toFloat(b)
+	if x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+	return 0
+}
+
+func isZero(v *Value) bool {
+	return compare(v, make_integer(0)) == 0
+}
+
+func negative(v *Value) bool {
+	return compare(v, make_integer(0)) < 0
+}
+
+// an integer operation on integral operands, done on int64 when
+// possible and on big integers otherwise. The result is inexact
+// when any operand was inexact
+func integerOp(a *Value, b *Value, op func(x int64, y int64) (int64, bool), bigOp func(x *big.Int, y *big.Int) *big.Int) *Value {
+	if a.kind == Integer && b.kind == Integer {
+		if r, ok := op(a.val.(int64), b.val.(int64)); ok {
+			return make_integer(r)
+		}
+	}
+
+	r := make_big_integer(bigOp(toBigInt(a), toBigInt(b)))
+	if !isExact(a) || !isExact(b) {
+		return inexact(r)
+	}
+	return r
+}
+
+func exact(v *Value) *Value {
+	if isExact(v) {
+		return v
+	}
+
+	f := v.val.(float64)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		raise_error(WrongTypeError, "inexact->exact: no exact representation", v)
+	}
+	return make_rational(new(big.Rat).SetFloat64(f))
+}
+
+func inexact(v *Value) *Value {
+	return make_float(toFloat(v))
+}
+
+// parse the text of a number, with an optional radix (#x #o #b #d)
+// and exactness (#e #i) prefix. ok is false when the text is not
+// a number
+func parseNumber(text string) (v *Value, ok bool) {
+	radix := 10
+	exactness := byte(0)
+
+	for len(text) >= 2 && text[0] == '#' {
+		switch text[1] {
+		case 'x', 'X':
+			radix = 16
+		case 'o', 'O':
+			radix = 8
+		case 'b', 'B':
+			radix = 2
+		case 'd', 'D':
+			radix = 10
+		case 'e', 'E', 'i', 'I':
+			exactness = text[1] | 0x20
+		default:
+			return nil, false
+		}
+		text = text[2:]
+	}
+
+	switch text {
+	case "+inf.0":
+		v = make_float(math.Inf(1))
+	case "-inf.0":
+		v = make_float(math.Inf(-1))
+	case "+nan.0", "-nan.0":
+		v = make_float(math.NaN())
+	}
+
+	if v == nil && exactness == 'e' && radix == 10 {
+		// an exact decimal is read as the ratio it spells, #e0.1 is 1/10
+		if r, ok := new(big.Rat).SetString(strings.TrimPrefix(text, "+")); ok && !strings.ContainsAny(text, "xXpP_") {
+			return make_rational(r), true
+		}
+	}
+
+	if v == nil {
+		v, ok = parseReal(text, radix)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	if exactness == 'e' {
+		return exact(v), true
+	}
+	if exactness == 'i' {
+		return inexact(v), true
+	}
+	return v, true
+}
+
+func parseReal(text string, radix int) (*Value, bool) {
+	if text == "" || strings.HasPrefix(text, "+-") || strings.HasPrefix(text, "-+") {
+		return nil, false
+	}
+
+	// an integer
+	if n, ok := new(big.Int).SetString(strings.TrimPrefix(text, "+"), radix); ok {
+		return make_big_integer(n), true
+	}
+
+	// a ratio of integers
+	if i := strings.IndexByte(text, '/'); i > 0 {
+		num, ok := new(big.Int).SetString(strings.TrimPrefix(text[:i], "+"), radix)
+		if !ok {
+			return nil, false
+		}
+		den, ok := new(big.Int).SetString(text[i+1:], radix)
+		if !ok || den.Sign() <= 0 {
+			return nil, false
+		}
+		return make_rational(new(big.Rat).SetFrac(num, den)), true
+	}
+
+	// a decimal, only in radix 10
+	if radix != 10 || strings.ContainsAny(text, "xXpP_") {
+		return nil, false
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, false
+	}
+	return make_float(f), true
+}
+
+// numeric primitives
+func plus(args *Value) *Value {
+	sum := make_integer(0)
+	for _, v := range numberArgs("+", args) {
+		sum = add(sum, v)
+	}
+	return sum
+}
+
+func minus(args *Value) *Value {
+	nums := numberArgs("-", args)
+	if len(nums) == 0 {
+		raise_error(ArityError, "-: at least one argument is required")
+	}
+	if len(nums) == 1 {
+		return sub(make_integer(0), nums[0])
+	}
+
+	res := nums[0]
+	for _, v := range nums[1:] {
+		res = sub(res, v)
+	}
+	return res
+}
+
+func mul(args *Value) *Value {
+	res := make_integer(1)
+	for _, v := range numberArgs("*", args) {
+		res = multiply(res, v)
+	}
+	return res
+}
+
+func div(args *Value) *Value {
+	nums := numberArgs("/", args)
+	if len(nums) == 0 {
+		raise_error(ArityError, "/: at least one argument is required")
+	}
+	if len(nums) == 1 {
+		return divide(make_integer(1), nums[0])
+	}
+
+	res := nums[0]
+	for _, v := range nums[1:] {
+		res = divide(res, v)
+	}
+	return res
+}
+
+// compare every pair of adjacent arguments with ok
+func compareArgs(name string, args *Value, ok func(c int) bool) *Value {
+	nums := numberArgs(name, args)
+	for i := 1; i < len(nums); i++ {
+		if !ok(compare(nums[i-1], nums[i])) {
+			return make_false()
+		}
+	}
+	return make_true()
+}
+
+func num_eq(args *Value) *Value {
+	return compareArgs("=", args, func(c int) bool { return c == 0 })
+}
+
+func gt(args *Value) *Value {
+	return compareArgs(">", args, func(c int) bool { return c > 0 })
+}
+
+func lt(args *Value) *Value {
+	return compareArgs("<", args, func(c int) bool { return c < 0 })
+}
+
+func quotient(args *Value) *Value {
+	nums := integerArgs("quotient", args)
+	arity("quotient", nums, 2)
+	if isZero(nums[1]) {
+		raise_error(DivideByZeroError, "quotient: division by zero", nums[0])
+	}
+	return integerOp(nums[0], nums[1], func(x int64, y int64) (int64, bool) {
+		return x / y, !(x == math.MinInt64 && y == -1)
+	}, func(x *big.Int, y *big.Int) *big.Int {
+		return new(big.Int).Quo(x, y)
+	})
+}
+
+// the remainder has the sign of the dividend
+func remainder(args *Value) *Value {
+	nums := integerArgs("remainder", args)
+	arity("remainder", nums, 2)
+	if isZero(nums[1]) {
+		raise_error(DivideByZeroError, "remainder: division by zero", nums[0])
+	}
+	return integerOp(nums[0], nums[1], func(x int64, y int64) (int64, bool) {
+		if y == -1 {
+			return 0, true
+		}
+		return x % y, true
+	}, func(x *big.Int, y *big.Int) *big.Int {
+		return new(big.Int).Rem(x, y)
+	})
+}
+
+// the modulo has the sign of the divisor
+func modulo(args *Value) *Value {
+	nums := integerArgs("modulo", args)
+	arity("modulo", nums, 2)
+	if isZero(nums[1]) {
+		raise_error(DivideByZeroError, "modulo: division by zero", nums[0])
+	}
+	return integerOp(nums[0], nums[1], func(x int64, y int64) (int64, bool) {
+		if y == -1 {
+			return 0, true
+		}
+		m := x % y
+		if m != 0 && (m < 0) != (y < 0) {
+			m += y
+		}
+		return m, true
+	}, func(x *big.Int, y *big.Int) *big.Int {
+		m := new(big.Int).Rem(x, y)
+		if m.Sign() != 0 && m.Sign() != y.Sign() {
+			m.Add(m, y)
+		}
+		return m
+	})
+}
+
+func abs(args *Value) *Value {
+	nums := numberArgs("abs", args)
+	arity("abs", nums, 1)
+	if negative(nums[0]) {
+		return sub(make_integer(0), nums[0])
+	}
+	return nums[0]
+}
+
+// min and max are inexact when any argument is inexact
+func extremum(name string, args *Value, better func(c int) bool) *Value {
+	nums := numberArgs(name, args)
+	if len(nums) == 0 {
+		raise_error(ArityError, name+": at least one argument is required")
+	}
+
+	res := nums[0]
+	anyInexact := !isExact(res)
+	for _, v := range nums[1:] {
+		if !isExact(v) {
+			anyInexact = true
+		}
+		if better(compare(v, res)) {
+			res = v
+		}
+	}
+	if anyInexact {
+		return inexact(res)
+	}
+	return res
+}
+
+func _min(args *Value) *Value {
+	return extremum("min", args, func(c int) bool { return c < 0 })
+}
+
+func _max(args *Value) *Value {
+	return extremum("max", args, func(c int) bool { return c > 0 })
+}
+
+func gcd2(x int64, y int64) (int64, bool) {
+	if x == math.MinInt64 || y == math.MinInt64 {
+		return 0, false
+	}
+	if x < 0 {
+		x = -x
+	}
+	if y < 0 {
+		y = -y
+	}
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return x, true
+}
+
+func bigGcd(x *big.Int, y *big.Int) *big.Int {
+	return new(big.Int).GCD(nil, nil, new(big.Int).Abs(x), new(big.Int).Abs(y))
+}
+
+func gcd(args *Value) *Value {
+	res := make_integer(0)
+	for _, v := range integerArgs("gcd", args) {
+		res = integerOp(res, v, gcd2, bigGcd)
+	}
+	return res
+}
+
+func lcm(args *Value) *Value {
+	res := make_integer(1)
+	for _, v := range integerArgs("lcm", args) {
+		res = integerOp(res, v, func(x int64, y int64) (int64, bool) {
+			return 0, false
+		}, func(x *big.Int, y *big.Int) *big.Int {
+			if x.Sign() == 0 || y.Sign() == 0 {
+				return new(big.Int)
+			}
+			l := new(big.Int).Mul(x, y)
+			l.Abs(l)
+			return l.Quo(l, bigGcd(x, y))
+		})
+	}
+	return res
+}
+
+func exact_to_inexact(args *Value) *Value {
+	nums := numberArgs("exact->inexact", args)
+	arity("exact->inexact", nums, 1)
+	return inexact(nums[0])
+}
+
+func inexact_to_exact(args *Value) *Value {
+	nums := numberArgs("inexact->exact", args)
+	arity("inexact->exact", nums, 1)
+	return exact(nums[0])
+}
@@ -1,220 +1,169 @@
-package main
+package scm
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
 	"io"
-	"os"
-	"strconv"
-	"strings"
-	"unicode"
 )
 
-func openAndParse(filename string) (*Value, error) {
-	fileBytes, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, errors.New(fmt.Sprintf("there was a problem opening the file: %s", err))
+// the forms that the quote abbreviations stand for
+var abbreviations = map[TokenKind]string{
+	QuoteToken:           "quote",
+	QuasiquoteToken:      "quasiquote",
+	UnquoteToken:         "unquote",
+	UnquoteSplicingToken: "unquote-splicing",
+}
+
+// Reader reads one datum at a time from an input stream, so a
+// program can be evaluated while it is still being typed. Every
+// datum but names carries the position where it starts in the source,
+// the position of a name is kept by the pair it was read into.
+type Reader struct {
+	lex *Lexer
+}
+
+func newReader(r io.Reader, file string) *Reader {
+	return &Reader{
+		lex: newLexer(r, file),
 	}
+}
 
-	buf := bytes.NewBuffer(fileBytes)
-	tree, err := parse(buf)
+// read the next datum, io.EOF is returned when the input
+// is exhausted before a datum starts
+func (r *Reader) read() (*Value, error) {
+	tok, err := r.lex.next()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("parse error: %s", err))
+		return nil, err
 	}
 
-	return tree, nil
+	return r.readDatum(tok)
 }
 
-// top level parse
-func parse(buf *bytes.Buffer) (*Value, error) {
-	tree := nullValue
-	err := parseExp(&tree, buf)
+// read the datum that starts with tok
+func (r *Reader) readDatum(tok *Token) (*Value, error) {
+	switch tok.kind {
+	case LeftParenToken:
+		return r.readList(tok)
+	case RightParenToken:
+		return nil, r.lex.errorf(tok.pos, "unexpected )")
+	case DotToken:
+		return nil, r.lex.errorf(tok.pos, "unexpected .")
+	case QuoteToken, QuasiquoteToken, UnquoteToken, UnquoteSplicingToken:
+		// 'datum is read as (quote datum), `datum as (quasiquote datum)
+		// and so on
+		datum, at, err := r.readNext(tok.pos)
+		if err != nil {
+			return nil, err
+		}
+		abbreviation := withPos(list(make_name(abbreviations[tok.kind]), datum), tok.pos)
+		cdr(abbreviation).val.(*Pair).car_pos = &at
+		return abbreviation, nil
+	case StringToken:
+		return withPos(&Value{kind: String, val: tok.text}, tok.pos), nil
+	case CharacterToken:
+		return withPos(&Value{kind: Character, val: []rune(tok.text)[0]}, tok.pos), nil
+	case BooleanToken:
+		return withPos(&Value{kind: Boolean, val: tok.text == "#t"}, tok.pos), nil
+	case AtomToken:
+		return r.readAtom(tok)
+	}
 
-	if err != nil {
-		return nil, err
+	return nil, r.lex.errorf(tok.pos, "unexpected token %s", tok.kind)
+}
+
+// read a datum that must be there, the input cannot end before it.
+// The position where the datum starts is returned with it
+func (r *Reader) readNext(start Position) (*Value, Position, error) {
+	tok, err := r.lex.next()
+	if err == io.EOF {
+		return nil, start, r.lex.unexpectedEOF(start)
+	} else if err != nil {
+		return nil, start, err
 	}
-	return tree, nil
+
+	datum, err := r.readDatum(tok)
+	return datum, tok.pos, err
 }
 
-func parseExp(head **Value, buf *bytes.Buffer) error {
+// read the items of a list, the opening paren was already read
+func (r *Reader) readList(open *Token) (*Value, error) {
+	var items []*Value
+	var positions []Position
+	tail := nullValue
+
 	for {
-		b, err := buf.ReadByte()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
+		tok, err := r.lex.next()
+		if err == io.EOF {
+			return nil, r.lex.unexpectedEOF(open.pos)
+		} else if err != nil {
+			return nil, err
 		}
 
-		c := rune(b)
-		node := nullValue
-
-		if c == '(' {
-			// create a new list and recurse
-			err = parseExp(&node, buf)
-			if err != nil {
-				return err
-			}
-			node = cons(node, nullValue)
-		} else if c == ')' {
+		if tok.kind == RightParenToken {
 			// close the current list
 			break
-		} else if isSpace(c) {
-			// this is a space
-			continue
-		} else if isWhitespace(c) {
-			// ignore whitespace
-			continue
-		} else if c == '"' {
-			// reading a string constant
-			str, err := buf.ReadBytes(byte('"'))
-			if err != nil && err != io.EOF {
-				return err
-			}
-			val := &Value{
-				kind: String,
-				val:  strings.Trim(string(str), "\""),
-			}
-			node = cons(val, nullValue)
-		} else if c == '\'' {
-			// reading a symbol
-			var sym strings.Builder
-			var prev rune
-
-			for {
-				b, err := buf.ReadByte()
-				if err != nil && err != io.EOF {
-					return err
-				}
-
-				t := rune(b)
-				// keep reading until whitespace or closing paren
-				if isWhitespace(t) || t == ')' {
-					if prev != '(' {
-						buf.UnreadByte()
-					}
-					if t == ')' {
-						sym.WriteRune(t)
-					}
-					break
-				}
-				sym.WriteRune(t)
-				prev = t
-			}
+		}
 
-			val := &Value{
-				kind: Symbol,
-				val:  sym.String(),
-			}
-			node = cons(val, nullValue)
-		} else if c == ';' {
-			// this is a line comment
-			// consume the buffer until the newline
-			_, err = buf.ReadBytes(byte('\n'))
-			if err != nil && err != io.EOF {
-				return err
+		if tok.kind == DotToken {
+			// a dotted pair, exactly one datum before the closing paren
+			if len(items) == 0 {
+				return nil, r.lex.errorf(tok.pos, "unexpected .")
 			}
-		} else if isChar(c) {
-			err = buf.UnreadByte()
+			tail, _, err = r.readNext(open.pos)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			var token strings.Builder
-
-			for {
-				b, err := buf.ReadByte()
-				if err != nil && err != io.EOF {
-					return err
-				}
-
-				t := rune(b)
-				if !isChar(t) {
-					buf.UnreadByte()
-					break
-				}
-				token.WriteRune(t)
+			closing, err := r.lex.next()
+			if err == io.EOF {
+				return nil, r.lex.unexpectedEOF(open.pos)
+			} else if err != nil {
+				return nil, err
 			}
-
-			var val *Value
-			tok := token.String()
-
-			num, cerr := strconv.ParseInt(tok, 10, 64)
-			// try to parse an integer
-			if cerr == nil {
-				val = &Value{
-					kind: Integer,
-					val:  num,
-				}
-			} else {
-				// try to parse a float
-				fl, cerr := strconv.ParseFloat(tok, 64)
-				if cerr == nil {
-					val = &Value{
-						kind: Float,
-						val:  fl,
-					}
-				} else {
-					// try to parse a boolean
-					bl, cerr := strconv.ParseBool(tok)
-					if cerr == nil {
-						val = &Value{
-							kind: Boolean,
-							val:  bl,
-						}
-					} else {
-						// this is just a name
-						val = &Value{
-							kind: Name,
-							val:  strings.Trim(tok, " "),
-						}
-					}
-				}
+			if closing.kind != RightParenToken {
+				return nil, r.lex.errorf(closing.pos, "expected ) after the cdr of a dotted pair")
 			}
-
-			node = cons(val, nullValue)
-		} else {
-			panic(fmt.Sprintf("unrecognized token: %c", c))
+			break
 		}
 
-		if !isNull(node) {
-			if isNull(*head) {
-				// the first item in our parse tree
-				*head = node
-			} else {
-				*head = listAppend(*head, node)
-			}
+		item, err := r.readDatum(tok)
+		if err != nil {
+			return nil, err
 		}
+		items = append(items, item)
+		positions = append(positions, tok.pos)
 	}
 
-	return nil
+	// every pair of the list starts where its car starts, the first
+	// one at the paren. Each pair keeps where its car was read for
+	// the names, which have no position
+	result := tail
+	for i := len(items) - 1; i >= 0; i-- {
+		result = cons(items[i], result)
+		result.pos = &positions[i]
+		result.val.(*Pair).car_pos = &positions[i]
+	}
+	if isPair(result) {
+		result.pos = &open.pos
+	}
+
+	return result, nil
 }
 
-func isChar(c rune) bool {
-	if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '-' || c == '?' || c == '+' || c == '-' || c == '*' || c == '=' || c == '/' || c == '>' || c == '<' || c == '!' || c == '.' {
-		return true
+// an atom is either a number or a name
+func (r *Reader) readAtom(tok *Token) (*Value, error) {
+	if !isNumeric(tok.text) {
+		// names are interned, they are shared by every occurrence
+		// and the list they are read into keeps their position
+		return make_name(tok.text), nil
 	}
-	return false
-}
 
-func isSpace(c rune) bool {
-	if c == ' ' {
-		return true
+	num, ok := parseNumber(tok.text)
+	if !ok {
+		return nil, r.lex.errorf(tok.pos, "invalid number %s", tok.text)
 	}
-	return false
+	return withPos(num, tok.pos), nil
 }
 
-// replace with unicode.IsSpace()
-func isWhitespace(c rune) bool {
-	switch c {
-	case ' ':
-		return true
-	case '\n':
-		return true
-	case '\r':
-		return true
-	case '\t':
-		return true
-	}
-	return false
+// attach a source position to a datum
+func withPos(v *Value, pos Position) *Value {
+	v.pos = &pos
+	return v
 }
@@ -0,0 +1,651 @@
+package scm
+
+import "fmt"
+
+// The compiler of SICP 5.5, an expression is translated to the
+// instructions of the register machine that the evaluator runs on.
+// An instruction sequence records the registers it needs and the ones
+// it modifies, so that preserving only saves a register around a
+// sequence when the sequence clobbers it and the code that follows
+// needs it.
+//
+// Compiled code runs on the registers, the stack and the environments
+// of the evaluator. A compiled procedure is called by jumping to its
+// entry with the continuation in cont, as in SICP. Any other procedure
+// is applied by apply_dispatch with the continuation saved on the stack,
+// which is how the evaluator calls procedures, and apply_dispatch jumps
+// to the entry of a compiled procedure called by interpreted code. The
+// forms the compiler does not translate are evaluated by eval_dispatch
+// in the environment of the compiled code, and so are the expressions
+// that raise a syntax error, which is then signaled when they run.
+
+type registers uint8
+
+const (
+	env_register registers = 1 << iota
+	proc_register
+	val_register
+	argl_register
+	cont_register
+	exp_register
+	unev_register
+
+	all_registers = env_register | proc_register | val_register | argl_register |
+		cont_register | exp_register | unev_register
+)
+
+var register_bits = map[string]registers{
+	"env":  env_register,
+	"proc": proc_register,
+	"val":  val_register,
+	"argl": argl_register,
+	"cont": cont_register,
+	"exp":  exp_register,
+	"unev": unev_register,
+}
+
+// the registers in the order preserving saves them
+var preserved_registers = []string{"env", "proc", "val", "argl", "cont", "exp", "unev"}
+
+func register_bit(name *Value) registers {
+	return register_bits[name.val.(string)]
+}
+
+// a label, when text is a name, or an instruction. exps are the
+// expressions whose evaluation starts with the instruction, outermost
+// first, they leave their position and the last of them in the exp
+// register as eval_dispatch does. call is the combination whose
+// procedure the instruction starts to apply. where is the position of
+// the name the instruction looks up, names have no position of their own
+type statement struct {
+	text  *Value
+	exps  []*Value
+	call  *Value
+	where *Position
+}
+
+type instructionSequence struct {
+	needs      registers
+	modifies   registers
+	statements []statement
+}
+
+func make_instruction_sequence(needs registers, modifies registers, texts ...*Value) *instructionSequence {
+	seq := &instructionSequence{needs: needs, modifies: modifies}
+	for _, text := range texts {
+		seq.statements = append(seq.statements, statement{text: text})
+	}
+	return seq
+}
+
+func empty_instruction_sequence() *instructionSequence {
+	return &instructionSequence{}
+}
+
+// the parts of the instructions
+func instruction_text(name string, parts ...*Value) *Value {
+	return cons(make_name(name), list(parts...))
+}
+
+func reg_operand(name *Value) *Value {
+	return list(make_name("reg"), name)
+}
+
+func const_operand(v *Value) *Value {
+	return list(make_name("const"), v)
+}
+
+func label_operand(name *Value) *Value {
+	return list(make_name("label"), name)
+}
+
+func op_operand(name string) *Value {
+	return list(make_name("op"), make_name(name))
+}
+
+var (
+	val_target     = make_name("val")
+	proc_target    = make_name("proc")
+	next_linkage   = make_name("next")
+	return_linkage = make_name("return")
+)
+
+type machineCompiler struct {
+	in *Interpreter
+	// the names bound around the expression compiled, a global macro
+	// is only expanded while it is compiled when it is not shadowed
+	bound []*Value
+}
+
+// compile exp at the top level, its value goes to val and the code
+// returns to cont
+func (in *Interpreter) compile_for_machine(exp *Value) *instructionSequence {
+	c := &machineCompiler{in: in}
+	return c.compile(exp, val_target, return_linkage)
+}
+
+func (c *machineCompiler) make_label(name string) *Value {
+	c.in.label_counter++
+	return make_name(fmt.Sprintf("%s%d", name, c.in.label_counter))
+}
+
+func (c *machineCompiler) compile(exp *Value, target *Value, linkage *Value) (seq *instructionSequence) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*SchemeError); !ok {
+				panic(r)
+			}
+			// the evaluator signals the error when the expression runs
+			seq = c.compile_interpreted(exp, target, linkage)
+		}
+		mark_evaluated(seq, exp)
+	}()
+
+	switch {
+	case test(is_self_evaluating(exp)):
+		return c.compile_constant(exp, target, linkage)
+	case isName(exp):
+		return c.compile_variable(exp, target, linkage)
+	case c.in.special_form(exp) != nil:
+		return c.compile_special_form(exp, target, linkage)
+	case test(is_application(exp)):
+		return c.compile_application(exp, target, linkage)
+	}
+	return c.compile_interpreted(exp, target, linkage)
+}
+
+// the car of pair compiled, a name is looked up at the
+// position where it was read
+func (c *machineCompiler) compile_element(pair *Value, target *Value, linkage *Value) *instructionSequence {
+	seq := c.compile(car(pair), target, linkage)
+	if !isName(car(pair)) {
+		return seq
+	}
+	for i := range seq.statements {
+		if s := &seq.statements[i]; !isName(s.text) {
+			s.where = car_position(pair)
+			break
+		}
+	}
+	return seq
+}
+
+// the first instruction of seq starts to apply the procedure of the
+// combination exp, the errors of a primitive report its position
+func mark_call(seq *instructionSequence, exp *Value) *instructionSequence {
+	for i := range seq.statements {
+		if s := &seq.statements[i]; !isName(s.text) {
+			s.call = exp
+			break
+		}
+	}
+	return seq
+}
+
+func mark_evaluated(seq *instructionSequence, exp *Value) {
+	for i := range seq.statements {
+		if s := &seq.statements[i]; !isName(s.text) {
+			s.exps = append([]*Value{exp}, s.exps...)
+			return
+		}
+	}
+}
+
+func (c *machineCompiler) compile_special_form(exp *Value, target *Value, linkage *Value) *instructionSequence {
+	name := original_name(car(exp))
+	if _, ok := c.in.extensions[name]; ok {
+		return c.compile_interpreted(exp, target, linkage)
+	}
+
+	switch name.val.(string) {
+	case "quote":
+		return c.compile_constant(text_of_quotation(exp), target, linkage)
+	case "set!":
+		return c.compile_assignment(exp, "set-variable-value!", assignment_variable(exp), assignment_value(exp), target, linkage)
+	case "define":
+		return c.compile_assignment(exp, "define-variable!", definition_variable(exp), definition_value(exp), target, linkage)
+	case "if":
+		return c.compile_if(exp, target, linkage)
+	case "lambda":
+		return c.compile_lambda(exp, target, linkage)
+	case "begin":
+		return c.compile_sequence(begin_actions(exp), target, linkage)
+	case "and", "or":
+		return c.compile_logical(exp, name.val.(string) == "and", target, linkage)
+	case "quasiquote":
+		return c.compile(quasiquote_to_combination(exp), target, linkage)
+	case "let":
+		return c.compile(let_to_combination(exp), target, linkage)
+	case "let*":
+		return c.compile(let_star_to_nested_lets(exp), target, linkage)
+	case "letrec", "letrec*":
+		return c.compile(letrec_to_let(exp), target, linkage)
+	case "cond":
+		return c.compile(cond_to_if(exp), target, linkage)
+	case "when":
+		return c.compile(when_to_if(exp), target, linkage)
+	case "unless":
+		return c.compile(unless_to_if(exp), target, linkage)
+	case "case":
+		return c.compile(case_to_cond(exp), target, linkage)
+	case "do":
+		return c.compile(do_to_named_let(exp), target, linkage)
+	case "guard":
+		return c.compile(guard_to_combination(
+			exp,
+			c.in.machine_procedure("call/cc"),
+			c.in.machine_procedure("with-exception-handler"),
+			c.in.machine_procedure("raise-continuable")), target, linkage)
+	case "define-macro":
+		return c.compile(define_macro_to_definition(exp), target, linkage)
+	case "defmacro":
+		return c.compile(defmacro_to_definition(exp), target, linkage)
+	}
+	// define-syntax, let-syntax and letrec-syntax make their macros in
+	// the environment the code runs in
+	return c.compile_interpreted(exp, target, linkage)
+}
+
+// the code that follows a sequence
+func (c *machineCompiler) compile_linkage(linkage *Value) *instructionSequence {
+	switch linkage {
+	case return_linkage:
+		return make_instruction_sequence(cont_register, 0,
+			instruction_text("goto", reg_operand(make_name("cont"))))
+	case next_linkage:
+		return empty_instruction_sequence()
+	}
+	return make_instruction_sequence(0, 0,
+		instruction_text("goto", label_operand(linkage)))
+}
+
+func (c *machineCompiler) end_with_linkage(linkage *Value, seq *instructionSequence) *instructionSequence {
+	return preserving(cont_register, seq, c.compile_linkage(linkage))
+}
+
+func (c *machineCompiler) compile_constant(v *Value, target *Value, linkage *Value) *instructionSequence {
+	return c.end_with_linkage(linkage, make_instruction_sequence(0, register_bit(target),
+		instruction_text("assign", target, const_operand(v))))
+}
+
+func (c *machineCompiler) compile_variable(name *Value, target *Value, linkage *Value) *instructionSequence {
+	return c.end_with_linkage(linkage, make_instruction_sequence(env_register, register_bit(target),
+		instruction_text("assign", target, op_operand("lookup-variable-value"), const_operand(name), reg_operand(make_name("env")))))
+}
+
+// set! and define, op is the operation that changes the environment
+func (c *machineCompiler) compile_assignment(exp *Value, op string, name *Value, value *Value, target *Value, linkage *Value) *instructionSequence {
+	var get_value_code *instructionSequence
+	if isName(cadr(exp)) {
+		get_value_code = c.compile_element(cddr(exp), val_target, next_linkage)
+	} else {
+		get_value_code = c.compile(value, val_target, next_linkage)
+	}
+	return c.end_with_linkage(linkage, preserving(env_register, get_value_code,
+		make_instruction_sequence(env_register|val_register, register_bit(target),
+			instruction_text("perform", op_operand(op), const_operand(name), reg_operand(val_target), reg_operand(make_name("env"))),
+			instruction_text("assign", target, const_operand(constant("ok"))))))
+}
+
+func (c *machineCompiler) compile_if(exp *Value, target *Value, linkage *Value) *instructionSequence {
+	f_branch := c.make_label("false-branch")
+	after_if := c.make_label("after-if")
+	consequent_linkage := linkage
+	if linkage == next_linkage {
+		consequent_linkage = after_if
+	}
+
+	p_code := c.compile_element(cdr(exp), val_target, next_linkage)
+	c_code := c.compile_element(cddr(exp), target, consequent_linkage)
+	var a_code *instructionSequence
+	if !isNull(cdddr(exp)) {
+		a_code = c.compile_element(cdddr(exp), target, linkage)
+	} else {
+		a_code = c.compile(if_alternative(exp), target, linkage)
+	}
+	code := preserving(env_register|cont_register, p_code, append_instruction_sequences(
+		make_instruction_sequence(val_register, 0,
+			instruction_text("test", op_operand("false?"), reg_operand(val_target)),
+			instruction_text("branch", label_operand(f_branch))),
+		parallel_instruction_sequences(
+			c_code,
+			append_instruction_sequences(make_instruction_sequence(0, 0, f_branch), a_code))))
+	return c.then_label(code, linkage, after_if)
+}
+
+// the label that the parts of an expression compiled with the next
+// linkage go to, it is left out when nothing jumps to it
+func (c *machineCompiler) then_label(code *instructionSequence, linkage *Value, label *Value) *instructionSequence {
+	if linkage != next_linkage {
+		return code
+	}
+	return append_instruction_sequences(code, make_instruction_sequence(0, 0, label))
+}
+
+// and and or test the value of every operand but the last, which is
+// compiled with the linkage of the expression. The value that ends
+// the test goes to the exit
+func (c *machineCompiler) compile_logical(exp *Value, and bool, target *Value, linkage *Value) *instructionSequence {
+	operands := logical_operands(exp)
+	if !isPair(operands) {
+		return c.compile_constant(&Value{kind: Boolean, val: and}, target, linkage)
+	}
+	if !isPair(cdr(operands)) {
+		// nothing is tested and nothing goes to the exit
+		return c.compile_element(operands, target, linkage)
+	}
+
+	exit := c.make_label("logical-exit")
+	after := c.make_label("after-logical")
+	last_linkage := linkage
+	if linkage == next_linkage {
+		last_linkage = after
+	}
+	decides := "true?"
+	if and {
+		decides = "false?"
+	}
+
+	var codes []*instructionSequence
+	for ; isPair(cdr(operands)); operands = cdr(operands) {
+		codes = append(codes, c.compile_element(operands, val_target, next_linkage))
+	}
+	code := c.compile_element(operands, target, last_linkage)
+	for i := len(codes) - 1; i >= 0; i-- {
+		code = preserving(env_register|cont_register, codes[i], append_instruction_sequences(
+			make_instruction_sequence(val_register, 0,
+				instruction_text("test", op_operand(decides), reg_operand(val_target)),
+				instruction_text("branch", label_operand(exit))),
+			code))
+	}
+
+	exit_code := empty_instruction_sequence()
+	if target != val_target {
+		exit_code = make_instruction_sequence(val_register, register_bit(target),
+			instruction_text("assign", target, reg_operand(val_target)))
+	}
+	return c.then_label(
+		parallel_instruction_sequences(code,
+			append_instruction_sequences(make_instruction_sequence(0, 0, exit), c.end_with_linkage(linkage, exit_code))),
+		linkage, after)
+}
+
+func (c *machineCompiler) compile_sequence(exps *Value, target *Value, linkage *Value) *instructionSequence {
+	if !isPair(cdr(exps)) {
+		// the evaluator fails on the empty sequence the same way
+		return c.compile_element(exps, target, linkage)
+	}
+	return preserving(env_register|cont_register,
+		c.compile_element(exps, target, next_linkage),
+		c.compile_sequence(cdr(exps), target, linkage))
+}
+
+func (c *machineCompiler) compile_lambda(exp *Value, target *Value, linkage *Value) *instructionSequence {
+	proc_entry := c.make_label("entry")
+	after_lambda := c.make_label("after-lambda")
+	lambda_linkage := linkage
+	if linkage == next_linkage {
+		lambda_linkage = after_lambda
+	}
+	return c.then_label(
+		tack_on_instruction_sequence(
+			c.end_with_linkage(lambda_linkage, make_instruction_sequence(env_register, register_bit(target),
+				instruction_text("assign", target, op_operand("make-compiled-procedure"), label_operand(proc_entry), reg_operand(make_name("env"))))),
+			c.compile_lambda_body(exp, proc_entry)),
+		linkage, after_lambda)
+}
+
+func (c *machineCompiler) compile_lambda_body(exp *Value, proc_entry *Value) *instructionSequence {
+	formals := lambda_parameters(exp)
+	bound := c.bound
+	defer func() {
+		c.bound = bound
+	}()
+	params := formals
+	for ; isPair(params); params = cdr(params) {
+		c.bound = append(c.bound, car(params))
+	}
+	if isName(params) {
+		c.bound = append(c.bound, params)
+	}
+
+	return append_instruction_sequences(
+		make_instruction_sequence(env_register|proc_register|argl_register, env_register,
+			proc_entry,
+			instruction_text("assign", make_name("env"), op_operand("compiled-procedure-env"), reg_operand(proc_target)),
+			instruction_text("assign", make_name("env"), op_operand("extend-environment"), const_operand(formals), reg_operand(make_name("argl")), reg_operand(make_name("env")))),
+		c.compile_sequence(scan_out_defines(lambda_body(exp)), val_target, return_linkage))
+}
+
+func (c *machineCompiler) is_bound(name *Value) bool {
+	for i := len(c.bound) - 1; i >= 0; i-- {
+		if c.bound[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// the lexical binding of a name where the combination compiled is, a
+// name bound around it is its own binding
+func (c *machineCompiler) binding(name *Value) interface{} {
+	if c.is_bound(name) {
+		return name
+	}
+	if name.ctx != nil {
+		return lexical_binding(name.ctx.name, name.ctx.env)
+	}
+	return nil
+}
+
+// a global macro that is defined when the combination is compiled is
+// expanded then, the others are tested for when the operator has been
+// evaluated, because a macro can be defined after a procedure using it.
+// The expansion runs only while the operator is still the macro that
+// made it, after the macro is redefined the combination is applied or
+// expanded again like the evaluator does
+func (c *machineCompiler) compile_application(exp *Value, target *Value, linkage *Value) *instructionSequence {
+	op := operator(exp)
+	may_be_macro := isName(op) && !c.is_bound(op)
+	var macro, expansion *Value
+	if may_be_macro {
+		if m := binding_value(op, c.in.global); m != nil && test(is_macro(m)) {
+			c.in.use_binding = c.binding
+			e, err := c.in.apply_at_compile_time(macro_transformer(m), operands(exp))
+			c.in.use_binding = nil
+			if err != nil {
+				return c.compile_interpreted(exp, target, linkage)
+			}
+			macro, expansion = m, e
+		}
+	}
+
+	proc_code := c.compile_element(exp, proc_target, next_linkage)
+	var operand_codes []*instructionSequence
+	for operands := operands(exp); isPair(operands); operands = cdr(operands) {
+		operand_codes = append(operand_codes, c.compile_element(operands, val_target, next_linkage))
+	}
+	if !may_be_macro {
+		return preserving(env_register|cont_register, proc_code, preserving(proc_register|cont_register,
+			c.construct_arglist(operand_codes),
+			mark_call(c.compile_procedure_call(target, linkage), exp)))
+	}
+
+	macro_call := c.make_label("macro-call")
+	after_call := c.make_label("after-combination")
+	call_linkage := linkage
+	if linkage == next_linkage {
+		call_linkage = after_call
+	}
+	var expansion_code *instructionSequence
+	if expansion != nil {
+		expansion_code = c.compile(expansion, target, call_linkage)
+	}
+	call_code := preserving(proc_register|cont_register,
+		c.construct_arglist(operand_codes),
+		mark_call(c.compile_procedure_call(target, call_linkage), exp))
+	// the evaluator expands the unevaluated operands, the combination
+	// is expanded only the first time
+	macro_code := c.compile_call(target, call_linkage, proc_register|env_register,
+		instruction_text("assign", make_name("exp"), const_operand(exp)),
+		instruction_text("assign", make_name("unev"), const_operand(operands(exp))),
+		instruction_text("save", make_name("cont")),
+		instruction_text("goto", label_operand(make_name("ev-macro"))))
+	code := append_instruction_sequences(
+		mark_call(make_instruction_sequence(proc_register, 0,
+			instruction_text("test", op_operand("macro?"), reg_operand(proc_target)),
+			instruction_text("branch", label_operand(macro_call))), exp),
+		parallel_instruction_sequences(
+			call_code,
+			append_instruction_sequences(make_instruction_sequence(0, 0, macro_call), macro_code)))
+	if expansion_code != nil {
+		expanded := c.make_label("expanded")
+		code = append_instruction_sequences(
+			make_instruction_sequence(proc_register, 0,
+				instruction_text("test", op_operand("eq?"), reg_operand(proc_target), const_operand(macro)),
+				instruction_text("branch", label_operand(expanded))),
+			parallel_instruction_sequences(
+				code,
+				append_instruction_sequences(make_instruction_sequence(0, 0, expanded), expansion_code)))
+	}
+	return c.then_label(preserving(env_register|cont_register, proc_code, code), linkage, after_call)
+}
+
+// the operands are evaluated from left to right like the evaluator
+// does, every value is added at the end of argl
+func (c *machineCompiler) construct_arglist(operand_codes []*instructionSequence) *instructionSequence {
+	if len(operand_codes) == 0 {
+		return make_instruction_sequence(0, argl_register,
+			instruction_text("assign", make_name("argl"), const_operand(nullValue)))
+	}
+	code := append_instruction_sequences(operand_codes[0],
+		make_instruction_sequence(val_register, argl_register,
+			instruction_text("assign", make_name("argl"), op_operand("list"), reg_operand(val_target))))
+	for _, operand_code := range operand_codes[1:] {
+		next := preserving(argl_register, operand_code,
+			make_instruction_sequence(val_register|argl_register, argl_register,
+				instruction_text("assign", make_name("argl"), op_operand("adjoin-arg"), reg_operand(val_target), reg_operand(make_name("argl")))))
+		code = preserving(env_register, code, next)
+	}
+	return code
+}
+
+// primitives are applied in line, compiled procedures are jumped to
+// and the other procedures are applied by apply_dispatch
+func (c *machineCompiler) compile_procedure_call(target *Value, linkage *Value) *instructionSequence {
+	primitive_branch := c.make_label("primitive-branch")
+	compiled_branch := c.make_label("compiled-branch")
+	after_call := c.make_label("after-call")
+	compiled_linkage := linkage
+	if linkage == next_linkage {
+		compiled_linkage = after_call
+	}
+
+	code := append_instruction_sequences(
+		make_instruction_sequence(proc_register, 0,
+			instruction_text("test", op_operand("primitive-procedure?"), reg_operand(proc_target)),
+			instruction_text("branch", label_operand(primitive_branch)),
+			instruction_text("test", op_operand("compiled-procedure?"), reg_operand(proc_target)),
+			instruction_text("branch", label_operand(compiled_branch))),
+		parallel_instruction_sequences(
+			c.compile_call(target, compiled_linkage, proc_register|argl_register,
+				instruction_text("save", make_name("cont")),
+				instruction_text("goto", label_operand(make_name("apply-dispatch")))),
+			parallel_instruction_sequences(
+				append_instruction_sequences(make_instruction_sequence(0, 0, compiled_branch),
+					c.compile_call(target, compiled_linkage, proc_register,
+						instruction_text("assign", val_target, op_operand("compiled-procedure-entry"), reg_operand(proc_target)),
+						instruction_text("goto", reg_operand(val_target)))),
+				append_instruction_sequences(make_instruction_sequence(0, 0, primitive_branch),
+					c.end_with_linkage(linkage, make_instruction_sequence(proc_register|argl_register, register_bit(target),
+						instruction_text("assign", target, op_operand("apply-primitive-procedure"), reg_operand(proc_target), reg_operand(make_name("argl")))))))))
+	return c.then_label(code, linkage, after_call)
+}
+
+// jump runs code that leaves a value in val and goes to the label in
+// cont, as the entry of a compiled procedure does. The continuation is
+// the label of the linkage, or the one in cont for the return linkage
+func (c *machineCompiler) compile_call(target *Value, linkage *Value, needs registers, jump ...*Value) *instructionSequence {
+	switch {
+	case target == val_target && linkage != return_linkage:
+		texts := append([]*Value{instruction_text("assign", make_name("cont"), label_operand(linkage))}, jump...)
+		return make_instruction_sequence(needs, all_registers, texts...)
+	case target != val_target && linkage != return_linkage:
+		proc_return := c.make_label("proc-return")
+		texts := append([]*Value{instruction_text("assign", make_name("cont"), label_operand(proc_return))}, jump...)
+		texts = append(texts,
+			proc_return,
+			instruction_text("assign", target, reg_operand(val_target)),
+			instruction_text("goto", label_operand(linkage)))
+		return make_instruction_sequence(needs, all_registers, texts...)
+	case target == val_target && linkage == return_linkage:
+		return make_instruction_sequence(needs|cont_register, all_registers, jump...)
+	}
+	panic(fmt.Sprintf("return linkage, target not val %s", target))
+}
+
+// exp is evaluated by the evaluator in the environment of the code
+func (c *machineCompiler) compile_interpreted(exp *Value, target *Value, linkage *Value) *instructionSequence {
+	if linkage == next_linkage {
+		after_eval := c.make_label("after-eval")
+		return append_instruction_sequences(
+			c.compile_interpreted(exp, target, after_eval),
+			make_instruction_sequence(0, 0, after_eval))
+	}
+	return c.compile_call(target, linkage, env_register,
+		instruction_text("assign", make_name("exp"), const_operand(exp)),
+		instruction_text("goto", label_operand(make_name("eval-dispatch"))))
+}
+
+// combining instruction sequences
+
+func append_instruction_sequences(seqs ...*instructionSequence) *instructionSequence {
+	result := empty_instruction_sequence()
+	for _, seq := range seqs {
+		result = &instructionSequence{
+			needs:      result.needs | (seq.needs &^ result.modifies),
+			modifies:   result.modifies | seq.modifies,
+			statements: append(append([]statement(nil), result.statements...), seq.statements...),
+		}
+	}
+	return result
+}
+
+// seq1 followed by seq2, the registers of regs that seq2 needs are
+// saved around seq1 when seq1 modifies them
+func preserving(regs registers, seq1 *instructionSequence, seq2 *instructionSequence) *instructionSequence {
+	for _, name := range preserved_registers {
+		r := register_bits[name]
+		if regs&r == 0 || seq2.needs&r == 0 || seq1.modifies&r == 0 {
+			continue
+		}
+		statements := []statement{{text: instruction_text("save", make_name(name))}}
+		statements = append(statements, seq1.statements...)
+		statements = append(statements, statement{text: instruction_text("restore", make_name(name))})
+		seq1 = &instructionSequence{
+			needs:      seq1.needs | r,
+			modifies:   seq1.modifies &^ r,
+			statements: statements,
+		}
+	}
+	return append_instruction_sequences(seq1, seq2)
+}
+
+// body is the code of a procedure, it is not executed where it is
+// placed and does not change the registers the sequence needs
+func tack_on_instruction_sequence(seq *instructionSequence, body *instructionSequence) *instructionSequence {
+	return &instructionSequence{
+		needs:      seq.needs,
+		modifies:   seq.modifies,
+		statements: append(append([]statement(nil), seq.statements...), body.statements...),
+	}
+}
+
+// the two branches of a test, only one of them runs
+func parallel_instruction_sequences(seq1 *instructionSequence, seq2 *instructionSequence) *instructionSequence {
+	return &instructionSequence{
+		needs:      seq1.needs | seq2.needs,
+		modifies:   seq1.modifies | seq2.modifies,
+		statements: append(append([]statement(nil), seq1.statements...), seq2.statements...),
+	}
+}
@@ -0,0 +1,126 @@
+package scm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	replPrompt = "scm> "
+)
+
+// Repl runs a read-eval-print loop over input, one datum at a time.
+// Definitions persist across errors. Input that ends in the middle of a
+// datum stops the loop with a *ParseError. When statistics are on, the
+// pushes and the maximum depth of the stack are printed after every
+// value.
+// Lines starting with a comma are meta-commands:
+//
+//	,quit         leave the loop
+//	,load <file>  evaluate every expression in file
+//	,env          list the names bound in the global environment
+func (in *Interpreter) Repl(input io.Reader) error {
+	reader := newReader(input, "")
+
+	for {
+		fmt.Fprint(in.out, replPrompt)
+
+		err := reader.lex.skipSpace()
+		if err == io.EOF {
+			fmt.Fprintln(in.out)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		c, err := reader.lex.readRune()
+		if err != nil {
+			return err
+		}
+		if c == ',' {
+			line, err := reader.lex.readLine()
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if quit := in.metaCommand(strings.TrimSpace(line)); quit {
+				return nil
+			}
+			continue
+		}
+		reader.lex.unreadRune()
+
+		datum, err := reader.read()
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				fmt.Fprintln(in.out)
+				return err
+			}
+			fmt.Fprintf(in.out, "parse error: %s\n", err)
+			reader.lex.skipLine()
+			continue
+		}
+
+		if in.stats.counting {
+			in.stats.reset(in.machine_registers())
+		}
+		if err := in.evaluate(datum); err != nil {
+			in.printError(err)
+			continue
+		}
+		in.user_print(reg(in.val))
+		fmt.Fprintln(in.out)
+		if in.stats.counting {
+			// like the monitored stack of SICP 5.2.4
+			fmt.Fprintf(in.out, "(total-pushes = %d maximum-depth = %d)\n", in.stats.pushes, in.stats.max_depth)
+		}
+	}
+}
+
+// run a meta-command, returns true when the loop should stop
+func (in *Interpreter) metaCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		fmt.Fprintln(in.out, "missing meta-command")
+		return false
+	}
+
+	switch fields[0] {
+	case "quit":
+		return true
+	case "load":
+		if len(fields) != 2 {
+			fmt.Fprintln(in.out, "usage: ,load <file>")
+			return false
+		}
+		in.load(fields[1])
+	case "env":
+		// the most recent definitions first
+		vars := frame_variables(first_frame(in.global))
+		for i := len(vars) - 1; i >= 0; i-- {
+			fmt.Fprintln(in.out, vars[i])
+		}
+	default:
+		fmt.Fprintf(in.out, "unknown meta-command: %s\n", fields[0])
+	}
+
+	return false
+}
+
+func (in *Interpreter) load(filename string) {
+	if _, err := in.EvalFile(filename); err != nil {
+		in.printError(err)
+		return
+	}
+	fmt.Fprintf(in.out, "loaded %s\n", filename)
+}
+
+func (in *Interpreter) printError(err error) {
+	var serr *SchemeError
+	if errors.As(err, &serr) && serr.Exp != nil {
+		fmt.Fprintf(in.out, "error: %s\n  in expression: %s\n", serr, serr.Exp)
+		return
+	}
+	fmt.Fprintf(in.out, "error: %s\n", err)
+}
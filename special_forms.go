@@ -0,0 +1,87 @@
+package scm
+
+import "fmt"
+
+// SpecialForm transforms an expression whose operator is the name of
+// the form into the expression that is evaluated in its place. The
+// expansion is evaluated in the environment of the original expression.
+type SpecialForm func(exp *Value) (*Value, error)
+
+// the special forms of the evaluator, eval_dispatch finds the label
+// for an expression by the interned name of its operator. A special
+// form cannot be shadowed by a variable
+func (in *Interpreter) install_special_forms() {
+	forms := map[string]*Value{
+		"quote":         label(in.ev_quoted),
+		"quasiquote":    label(in.ev_quasiquote),
+		"set!":          label(in.ev_assignment),
+		"define":        label(in.ev_definition),
+		"if":            label(in.ev_if),
+		"lambda":        label(in.ev_lambda),
+		"let":           label(in.ev_let),
+		"let*":          label(in.ev_let_star),
+		"letrec":        label(in.ev_letrec),
+		"letrec*":       label(in.ev_letrec),
+		"cond":          label(in.ev_cond),
+		"and":           label(in.ev_and),
+		"or":            label(in.ev_or),
+		"when":          label(in.ev_when),
+		"unless":        label(in.ev_unless),
+		"case":          label(in.ev_case),
+		"do":            label(in.ev_do),
+		"define-syntax": label(in.ev_define_syntax),
+		"let-syntax":    label(in.ev_let_syntax),
+		"letrec-syntax": label(in.ev_letrec_syntax),
+		"define-macro":  label(in.ev_define_macro),
+		"defmacro":      label(in.ev_defmacro),
+		"guard":         label(in.ev_guard),
+		"begin":         label(in.ev_begin),
+	}
+
+	in.special_forms = map[*Value]*Value{}
+	for name, form := range forms {
+		in.special_forms[make_name(name)] = form
+	}
+	in.extensions = map[*Value]SpecialForm{}
+}
+
+// the label for exp when it is a special form, nil otherwise
+func (in *Interpreter) special_form(exp *Value) *Value {
+	if !isPair(exp) || !isName(car(exp)) {
+		return nil
+	}
+	return in.special_forms[original_name(car(exp))]
+}
+
+// DefineSpecialForm registers the special form called name. The names
+// of the built-in special forms and of forms already registered cannot
+// be used again. An error returned by form is signaled as a syntax error
+// by the program being evaluated.
+func (in *Interpreter) DefineSpecialForm(name string, form SpecialForm) error {
+	sym := make_name(name)
+	if _, ok := in.special_forms[sym]; ok {
+		return fmt.Errorf("%s is already a special form", name)
+	}
+	in.extensions[sym] = form
+	in.special_forms[sym] = label(in.ev_extension)
+	return nil
+}
+
+// a special form registered with DefineSpecialForm, expanded
+// the first time it is evaluated
+func (in *Interpreter) ev_extension() {
+	if exp := in.expansion_of(reg(in.exp), nil); exp != nil {
+		assign(in.exp, exp)
+		in.go_to(label(in.eval_dispatch))
+		return
+	}
+	form := in.extensions[original_name(car(reg(in.exp)))]
+	in.in_primitive = true
+	expansion, err := form(strip_lexical_addresses(reg(in.exp)))
+	in.in_primitive = false
+	if err != nil {
+		raise_error(SyntaxError, err.Error(), reg(in.exp))
+	}
+	assign(in.exp, in.keep_expansion(reg(in.exp), nil, expansion, reg(in.env)))
+	in.go_to(label(in.eval_dispatch))
+}
@@ -0,0 +1,140 @@
+package scm
+
+import (
+	"errors"
+	"testing"
+)
+
+// the ways an interpreter can run a program
+var modes = []struct {
+	name string
+	set  func(in *Interpreter)
+}{
+	{"explicit-control", func(in *Interpreter) {}},
+	{"scan", func(in *Interpreter) { in.SetLexicalAddressing(false) }},
+	{"analyze", func(in *Interpreter) { in.SetAnalyzing(true) }},
+	{"vm", func(in *Interpreter) { in.SetBytecode(true) }},
+	{"compiled", func(in *Interpreter) { in.SetCompiled(true) }},
+}
+
+// (unless-zero n body ...) => (if (= n 0) 0 (begin body ...))
+func unlessZero(exp *Value) (*Value, error) {
+	if !isPair(cdr(exp)) {
+		return nil, errors.New("unless-zero: missing operand")
+	}
+	n := Car(Cdr(exp))
+	body := Cdr(Cdr(exp))
+	zero, _ := ValueOf(0)
+	test := List(MakeName("="), n, zero)
+	return List(MakeName("if"), test, zero, Cons(MakeName("begin"), body)), nil
+}
+
+func TestDefineSpecialForm(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := New()
+			mode.set(in)
+			if err := in.DefineSpecialForm("unless-zero", unlessZero); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				src  string
+				want string
+			}{
+				{"(unless-zero 0 (car '()))", "0"},
+				{"(unless-zero 5 'a 'b)", "b"},
+				// in the body of a procedure, where its operand is a
+				// local variable
+				{"(define (f n) (unless-zero n (* n 10))) (list (f 0) (f 3))", "(0 30)"},
+				// the expansion is evaluated where the form is
+				{"(let ((x 2)) (unless-zero x (+ x 1)))", "3"},
+			}
+			for _, test := range tests {
+				v, err := in.EvalString(test.src)
+				if err != nil {
+					t.Errorf("%s: %v", test.src, err)
+					continue
+				}
+				if v.String() != test.want {
+					t.Errorf("%s: got %s, want %s", test.src, v, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefineSpecialFormError(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := New()
+			mode.set(in)
+			if err := in.DefineSpecialForm("unless-zero", unlessZero); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := in.EvalString("(define (g) (unless-zero)) (g)")
+			var serr *SchemeError
+			if !errors.As(err, &serr) {
+				t.Fatalf("got %v, want a *SchemeError", err)
+			}
+			if serr.Kind != SyntaxError || serr.Message != "unless-zero: missing operand" {
+				t.Errorf("got %s error %q", serr.Kind, serr.Message)
+			}
+
+			// the interpreter goes on after the error
+			v, err := in.EvalString("(unless-zero 1 'ok)")
+			if err != nil || v.String() != "ok" {
+				t.Errorf("after the error: got %v, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestDefineSpecialFormNames(t *testing.T) {
+	in := New()
+	for _, name := range []string{"if", "lambda", "define-syntax"} {
+		if err := in.DefineSpecialForm(name, unlessZero); err == nil {
+			t.Errorf("%s was registered again", name)
+		}
+	}
+	if err := in.DefineSpecialForm("unless-zero", unlessZero); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.DefineSpecialForm("unless-zero", unlessZero); err == nil {
+		t.Error("unless-zero was registered twice")
+	}
+
+	// the built-in form still works
+	v, err := in.EvalString("(if #f 1 2)")
+	if err != nil || v.String() != "2" {
+		t.Errorf("if: got %v, %v", v, err)
+	}
+}
+
+// a form is expanded the first time it is evaluated, however many
+// times the procedure it is in runs
+func TestDefineSpecialFormExpandedOnce(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			in := New()
+			mode.set(in)
+			calls := 0
+			counted := func(exp *Value) (*Value, error) {
+				calls++
+				return unlessZero(exp)
+			}
+			if err := in.DefineSpecialForm("unless-zero", counted); err != nil {
+				t.Fatal(err)
+			}
+
+			v, err := in.EvalString("(define (f n) (unless-zero n (* n 10))) (list (f 0) (f 1) (f 2) (f 3))")
+			if err != nil || v.String() != "(0 10 20 30)" {
+				t.Fatalf("got %v, %v", v, err)
+			}
+			if calls != 1 {
+				t.Errorf("the form was expanded %d times", calls)
+			}
+		})
+	}
+}
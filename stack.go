@@ -1,4 +1,4 @@
-package main
+package scm
 
 import (
 	llist "container/list"
@@ -28,3 +28,11 @@ func (s *Stack) pop() interface{} {
 	f := s.items.Front()
 	return s.items.Remove(f)
 }
+
+// a copy of the stack that shares the saved items, the copy and
+// the original can be pushed and popped independently
+func (s *Stack) copy() *Stack {
+	c := newStack()
+	c.items.PushBackList(s.items)
+	return c
+}
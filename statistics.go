@@ -0,0 +1,199 @@
+package scm
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// The statistics of a machine, like the monitored stack of SICP 5.2.4.
+// The pushes and the maximum depth of the stack are always counted, the
+// instructions executed by label only when counting is on. A label of
+// the evaluator counts as one instruction and is named after the method
+// that implements it, assembled instructions count under the label of
+// the controller text that precedes them and the instructions of the
+// virtual machine under their opcode. The assignments are counted by
+// the registers themselves.
+type statistics struct {
+	pushes    int
+	max_depth int
+
+	counting     bool
+	instructions map[string]int
+	names        labelNames
+}
+
+func newStatistics() *statistics {
+	return &statistics{
+		instructions: map[string]int{},
+		names:        labelNames{},
+	}
+}
+
+// a push that leaves depth items on the stack
+func (s *statistics) pushed(depth int) {
+	s.pushes++
+	if depth > s.max_depth {
+		s.max_depth = depth
+	}
+}
+
+func (s *statistics) executed(name string) {
+	s.instructions[name]++
+}
+
+// count a label of the evaluator, assembled instructions count
+// themselves under their own label
+func (s *statistics) executed_label(f func()) {
+	if name := s.names.of(f); name != "" {
+		s.instructions[name]++
+	}
+}
+
+func (s *statistics) reset(registers []*Register) {
+	s.pushes = 0
+	s.max_depth = 0
+	s.instructions = map[string]int{}
+	for _, r := range registers {
+		r.assignments = 0
+	}
+}
+
+// the names of the labels of the evaluator by their code
+type labelNames map[uintptr]string
+
+func (names labelNames) of(f func()) string {
+	pc := reflect.ValueOf(f).Pointer()
+	name, ok := names[pc]
+	if !ok {
+		name = label_name(pc)
+		names[pc] = name
+	}
+	return name
+}
+
+// the name of the label implemented by the function at pc, eval_dispatch
+// is eval-dispatch and the closures of analyze_if are analyze-if. The
+// instructions of the assembler are not named
+func label_name(pc uintptr) string {
+	name := runtime.FuncForPC(pc).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimPrefix(name, "scm.")
+	if strings.HasPrefix(name, "(*assembler).") {
+		return ""
+	}
+	name = strings.TrimPrefix(name, "(*Interpreter).")
+	name = strings.TrimSuffix(name, "-fm")
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ReplaceAll(name, "_", "-")
+}
+
+type count struct {
+	name string
+	n    int
+}
+
+// the counts from the largest, names with the same count in order
+func sorted_counts(counts map[string]int) []count {
+	var sorted []count
+	for name, n := range counts {
+		if n > 0 {
+			sorted = append(sorted, count{name, n})
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].n != sorted[j].n {
+			return sorted[i].n > sorted[j].n
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	return sorted
+}
+
+func register_counts(registers []*Register) map[string]int {
+	counts := map[string]int{}
+	for _, r := range registers {
+		counts[r.name] = r.assignments
+	}
+	return counts
+}
+
+// the statistics as an association list of total-pushes, maximum-depth,
+// instructions and assignments, the counts of the instructions and of
+// the assignments are lists of (name . n) pairs
+func (s *statistics) value(registers []*Register) *Value {
+	alist := func(counts map[string]int) *Value {
+		var items []*Value
+		for _, c := range sorted_counts(counts) {
+			items = append(items, cons(make_name(c.name), make_integer(int64(c.n))))
+		}
+		return list(items...)
+	}
+	return list(
+		cons(make_name("total-pushes"), make_integer(int64(s.pushes))),
+		cons(make_name("maximum-depth"), make_integer(int64(s.max_depth))),
+		cons(make_name("instructions"), alist(s.instructions)),
+		cons(make_name("assignments"), alist(register_counts(registers))))
+}
+
+func (s *statistics) write(w io.Writer, registers []*Register) {
+	fmt.Fprintf(w, "total pushes: %d\n", s.pushes)
+	fmt.Fprintf(w, "maximum depth: %d\n", s.max_depth)
+	if counts := sorted_counts(s.instructions); len(counts) > 0 {
+		fmt.Fprintln(w, "instructions:")
+		for _, c := range counts {
+			fmt.Fprintf(w, "  %-28s %d\n", c.name, c.n)
+		}
+	}
+	if counts := sorted_counts(register_counts(registers)); len(counts) > 0 {
+		fmt.Fprintln(w, "assignments:")
+		for _, c := range counts {
+			fmt.Fprintf(w, "  %-28s %d\n", c.name, c.n)
+		}
+	}
+}
+
+// the registers of the evaluator whose assignments are counted
+func (in *Interpreter) machine_registers() []*Register {
+	return []*Register{in.exp, in.env, in.val, in.cont, in.proc, in.argl, in.unev, in.flag}
+}
+
+// SetStatistics turns the counting of the instructions executed by each
+// label on or off, it is off by default. The pushes, the maximum depth
+// of the stack and the assignments of the registers are always counted.
+func (in *Interpreter) SetStatistics(on bool) {
+	in.stats.counting = on
+}
+
+// WriteStatistics writes the statistics of the machine counted since
+// the interpreter was created or the statistics were last reset.
+func (in *Interpreter) WriteStatistics(w io.Writer) {
+	in.stats.write(w, in.machine_registers())
+}
+
+// statistics primitives, without a machine they
+// are the statistics of the evaluator
+func (in *Interpreter) machine_statistics(args *Value) *Value {
+	if isPair(args) {
+		m := machine_of("machine-statistics", car(args))
+		return m.stats.value(m.register_list())
+	}
+	return in.stats.value(in.machine_registers())
+}
+
+func (in *Interpreter) reset_statistics(args *Value) *Value {
+	if isPair(args) {
+		m := machine_of("reset-statistics!", car(args))
+		m.stats.reset(m.register_list())
+		return make_name("done")
+	}
+	in.stats.reset(in.machine_registers())
+	return make_name("done")
+}
@@ -0,0 +1,85 @@
+package scm
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Names are symbols, the identifiers of variables and the symbols of
+// quoted data are the same values. Every name read or created with
+// make_name is interned in a table shared by all the interpreters, so
+// two names spelled the same are the same value and comparing them
+// with eq? or looking them up in an environment compares pointers.
+var symbols = struct {
+	sync.Mutex
+	table map[string]*Value
+}{
+	table: map[string]*Value{},
+}
+
+func intern(name string) *Value {
+	symbols.Lock()
+	defer symbols.Unlock()
+
+	if sym, ok := symbols.table[name]; ok {
+		return sym
+	}
+	sym := make_uninterned_name(name)
+	symbols.table[name] = sym
+	return sym
+}
+
+// a name that is only equal to itself, even if another
+// name is spelled the same
+func make_uninterned_name(name string) *Value {
+	return &Value{
+		kind: Name,
+		val:  name,
+	}
+}
+
+// the number of the last name made by gensym
+var gensym_count uint64
+
+// symbol primitives
+func symbol_p(args *Value) *Value {
+	if isName(car(args)) {
+		return make_true()
+	}
+	return make_false()
+}
+
+func symbol_to_string(args *Value) *Value {
+	sym := car(args)
+	if !isName(sym) {
+		raise_error(WrongTypeError, "symbol->string: not a symbol", sym)
+	}
+	return &Value{
+		kind: String,
+		val:  sym.val.(string),
+	}
+}
+
+func string_to_symbol(args *Value) *Value {
+	str := car(args)
+	if !isString(str) {
+		raise_error(WrongTypeError, "string->symbol: not a string", str)
+	}
+	return make_name(str.val.(string))
+}
+
+// (gensym) or (gensym prefix), a fresh uninterned symbol
+func gensym(args *Value) *Value {
+	prefix := "g"
+	if isPair(args) {
+		switch p := car(args); p.kind {
+		case String, Name:
+			prefix = p.val.(string)
+		default:
+			raise_error(WrongTypeError, "gensym: the prefix is not a string or a symbol", p)
+		}
+	}
+	n := atomic.AddUint64(&gensym_count, 1)
+	return make_uninterned_name(fmt.Sprintf("%s%d", prefix, n))
+}
@@ -1,10 +1,8 @@
-package main
+package scm
 
-import "fmt"
-
-// self evaluating items (numbers and strings)
+// self evaluating items (numbers, strings, characters and booleans)
 func is_self_evaluating(exp *Value) *Value {
-	if isNumber(exp) || isString(exp) {
+	if isNumber(exp) || isString(exp) || exp.kind == Character || exp.kind == Boolean {
 		return make_true()
 	}
 	return make_false()
@@ -12,31 +10,107 @@ func is_self_evaluating(exp *Value) *Value {
 
 // variables and quotations
 func is_variable(exp *Value) *Value {
-	if isName(exp) {
+	if isName(exp) || is_lexical_address(exp) {
 		return make_true()
 	}
 	return make_false()
 }
 
 func is_quoted(exp *Value) *Value {
-	if isSymbol(exp) {
+	if is_tagged_list(exp, "quote") {
 		return make_true()
 	}
 	return make_false()
 }
 
 func text_of_quotation(exp *Value) *Value {
-	return cadr(exp)
+	return strip_syntax(cadr(exp))
 }
 
 func is_tagged_list(exp *Value, tag string) bool {
 	if isPair(exp) {
 		name := car(exp)
-		return name.val.(string) == tag
+		return isName(name) && name.val.(string) == tag
 	}
 	return false
 }
 
+func make_quote(datum *Value) *Value {
+	return list(make_name("quote"), datum)
+}
+
+// quasiquote expressions
+func is_quasiquote(exp *Value) bool {
+	return is_tagged_list(exp, "quasiquote")
+}
+
+func quasiquote_template(exp *Value) *Value {
+	return cadr(exp)
+}
+
+// quasiquote to combination transformation, the template becomes
+// an expression that builds it with cons and append
+func quasiquote_to_combination(exp *Value) *Value {
+	return car(expand_quasiquote(quasiquote_template(exp), 1))
+}
+
+// expand a template nested inside depth quasiquotes, only the
+// unquotes at depth 1 are evaluated. The expression is the only
+// element of the list returned, an unquoted name keeps there the
+// position where it was read
+func expand_quasiquote(template *Value, depth int) *Value {
+	if !isPair(template) {
+		return list(make_quote(template))
+	}
+
+	if is_tagged_list(template, "unquote") {
+		if depth == 1 {
+			return cons_car(cdr(template), nullValue)
+		}
+		return quasiquote_list(
+			list(make_quote(car(template))),
+			expand_quasiquote(cadr(template), depth-1))
+	}
+
+	if is_tagged_list(template, "quasiquote") {
+		return quasiquote_list(
+			list(make_quote(car(template))),
+			expand_quasiquote(cadr(template), depth+1))
+	}
+
+	first := car(template)
+	rest := expand_quasiquote(cdr(template), depth)
+	if is_tagged_list(first, "unquote-splicing") {
+		if depth == 1 {
+			return list(make_application(
+				make_quote(make_primitive_procedure(make_prim(_append))),
+				cons_car(cdr(first), rest)))
+		}
+		spliced := quasiquote_list(
+			list(make_quote(car(first))),
+			expand_quasiquote(cadr(first), depth-1))
+		return quasiquote_cons(spliced, rest)
+	}
+
+	return quasiquote_cons(expand_quasiquote(first, depth), rest)
+}
+
+// the expression that conses the values of first and rest, when
+// both are constant the pair is built right away. The expressions
+// are the elements of lists as expand_quasiquote returns them
+func quasiquote_cons(first *Value, rest *Value) *Value {
+	if test(is_quoted(car(first))) && test(is_quoted(car(rest))) {
+		return list(make_quote(cons(text_of_quotation(car(first)), text_of_quotation(car(rest)))))
+	}
+	return list(make_application(
+		make_quote(make_primitive_procedure(make_prim(_cons))),
+		cons_car(first, rest)))
+}
+
+func quasiquote_list(first *Value, second *Value) *Value {
+	return quasiquote_cons(first, quasiquote_cons(second, list(make_quote(nullValue))))
+}
+
 // assignments with set!
 func is_assignment(exp *Value) *Value {
 	if is_tagged_list(exp, "set!") {
@@ -90,10 +164,7 @@ func lambda_body(exp *Value) *Value {
 }
 
 func make_lambda(parameters *Value, body *Value) *Value {
-	lamb := &Value{
-		kind: Name,
-		val:  "lambda",
-	}
+	lamb := make_name("lambda")
 	return cons(lamb, cons(parameters, body))
 }
 
@@ -122,13 +193,16 @@ func if_alternative(exp *Value) *Value {
 }
 
 func make_if(predicate *Value, consequent *Value, alternative *Value) *Value {
-	if_name := &Value{
-		kind: Name,
-		val:  "if",
-	}
+	if_name := make_name("if")
 	return list(if_name, predicate, consequent, alternative)
 }
 
+// an if expression of the cars of the pairs predicate, consequent and
+// alternative, a name moved from the source keeps its position
+func make_if_of(predicate *Value, consequent *Value, alternative *Value) *Value {
+	return cons(make_name("if"), cons_car(predicate, cons_car(consequent, cons_car(alternative, nullValue))))
+}
+
 // begin expressions
 func is_begin(exp *Value) *Value {
 	if is_tagged_list(exp, "begin") {
@@ -163,10 +237,7 @@ func sequence_to_exp(seq *Value) *Value {
 }
 
 func make_begin(seq *Value) *Value {
-	begin_name := &Value{
-		kind: Name,
-		val:  "begin",
-	}
+	begin_name := make_name("begin")
 	return cons(begin_name, seq)
 }
 
@@ -186,13 +257,330 @@ func make_application(operator *Value, operands *Value) *Value {
 	return cons(operator, operands)
 }
 
+func make_let(bindings *Value, body *Value) *Value {
+	return cons(make_name("let"), cons(bindings, body))
+}
+
 // let to combination transformation
 func let_to_combination(exp *Value) *Value {
+	if is_named_let(exp) {
+		return named_let_to_combination(exp)
+	}
+
 	lamb := make_lambda(
 		_map(car, let_bindings(exp)),
 		let_body(exp))
 
-	return make_application(lamb, _map(cadr, let_bindings(exp)))
+	return make_application(lamb, binding_inits(let_bindings(exp)))
+}
+
+// the inits of a list of (name init) bindings, each keeps the
+// position where it was read
+func binding_inits(bindings *Value) *Value {
+	if isNull(bindings) {
+		return nullValue
+	}
+	return cons_car(cdr(car(bindings)), binding_inits(cdr(bindings)))
+}
+
+// named let, (let name bindings body)
+func is_named_let(exp *Value) bool {
+	return isName(cadr(exp))
+}
+
+func named_let_name(exp *Value) *Value {
+	return cadr(exp)
+}
+func named_let_bindings(exp *Value) *Value {
+	return caddr(exp)
+}
+func named_let_body(exp *Value) *Value {
+	return cdddr(exp)
+}
+
+// the name is bound to the procedure inside its own body:
+// ((letrec ((name (lambda vars body))) name) inits)
+func named_let_to_combination(exp *Value) *Value {
+	name := named_let_name(exp)
+	bindings := named_let_bindings(exp)
+	proc := make_lambda(_map(car, bindings), named_let_body(exp))
+	letrec := cons(make_name("letrec"), cons(list(list(name, proc)), list(name)))
+
+	return make_application(letrec, binding_inits(bindings))
+}
+
+// let* expressions, each binding sees the previous ones
+func is_let_star(exp *Value) bool {
+	return is_tagged_list(exp, "let*")
+}
+
+func let_star_to_nested_lets(exp *Value) *Value {
+	bindings := let_bindings(exp)
+	if isNull(bindings) || isNull(cdr(bindings)) {
+		return make_let(bindings, let_body(exp))
+	}
+
+	rest := cons(make_name("let*"), cons(cdr(bindings), let_body(exp)))
+	return make_let(list(car(bindings)), list(rest))
+}
+
+// letrec and letrec* expressions, the variables are bound before
+// their values are computed and assigned in order
+func is_letrec(exp *Value) bool {
+	return is_tagged_list(exp, "letrec") || is_tagged_list(exp, "letrec*")
+}
+
+func letrec_to_let(exp *Value) *Value {
+	bindings := let_bindings(exp)
+	unassigned := func(binding *Value) *Value {
+		return list(car(binding), make_quote(unassigned_value))
+	}
+	assignment := func(binding *Value) *Value {
+		return cons(make_name("set!"), cons(car(binding), cons_car(cdr(binding), nullValue)))
+	}
+
+	return make_let(
+		_map(unassigned, bindings),
+		listAppend(_map(assignment, bindings), let_body(exp)))
+}
+
+// internal definitions, the defines at the start of a procedure
+// body are scanned out so that the body is evaluated like a letrec*
+func scan_out_defines(body *Value) *Value {
+	var bindings []*Value
+	var exps []*Value
+
+	for seq := body; isPair(seq); seq = cdr(seq) {
+		exp := car(seq)
+		if test(is_definition(exp)) {
+			variable := definition_variable(exp)
+			bindings = append(bindings, list(variable, make_quote(unassigned_value)))
+			value := list(definition_value(exp))
+			if isName(cadr(exp)) {
+				value = cons_car(cddr(exp), nullValue)
+			}
+			assignment := cons(make_name("set!"), cons(variable, value))
+			// errors in the value are reported at the definition
+			assignment.pos = exp.pos
+			exp = assignment
+		}
+		exps = append(exps, exp)
+	}
+
+	if len(bindings) == 0 {
+		return body
+	}
+	return list(make_let(list(bindings...), list(exps...)))
+}
+
+// the value of a variable that is bound but not assigned yet
+var unassigned_value = &Value{
+	kind: Name,
+	val:  "*unassigned*",
+}
+
+// and and or expressions, the operands are evaluated from left
+// to right only until the result is known
+func is_and(exp *Value) bool {
+	return is_tagged_list(exp, "and")
+}
+func is_or(exp *Value) bool {
+	return is_tagged_list(exp, "or")
+}
+func logical_operands(exp *Value) *Value {
+	return cdr(exp)
+}
+
+// when and unless expressions
+func is_when(exp *Value) bool {
+	return is_tagged_list(exp, "when")
+}
+func is_unless(exp *Value) bool {
+	return is_tagged_list(exp, "unless")
+}
+func when_body(exp *Value) *Value {
+	return cddr(exp)
+}
+
+func when_to_if(exp *Value) *Value {
+	return make_if_of(cdr(exp), list(make_begin(when_body(exp))), list(make_false()))
+}
+
+func unless_to_if(exp *Value) *Value {
+	return make_if_of(cdr(exp), list(make_false()), list(make_begin(when_body(exp))))
+}
+
+// case expressions
+func is_case(exp *Value) bool {
+	return is_tagged_list(exp, "case")
+}
+func case_key(exp *Value) *Value {
+	return cadr(exp)
+}
+func case_clauses(exp *Value) *Value {
+	return cddr(exp)
+}
+func case_data(clause *Value) *Value {
+	return car(clause)
+}
+
+// the key is evaluated once and compared with eqv? to the data of
+// each clause, (let ((key <key>)) (cond ((memv key '<data>) ...) ...))
+func case_to_cond(exp *Value) *Value {
+	key := make_temporary_name("case-key")
+	memv := make_quote(make_primitive_procedure(make_prim(memv)))
+
+	clause := func(clause *Value) *Value {
+		var test *Value
+		if is_cond_else_clause(clause) {
+			test = car(clause)
+		} else {
+			test = make_application(memv, list(key, make_quote(case_data(clause))))
+		}
+
+		if is_cond_arrow_clause(clause) {
+			return list(test, cons_car(cddr(clause), list(key)))
+		}
+		return cons(test, cond_actions(clause))
+	}
+
+	return make_let(
+		list(cons(key, cons_car(cdr(exp), nullValue))),
+		list(cons(make_name("cond"), _map(clause, case_clauses(exp)))))
+}
+
+// do loops
+func is_do(exp *Value) bool {
+	return is_tagged_list(exp, "do")
+}
+func do_specs(exp *Value) *Value {
+	return cadr(exp)
+}
+func do_results(exp *Value) *Value {
+	return cdr(caddr(exp))
+}
+func do_commands(exp *Value) *Value {
+	return cdddr(exp)
+}
+
+// a variable without a step keeps its value between iterations,
+// each step keeps the position where it was read
+func do_steps(specs *Value) *Value {
+	if isNull(specs) {
+		return nullValue
+	}
+	spec := car(specs)
+	step := spec
+	if !isNull(cddr(spec)) {
+		step = cddr(spec)
+	}
+	return cons_car(step, do_steps(cdr(specs)))
+}
+
+// the loop is a named let, (let loop ((var init) ...) (if test
+// (begin result ...) (begin command ... (loop step ...))))
+func do_to_named_let(exp *Value) *Value {
+	loop := make_temporary_name("do-loop")
+	bindings := _map(func(spec *Value) *Value {
+		return cons(car(spec), cons_car(cdr(spec), nullValue))
+	}, do_specs(exp))
+
+	result := make_false()
+	if !isNull(do_results(exp)) {
+		result = make_begin(do_results(exp))
+	}
+	next := make_application(loop, do_steps(do_specs(exp)))
+	body := make_if_of(
+		caddr(exp),
+		list(result),
+		list(make_begin(listAppend(do_commands(exp), list(next)))))
+
+	return cons(make_name("let"), list(loop, bindings, body))
+}
+
+// guard expressions, (guard (var clause ...) body ...)
+func is_guard(exp *Value) bool {
+	return is_tagged_list(exp, "guard")
+}
+func guard_variable(exp *Value) *Value {
+	return caadr(exp)
+}
+func guard_clauses(exp *Value) *Value {
+	return cdadr(exp)
+}
+func guard_body(exp *Value) *Value {
+	return cddr(exp)
+}
+
+// the body runs with a handler that returns to the continuation of
+// the guard before the clauses are evaluated with var bound to the
+// condition. When no clause applies, the condition is raised again
+// with raise-continuable in the dynamic environment of the original
+// raise. The call/cc, with-exception-handler and raise-continuable
+// procedures are passed in by the machine
+//
+//	((call/cc
+//	   (lambda (guard-k)
+//	     (with-exception-handler
+//	       (lambda (condition)
+//	         ((call/cc
+//	            (lambda (handler-k)
+//	              (guard-k
+//	                (lambda ()
+//	                  (let ((var condition))
+//	                    (cond clause ...
+//	                      (else (handler-k
+//	                              (lambda () (raise-continuable condition))))))))))))
+//	       (lambda ()
+//	         (let ((result (begin body ...)))
+//	           (guard-k (lambda () result))))))))
+func guard_to_combination(exp *Value, callcc *Value, with_handler *Value, raise_continuable *Value) *Value {
+	guard_k := make_temporary_name("guard-k")
+	handler_k := make_temporary_name("handler-k")
+	condition := make_temporary_name("condition")
+	result := make_temporary_name("result")
+
+	callcc = make_quote(callcc)
+	thunk := func(body *Value) *Value {
+		return make_lambda(nullValue, list(body))
+	}
+
+	clauses := guard_clauses(exp)
+	if !has_else_clause(clauses) {
+		reraise := make_application(handler_k, list(thunk(
+			make_application(make_quote(raise_continuable), list(condition)))))
+		clauses = listAppend(clauses, list(list(make_name("else"), reraise)))
+	}
+	handle := make_let(
+		list(list(guard_variable(exp), condition)),
+		list(cons(make_name("cond"), clauses)))
+
+	handler := make_lambda(list(condition), list(
+		make_application(
+			make_application(callcc, list(
+				make_lambda(list(handler_k), list(
+					make_application(guard_k, list(thunk(handle))))))),
+			nullValue)))
+
+	body := make_lambda(nullValue, list(
+		make_let(
+			list(list(result, make_begin(guard_body(exp)))),
+			list(make_application(guard_k, list(thunk(result)))))))
+
+	return make_application(
+		make_application(callcc, list(
+			make_lambda(list(guard_k), list(
+				make_application(make_quote(with_handler), list(handler, body)))))),
+		nullValue)
+}
+
+func has_else_clause(clauses *Value) bool {
+	for ; !isNull(clauses); clauses = cdr(clauses) {
+		if is_cond_else_clause(car(clauses)) {
+			return true
+		}
+	}
+	return false
 }
 
 // procedure applications
@@ -237,10 +625,13 @@ func cond_clauses(exp *Value) *Value {
 }
 func is_cond_else_clause(clause *Value) bool {
 	pred := cond_predicate(clause)
-	if pred.val.(string) == "else" {
-		return true
-	}
-	return false
+	return isName(pred) && pred.val.(string) == "else"
+}
+
+// (test => receiver) calls receiver with the value of test
+func is_cond_arrow_clause(clause *Value) bool {
+	actions := cond_actions(clause)
+	return isPair(actions) && isName(car(actions)) && car(actions).val.(string) == "=>"
 }
 
 func cond_predicate(clause *Value) *Value {
@@ -251,31 +642,53 @@ func cond_actions(clause *Value) *Value {
 }
 
 func cond_to_if(exp *Value) *Value {
-	return expand_clauses(cond_clauses(exp))
+	return car(expand_clauses(cond_clauses(exp)))
 }
 
+// the expression of the clauses is the only element of the list
+// returned, a name moved from a clause keeps there the position
+// where it was read
 func expand_clauses(clauses *Value) *Value {
 	if isNull(clauses) {
-		return &Value{
+		return list(&Value{
 			kind: Boolean,
 			val:  false,
-		}
+		})
 	}
 
 	first := car(clauses)
 	rest := cdr(clauses)
 	if is_cond_else_clause(first) {
 		if isNull(rest) {
-			return sequence_to_exp(cond_actions(first))
+			return sequence_of(cond_actions(first))
 		} else {
-			panic(fmt.Sprintf("ELSE clause isn't last -- cond_to_if %s", clauses))
+			raise_error(SyntaxError, "ELSE clause isn't last -- cond_to_if", clauses)
 		}
-	} else {
-		return make_if(
-			cond_predicate(first),
-			sequence_to_exp(cond_actions(first)),
-			expand_clauses(rest))
 	}
+
+	if is_cond_arrow_clause(first) || isNull(cond_actions(first)) {
+		// the value of the test is needed after it is checked:
+		// (let ((value test)) (if value (receiver value) rest))
+		value := make_temporary_name("cond-value")
+		consequent := value
+		if is_cond_arrow_clause(first) {
+			consequent = cons_car(cddr(first), list(value))
+		}
+		return list(make_let(
+			list(cons(value, cons_car(first, nullValue))),
+			list(make_if_of(list(value), list(consequent), expand_clauses(rest)))))
+	}
+
+	return list(make_if_of(first, sequence_of(cond_actions(first)), expand_clauses(rest)))
+}
+
+// the expression of a sequence as the only element of a list,
+// a single name keeps the position where it was read
+func sequence_of(seq *Value) *Value {
+	if isPair(seq) && isNull(cdr(seq)) {
+		return cons_car(seq, nullValue)
+	}
+	return list(sequence_to_exp(seq))
 }
 
 func is_true(v *Value) *Value {
@@ -285,6 +698,13 @@ func is_true(v *Value) *Value {
 	return make_false()
 }
 
+func is_false(v *Value) *Value {
+	if isTrue(v) {
+		return make_false()
+	}
+	return make_true()
+}
+
 func make_prim(p func(args *Value) *Value) *Value {
 	return &Value{
 		kind: Function,
@@ -292,11 +712,16 @@ func make_prim(p func(args *Value) *Value) *Value {
 	}
 }
 
+// a name for a variable introduced by a syntax transformation,
+// it is not interned so it never captures a user variable with
+// the same name
+func make_temporary_name(n string) *Value {
+	return make_uninterned_name("#:" + n)
+}
+
+// the interned name spelled n
 func make_name(n string) *Value {
-	return &Value{
-		kind: Name,
-		val:  n,
-	}
+	return intern(n)
 }
 
 func make_false() *Value {
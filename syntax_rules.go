@@ -0,0 +1,431 @@
+package scm
+
+// Hygienic macros. A syntax-rules transformer matches the operands of
+// a macro use against its patterns and instantiates the template of
+// the first rule that matches. Every name in the template that is not
+// a pattern variable is renamed to an alias, a name that carries the
+// syntactic context of the macro definition:
+//
+//   - a binding form in the expansion binds the alias, which never
+//     captures the names of the macro use even if they are spelled
+//     the same (a temp introduced by my-or does not shadow the temp
+//     of the user)
+//   - an alias that is not bound by the expansion refers to the
+//     binding of the original name in the environment where the
+//     macro was defined, whatever the environment of the macro use
+//
+// Names read by the parser have no syntactic context. A literal of the
+// patterns matches a name of the macro use that has the same binding
+// as the literal where the macro was defined, or that is spelled the
+// same when neither is bound lexically: => bound by a let around the
+// macro use is not the literal =>.
+
+// SyntacticContext is the context of an alias introduced by the
+// expansion of a syntax-rules macro
+type SyntacticContext struct {
+	// the name as it appears in the template
+	name *Value
+	// the environment where the macro was defined
+	env *Value
+}
+
+// SyntaxRules is a transformer defined with syntax-rules
+type SyntaxRules struct {
+	in       *Interpreter
+	keyword  *Value
+	ellipsis *Value
+	literals []*Value
+	// (pattern template) lists
+	rules []*Value
+	env   *Value
+	// the lexical binding of a name where the macro was defined
+	binding func(name *Value) interface{}
+}
+
+// the lexical binding of name in env, the frame and the offset of its
+// variable. nil when it is bound at the top level or not at all, an
+// alias that is not bound by its expansion has the binding of its
+// original name where its macro was defined
+func lexical_binding(name *Value, env *Value) interface{} {
+	frame, i := find_binding(name, env)
+	if frame == nil {
+		if name.ctx != nil {
+			return lexical_binding(name.ctx.name, name.ctx.env)
+		}
+		return nil
+	}
+	if is_global_frame(frame) {
+		return nil
+	}
+	return frameBinding{frame: frame, offset: i}
+}
+
+type frameBinding struct {
+	frame  *Frame
+	offset int
+}
+
+// the lexical bindings of the names in env
+func lexical_bindings(env *Value) func(name *Value) interface{} {
+	return func(name *Value) interface{} {
+		return lexical_binding(name, env)
+	}
+}
+
+// two names are the same identifier when they are spelled the
+// same and come from the same expansion
+type identifier struct {
+	name string
+	ctx  *SyntacticContext
+}
+
+func identifier_of(name *Value) identifier {
+	return identifier{name: name.val.(string), ctx: name.ctx}
+}
+
+// a pattern variable is bound to the form it matched, or to
+// one match for every repetition when it is under an ellipsis
+type syntaxMatch struct {
+	form  *Value
+	seq   bool
+	items []*syntaxMatch
+}
+
+type syntaxBindings map[identifier]*syntaxMatch
+
+// (syntax-rules (literal ...) (pattern template) ...), a name before
+// the literals is used as the ellipsis instead of ...
+func is_syntax_rules(spec *Value) bool {
+	return is_tagged_list(spec, "syntax-rules")
+}
+
+// the macro defined by spec for keyword in the environment env,
+// binding is the lexical binding of a name there
+func (in *Interpreter) make_syntax_rules(keyword *Value, spec *Value, env *Value, binding func(name *Value) interface{}) *Value {
+	if !is_syntax_rules(spec) {
+		raise_error(SyntaxError, "not a syntax-rules transformer", keyword, spec)
+	}
+
+	sr := &SyntaxRules{
+		in:       in,
+		keyword:  keyword,
+		ellipsis: make_name("..."),
+		env:      env,
+		binding:  binding,
+	}
+
+	rest := cdr(spec)
+	if isPair(rest) && isName(car(rest)) {
+		sr.ellipsis = car(rest)
+		rest = cdr(rest)
+	}
+	if !isPair(rest) {
+		raise_error(SyntaxError, "syntax-rules: missing literals", spec)
+	}
+	for literals := car(rest); isPair(literals); literals = cdr(literals) {
+		sr.literals = append(sr.literals, car(literals))
+	}
+	for rules := cdr(rest); isPair(rules); rules = cdr(rules) {
+		rule := car(rules)
+		if listLen(rule) != 2 || !isPair(car(rule)) {
+			raise_error(SyntaxError, "syntax-rules: invalid rule", rule)
+		}
+		sr.rules = append(sr.rules, rule)
+	}
+
+	return make_macro(make_primitive_procedure(make_prim(sr.expand)))
+}
+
+// the transformer, the keyword at the head of the
+// patterns is ignored and only the operands are matched
+func (sr *SyntaxRules) expand(operands *Value) *Value {
+	for _, rule := range sr.rules {
+		b := syntaxBindings{}
+		if sr.match(cdr(car(rule)), operands, b) {
+			return sr.instantiate(cadr(rule), b, map[identifier]*Value{})
+		}
+	}
+
+	raise_error(SyntaxError, "no syntax rule matches", cons(sr.keyword, operands))
+	return nil
+}
+
+func (sr *SyntaxRules) is_ellipsis(v *Value) bool {
+	return sr.ellipsis != nil && isName(v) && v.val.(string) == sr.ellipsis.val.(string)
+}
+
+func (sr *SyntaxRules) is_literal(v *Value) bool {
+	for _, literal := range sr.literals {
+		if v.val.(string) == literal.val.(string) {
+			return true
+		}
+	}
+	return false
+}
+
+// the name form of the macro use is the literal when it is the same
+// name with the same lexical binding
+func (sr *SyntaxRules) matches_literal(form *Value, literal *Value) bool {
+	if original_name(form) != original_name(literal) {
+		return false
+	}
+	var use interface{}
+	if sr.in.use_binding != nil {
+		use = sr.in.use_binding(form)
+	}
+	return use == sr.binding(literal)
+}
+
+func is_underscore(v *Value) bool {
+	return isName(v) && v.val.(string) == "_"
+}
+
+func (sr *SyntaxRules) match(pattern *Value, form *Value, b syntaxBindings) bool {
+	switch {
+	case isName(pattern):
+		if sr.is_literal(pattern) {
+			return isName(form) && sr.matches_literal(form, pattern)
+		}
+		if !is_underscore(pattern) {
+			b[identifier_of(pattern)] = &syntaxMatch{form: form}
+		}
+		return true
+
+	case isPair(pattern):
+		if isPair(cdr(pattern)) && sr.is_ellipsis(cadr(pattern)) {
+			return sr.match_ellipsis(car(pattern), cddr(pattern), form, b)
+		}
+		if !isPair(form) {
+			return false
+		}
+		return sr.match(car(pattern), car(form), b) && sr.match(cdr(pattern), cdr(form), b)
+
+	case isNull(pattern):
+		return isNull(form)
+	}
+
+	return pattern.kind == form.kind && isEqual(pattern, form)
+}
+
+// (p ... . tail), p matches as many forms as possible while
+// leaving enough of them for the pairs of the tail
+func (sr *SyntaxRules) match_ellipsis(p *Value, tail *Value, form *Value, b syntaxBindings) bool {
+	min := countPairs(tail)
+	var items []syntaxBindings
+
+	for countPairs(form) > min {
+		item := syntaxBindings{}
+		if !sr.match(p, car(form), item) {
+			return false
+		}
+		items = append(items, item)
+		form = cdr(form)
+	}
+
+	for _, v := range sr.pattern_variables(p, nil) {
+		m := &syntaxMatch{seq: true}
+		for _, item := range items {
+			m.items = append(m.items, item[v])
+		}
+		b[v] = m
+	}
+
+	return sr.match(tail, form, b)
+}
+
+func (sr *SyntaxRules) pattern_variables(pattern *Value, vars []identifier) []identifier {
+	switch {
+	case isName(pattern):
+		if !sr.is_literal(pattern) && !sr.is_ellipsis(pattern) && !is_underscore(pattern) {
+			vars = append(vars, identifier_of(pattern))
+		}
+	case isPair(pattern):
+		vars = sr.pattern_variables(car(pattern), vars)
+		vars = sr.pattern_variables(cdr(pattern), vars)
+	}
+	return vars
+}
+
+// the number of pairs at the start of a list, proper or not
+func countPairs(v *Value) int {
+	n := 0
+	for ; isPair(v); v = cdr(v) {
+		n++
+	}
+	return n
+}
+
+// the names of the template are renamed once per expansion, so every
+// occurrence of a name in the template becomes the same alias
+func (sr *SyntaxRules) instantiate(template *Value, b syntaxBindings, renames map[identifier]*Value) *Value {
+	switch {
+	case isName(template):
+		if m, ok := b[identifier_of(template)]; ok {
+			if m.seq {
+				raise_error(SyntaxError, "pattern variable used without an ellipsis", template)
+			}
+			return m.form
+		}
+		return sr.rename(template, renames)
+
+	case isPair(template):
+		if sr.is_ellipsis(car(template)) && isPair(cdr(template)) {
+			// (... template) escapes the ellipsis
+			escaped := *sr
+			escaped.ellipsis = nil
+			return escaped.instantiate(cadr(template), b, renames)
+		}
+
+		element := car(template)
+		rest := cdr(template)
+		depth := 0
+		for isPair(rest) && sr.is_ellipsis(car(rest)) {
+			depth++
+			rest = cdr(rest)
+		}
+		if depth == 0 {
+			return cons(sr.instantiate(element, b, renames), sr.instantiate(rest, b, renames))
+		}
+		items := sr.instantiate_ellipsis(element, depth, b, renames)
+		return listAppend(list(items...), sr.instantiate(rest, b, renames))
+	}
+
+	return template
+}
+
+// the element of a template followed by depth ellipses is
+// instantiated once for every repetition of its pattern variables
+func (sr *SyntaxRules) instantiate_ellipsis(element *Value, depth int, b syntaxBindings, renames map[identifier]*Value) []*Value {
+	if depth == 0 {
+		return []*Value{sr.instantiate(element, b, renames)}
+	}
+
+	var vars []identifier
+	for _, v := range sr.pattern_variables(element, nil) {
+		if m, ok := b[v]; ok && m.seq {
+			vars = append(vars, v)
+		}
+	}
+	if len(vars) == 0 {
+		raise_error(SyntaxError, "no pattern variable before an ellipsis", element)
+	}
+
+	n := len(b[vars[0]].items)
+	for _, v := range vars[1:] {
+		if len(b[v].items) != n {
+			raise_error(SyntaxError, "pattern variables under an ellipsis matched a different number of forms", element)
+		}
+	}
+
+	var items []*Value
+	for i := 0; i < n; i++ {
+		repetition := syntaxBindings{}
+		for v, m := range b {
+			repetition[v] = m
+		}
+		for _, v := range vars {
+			repetition[v] = b[v].items[i]
+		}
+		items = append(items, sr.instantiate_ellipsis(element, depth-1, repetition, renames)...)
+	}
+	return items
+}
+
+func (sr *SyntaxRules) rename(name *Value, renames map[identifier]*Value) *Value {
+	id := identifier_of(name)
+	if alias, ok := renames[id]; ok {
+		return alias
+	}
+	alias := &Value{
+		kind: Name,
+		val:  name.val,
+		ctx: &SyntacticContext{
+			name: name,
+			env:  sr.env,
+		},
+	}
+	renames[id] = alias
+	return alias
+}
+
+// the name an alias was renamed from, the interned name
+// written in the template of the outermost macro
+func original_name(name *Value) *Value {
+	for name.ctx != nil {
+		name = name.ctx.name
+	}
+	return name
+}
+
+// the datum without the syntactic context of its names, quoted data
+// in an expansion contains plain symbols. The datum itself is
+// returned when it has no aliases
+func strip_syntax(datum *Value) *Value {
+	switch {
+	case isName(datum):
+		if datum.ctx != nil {
+			return original_name(datum)
+		}
+	case isPair(datum):
+		if test(is_compound_procedure(datum)) {
+			// procedures are not syntax and their environment is circular
+			return datum
+		}
+		first := strip_syntax(car(datum))
+		rest := strip_syntax(cdr(datum))
+		if first != car(datum) || rest != cdr(datum) {
+			return cons(first, rest)
+		}
+	}
+	return datum
+}
+
+// define-syntax, let-syntax and letrec-syntax
+func is_define_syntax(exp *Value) bool {
+	return is_tagged_list(exp, "define-syntax")
+}
+func define_syntax_keyword(exp *Value) *Value {
+	return cadr(exp)
+}
+func define_syntax_transformer(exp *Value) *Value {
+	return caddr(exp)
+}
+
+func is_let_syntax(exp *Value) bool {
+	return is_tagged_list(exp, "let-syntax")
+}
+func is_letrec_syntax(exp *Value) bool {
+	return is_tagged_list(exp, "letrec-syntax")
+}
+func syntax_bindings(exp *Value) *Value {
+	return cadr(exp)
+}
+func syntax_body(exp *Value) *Value {
+	return cddr(exp)
+}
+
+// the environment for the body of a let-syntax, the
+// transformers are closed in the environment env
+func (in *Interpreter) let_syntax_environment(exp *Value, env *Value) *Value {
+	keywords := _map(car, syntax_bindings(exp))
+	macros := _map(func(binding *Value) *Value {
+		return in.make_syntax_rules(car(binding), cadr(binding), env, lexical_bindings(env))
+	}, syntax_bindings(exp))
+	return extend_environment(keywords, macros, env)
+}
+
+// the environment for the body of a letrec-syntax, the transformers
+// are closed in the new environment so they can refer to each other
+func (in *Interpreter) letrec_syntax_environment(exp *Value, env *Value) *Value {
+	keywords := _map(car, syntax_bindings(exp))
+	placeholders := _map(func(*Value) *Value {
+		return unassigned_value
+	}, keywords)
+	env = extend_environment(keywords, placeholders, env)
+
+	for bindings := syntax_bindings(exp); isPair(bindings); bindings = cdr(bindings) {
+		binding := car(bindings)
+		define_variable(car(binding), in.make_syntax_rules(car(binding), cadr(binding), env, lexical_bindings(env)), env)
+	}
+	return env
+}
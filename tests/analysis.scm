@@ -0,0 +1,25 @@
+; a macro defined after the procedure that uses it gets the operands
+; as they were written, whether they were analyzed or not
+(define (show) (my-quote (let ((a 1)) (cond ((> a 0) => list) (else a)))))
+(define-macro (my-quote x) (list 'quote x))
+(display (show))
+(newline)
+
+(define (map-list f l)
+  (if (null-list? l) '() (cons (f (car l)) (map-list f (cdr l)))))
+(define (null-list? l) (eq? l '()))
+
+; every derived expression in a procedure that runs many times
+(define (classify n)
+  (let* ((sign (cond ((< n 0) 'negative) ((= n 0) 'zero) (else 'positive)))
+         (parity (case (remainder n 2) ((0) 'even) (else 'odd))))
+    (do ((i 0 (+ i 1)) (acc '() (cons i acc)))
+        ((= i 2) (list sign parity (when (> n 5) 'big) (unless (> n 5) 'small) acc)))))
+(display (map-list classify '(-3 0 4 7)))
+(newline)
+
+; the operands of a macro are not expressions, they are only
+; analyzed as far as they can be
+(define-syntax quote-it (syntax-rules () ((_ x) (quote x))))
+(display (list (quote-it (if)) (quote-it (define)) (quote-it (let 5))))
+(newline)
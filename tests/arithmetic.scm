@@ -0,0 +1,43 @@
+; exact integers stay exact, a single inexact operand
+; makes the result inexact
+
+(define (factorial n)
+  (if (= n 1)
+    1
+    (* (factorial (- n 1)) n)))
+
+(display (factorial 5)) ; returns 120
+(newline)
+(display (factorial 20)) ; returns 2432902008176640000
+(newline)
+(display (+ 1 2 3)) ; returns 6
+(newline)
+(display (+ 1 2.5)) ; returns 3.5
+(newline)
+(display (- 10)) ; returns -10
+(newline)
+(display (- 10 1 2)) ; returns 7
+(newline)
+(display (* 2 3.0)) ; returns 6.0
+(newline)
+(display (/ 10 2)) ; returns 5
+(newline)
+(display (/ 1 4)) ; returns 1/4
+(newline)
+(display (/ 2)) ; returns 1/2
+(newline)
+(display (list (quotient 17 5) (remainder 17 5) (modulo 17 5))) ; returns (3 2 2)
+(newline)
+(display (list (quotient -17 5) (remainder -17 5) (modulo -17 5))) ; returns (-3 -2 3)
+(newline)
+(display (list (remainder 17 -5) (modulo 17 -5) (modulo 17.0 5))) ; returns (2 -3 2.0)
+(newline)
+(display (list (abs -7) (abs 7.5) (min 1 2 3) (max 1 2 3) (max 1 2.0))) ; returns (7 7.5 1 3 2.0)
+(newline)
+(display (list (gcd 32 -36) (gcd) (lcm 32 -36) (lcm))) ; returns (4 0 288 1)
+(newline)
+(display (list (exact->inexact 1) (inexact->exact 2.0) (exact 3) (inexact 4))) ; returns (1.0 2 3 4.0)
+(newline)
+(display (list (= 1 1.0) (< 1 2 3) (< 1 3 2) (> 3 2 1))) ; returns (#t #t #f #t)
+(newline)
+(display (/ 1 0))
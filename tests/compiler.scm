@@ -0,0 +1,37 @@
+; run --compiled compiles every expression, the compiled procedures call
+; the procedures the evaluator makes for the forms left to it, like the
+; body of a let-syntax, and the other way around
+
+(define interpreted-map
+  (let-syntax ()
+    (lambda (f l)
+      (if (eq? l '()) '() (cons (f (car l)) (interpreted-map f (cdr l)))))))
+(define (square x) (* x x))
+(define (compiled-sum l) (if (eq? l '()) 0 (+ (car l) (compiled-sum (cdr l)))))
+(display (compiled-sum (interpreted-map square '(1 2 3)))) (newline)
+
+; tail calls between them do not grow the stack
+(define interpreted-even?
+  (let-syntax ()
+    (lambda (n) (if (= n 0) #t (compiled-odd? (- n 1))))))
+(define (compiled-odd? n) (if (= n 0) #f (interpreted-even? (- n 1))))
+(display (interpreted-even? 100000)) (newline)
+
+; a macro defined after the procedure that uses it is expanded by the
+; evaluator when the procedure runs
+(define (late) (swap-args - 1 10))
+(define-syntax swap-args (syntax-rules () ((_ f a b) (f b a))))
+(display (late)) (newline)
+
+; the registers saved around a call survive a continuation
+(define saved #f)
+(define (twice-called)
+  (let ((n (call/cc (lambda (k) (set! saved k) 1))))
+    (list n (* n 10))))
+(define result (twice-called))
+(display result) (newline)
+(if (= (car result) 1) (saved 2))
+(display result) (newline)
+
+(define (bad l) (+ (car l) 'one))
+(compiled-sum (interpreted-map bad '((1))))
@@ -0,0 +1,98 @@
+; call/cc and call-with-current-continuation
+
+(define (for-each-item f lst)
+  (if (eq? lst '())
+    'done
+    (begin (f (car lst)) (for-each-item f (cdr lst)))))
+
+; escaping
+(display (+ 1 (call/cc (lambda (k) (+ 10 (k 2)))))) ; returns 3
+(newline)
+(display (call/cc (lambda (k) 42))) ; returns 42
+(newline)
+
+(define (find-first pred lst)
+  (call-with-current-continuation
+    (lambda (return)
+      (for-each-item (lambda (x) (if (pred x) (return x))) lst)
+      #f)))
+(display (find-first (lambda (x) (> x 2)) '(1 2 3 4))) ; returns 3
+(newline)
+(display (find-first (lambda (x) (> x 9)) '(1 2 3 4))) ; returns #f
+(newline)
+
+; escaping out of a deep recursion
+(define (product lst)
+  (call/cc
+    (lambda (break)
+      (define (loop lst)
+        (cond ((eq? lst '()) 1)
+              ((= (car lst) 0) (break 0))
+              (else (* (car lst) (loop (cdr lst))))))
+      (loop lst))))
+(display (product '(1 2 3 4))) ; returns 24
+(newline)
+(display (product '(1 2 0 4))) ; returns 0
+(newline)
+
+; re-entering a continuation several times
+(define (re-enter)
+  (let ((i 0) (k #f) (acc '()))
+    (set! acc (cons (call/cc (lambda (c) (set! k c) i)) acc))
+    (set! i (+ i 1))
+    (if (< i 3) (k i) acc)))
+(display (re-enter)) ; returns (2 1 0)
+(newline)
+
+; generators
+(define (make-generator lst)
+  (define return #f)
+  (define (resume)
+    (for-each-item
+      (lambda (x)
+        (call/cc
+          (lambda (next)
+            (set! resume (lambda () (next #f)))
+            (return x))))
+      lst)
+    (return 'done))
+  (lambda ()
+    (call/cc
+      (lambda (r)
+        (set! return r)
+        (resume)))))
+
+(define next-item (make-generator '(a b c)))
+(display (list (next-item) (next-item) (next-item) (next-item))) ; returns (a b c done)
+(newline)
+
+; backtracking
+(define choices '())
+(define (fail)
+  (if (eq? choices '())
+    (error "no more choices")
+    (let ((k (car choices)))
+      (set! choices (cdr choices))
+      (k #f))))
+(define (amb lst)
+  (call/cc
+    (lambda (k)
+      (for-each-item
+        (lambda (choice)
+          (call/cc
+            (lambda (next)
+              (set! choices (cons next choices))
+              (k choice))))
+        lst)
+      (fail))))
+
+(display
+  (let* ((a (amb '(1 2 3 4 5)))
+         (b (amb '(1 2 3 4 5)))
+         (c (amb '(1 2 3 4 5))))
+    (if (= (* c c) (+ (* a a) (* b b)))
+      (list a b c)
+      (fail)))) ; returns (3 4 5)
+(newline)
+
+(call/cc (lambda (k) (k 1 2))) ; error
@@ -0,0 +1,42 @@
+; and, or, when, unless, case and do
+
+(display (and)) (newline)            ; returns #t
+(display (or)) (newline)             ; returns #f
+(display (and 1 2 3)) (newline)      ; returns 3
+(display (and 1 #f (car '()))) (newline) ; returns #f
+(display (or #f 2 (car '()))) (newline)  ; returns 2
+(display (or #f #f)) (newline)       ; returns #f
+(define (count-down n) (or (= n 0) (count-down (- n 1))))
+(display (count-down 100000)) (newline) ; returns #t
+
+; when, unless
+(display (when (> 2 1) 'a 'b)) (newline)   ; returns b
+(display (unless (> 2 1) 'a)) (newline)    ; returns #f
+(display (unless (< 2 1) 'c)) (newline)    ; returns c
+
+; case
+(define (classify x)
+  (case x
+    ((1 2 3) 'small)
+    ((#\a #\b) 'letter)
+    ((foo) 'foo)
+    (else 'other)))
+(display (classify 2)) (newline)     ; returns small
+(display (classify #\b)) (newline)   ; returns letter
+(display (classify 'foo)) (newline)  ; returns foo
+(display (classify 42)) (newline)    ; returns other
+(display (case 5 ((5) => (lambda (x) (* x x))) (else 0))) (newline) ; returns 25
+(display (case 7 ((5) 'five) (else => (lambda (x) (+ x 1))))) (newline) ; returns 8
+(display (case 7 ((5) 'five))) (newline) ; returns #f
+
+; do
+(display (do ((i 0 (+ i 1))
+              (acc '() (cons i acc)))
+             ((= i 5) acc)))
+(newline)                            ; returns (4 3 2 1 0)
+(define (sum-vector-like n)
+  (do ((i 0 (+ i 1))
+       (sum 0))
+      ((= i n) sum)
+    (set! sum (+ sum i))))
+(display (sum-vector-like 10)) (newline) ; returns 45
@@ -0,0 +1,17 @@
+(define (fact n)
+  (if (= n 1)
+      1
+      (* n (fact (- n 1)))))
+
+(define (sum-to n)
+  (define (iter i total)
+    (if (> i n)
+        total
+        (iter (+ i 1) (+ total i))))
+  (iter 1 0))
+
+(display (fact 3))
+(newline)
+(display (sum-to 4))
+(newline)
+(fact 'three)
@@ -0,0 +1,103 @@
+; dynamic-wind with escapes, re-entry and errors
+
+(define trail '())
+(define (note x) (set! trail (cons x trail)))
+(define (show-trail)
+  (display (reverse-list trail))
+  (newline)
+  (set! trail '()))
+(define (reverse-list l)
+  (define (loop l acc)
+    (if (eq? l '()) acc (loop (cdr l) (cons (car l) acc))))
+  (loop l '()))
+
+; a normal return runs before, thunk and after in order
+(display
+  (dynamic-wind
+    (lambda () (note 'before))
+    (lambda () (note 'during) 'result)
+    (lambda () (note 'after)))) ; returns result
+(newline)
+(show-trail) ; returns (before during after)
+
+; escaping out of the extent runs the after thunk
+(display
+  (call/cc
+    (lambda (k)
+      (dynamic-wind
+        (lambda () (note 'before))
+        (lambda () (k 'escaped) (note 'not-reached))
+        (lambda () (note 'after)))))) ; returns escaped
+(newline)
+(show-trail) ; returns (before after)
+
+; nested extents are left from the innermost one
+(call/cc
+  (lambda (k)
+    (dynamic-wind
+      (lambda () (note 'outer-before))
+      (lambda ()
+        (dynamic-wind
+          (lambda () (note 'inner-before))
+          (lambda () (k 'out))
+          (lambda () (note 'inner-after))))
+      (lambda () (note 'outer-after)))))
+(show-trail) ; returns (outer-before inner-before inner-after outer-after)
+
+; re-entering the extent runs the before thunk again
+(let ((k #f) (n 0))
+  (dynamic-wind
+    (lambda () (note 'in))
+    (lambda () (call/cc (lambda (c) (set! k c))) (note n))
+    (lambda () (note 'out)))
+  (set! n (+ n 1))
+  (if (< n 3) (k 'again)))
+(show-trail) ; returns (in 0 out in 1 out in 2 out)
+
+(define (not-yet? x) (eq? x #f))
+
+; jumping between two extents only winds the ones that differ
+(let ((k #f) (done #f))
+  (dynamic-wind
+    (lambda () (note 'a-in))
+    (lambda ()
+      (dynamic-wind
+        (lambda () (note 'b-in))
+        (lambda () (call/cc (lambda (c) (set! k c))))
+        (lambda () (note 'b-out)))
+      (if (not-yet? done)
+        (begin
+          (set! done #t)
+          (dynamic-wind
+            (lambda () (note 'c-in))
+            (lambda () (k 'back))
+            (lambda () (note 'c-out))))))
+    (lambda () (note 'a-out))))
+(show-trail) ; returns (a-in b-in b-out c-in c-out b-in b-out a-out)
+
+; generators keep their extents between calls
+(define (make-counter)
+  (define return #f)
+  (define (resume)
+    (dynamic-wind
+      (lambda () (note 'enter))
+      (lambda ()
+        (call/cc (lambda (next) (set! resume (lambda () (next #f))) (return 1)))
+        (call/cc (lambda (next) (set! resume (lambda () (next #f))) (return 2))))
+      (lambda () (note 'leave)))
+    (return 'done))
+  (lambda () (call/cc (lambda (r) (set! return r) (resume)))))
+(define counter (make-counter))
+(display (list (counter) (counter) (counter))) ; returns (1 2 done)
+(newline)
+(show-trail) ; returns (enter leave enter leave enter leave)
+
+; an error leaves every extent before the program stops
+(dynamic-wind
+  (lambda () (note 'before))
+  (lambda ()
+    (dynamic-wind
+      (lambda () #f)
+      (lambda () (car '()))
+      (lambda () (note 'inner-after) (show-trail))))
+  (lambda () (display "cleaned up") (newline)))
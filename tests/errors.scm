@@ -0,0 +1,22 @@
+; an error stops the program and is reported on stderr
+
+(define (safe-div a b)
+  (if (= b 0)
+    (error "division by zero:" a)
+    (* a b)))
+
+(display (safe-div 4 2)) ; returns 8
+(newline)
+(display (error-object? "not an error")) ; returns false
+(newline)
+; a malformed let-syntax is an error of the program, the lexical
+; addressing leaves it to the evaluator
+(display (guard (e ((error-object? e) (error-object-message e))) (let-syntax)))
+(newline)
+; a list tagged like a procedure of the machine is not one
+(display (guard (e ((error-object? e) (error-object-message e))) ((list 'machine 5 5) 1)))
+(newline)
+(display (guard (e ((error-object? e) (error-object-message e))) ((list 'compiled-procedure 5 5) 1)))
+(newline)
+(safe-div 1 0)
+(display "never reached")
@@ -0,0 +1,101 @@
+; raise, raise-continuable, with-exception-handler and guard
+
+; raise-continuable returns the value of the handler
+(display
+  (with-exception-handler
+    (lambda (c) (+ c 1))
+    (lambda () (* 2 (raise-continuable 20))))) ; returns 42
+(newline)
+
+; a handler escaping with a continuation
+(display
+  (call/cc
+    (lambda (k)
+      (with-exception-handler
+        (lambda (c) (k (list 'caught c)))
+        (lambda () (raise 'oops)))))) ; returns (caught oops)
+(newline)
+
+; guard
+(display (guard (e (#t (list 'guarded e))) (raise 'boom))) ; returns (guarded boom)
+(newline)
+(display (guard (e ((eq? e 1) 'one) ((eq? e 2) 'two)) (raise 2))) ; returns two
+(newline)
+(display (guard (e ((eq? e 1) 'one) (else 'other)) (raise 3))) ; returns other
+(newline)
+(display (guard (e (#f 'never)) 'no-exception)) ; returns no-exception
+(newline)
+(display (guard (e ((eq? e 5) => (lambda (x) (list 'arrow x)))) (raise 5))) ; returns (arrow #t)
+(newline)
+
+; errors signaled by primitives and by the machine are catchable
+(display (guard (e ((error-object? e) (error-object-message e))) (car '())))
+(newline) ; returns "car: value is not a pair"
+(display (guard (e ((error-object? e) (error-object-message e))) undefined-variable))
+(newline) ; returns "Unbound variable"
+(display (guard (e ((error-object? e) (error-object-irritants e))) (error "bad thing:" 1 2)))
+(newline) ; returns (1 2)
+(display (guard (e ((error-object? e) (error-object-message e))) (/ 1 0)))
+(newline)
+
+; nested handlers, the handler runs with the outer handlers installed
+(display
+  (with-exception-handler
+    (lambda (c) (list 'outer c))
+    (lambda ()
+      (with-exception-handler
+        (lambda (c) (raise-continuable (list 'inner c)))
+        (lambda () (raise-continuable 'x)))))) ; returns (outer (inner x))
+(newline)
+
+; re-raising from a guard without a matching clause
+(display
+  (guard (e ((eq? e 'outer) 'caught-outer))
+    (guard (e ((eq? e 'inner) 'caught-inner))
+      (raise 'outer)))) ; returns caught-outer
+(newline)
+
+(display
+  (guard (e (#t (list 'outer-saw e)))
+    (guard (e ((eq? e 'never) 'no))
+      (raise (list 1 2))))) ; returns (outer-saw (1 2))
+(newline)
+
+; re-raising from a guard clause
+(display
+  (guard (e (#t (list 're-raised e)))
+    (guard (e (#t (raise (list 'wrapped e))))
+      (raise 'first)))) ; returns (re-raised (wrapped first))
+(newline)
+
+; a guard that does not catch keeps the raise continuable
+(display
+  (with-exception-handler
+    (lambda (c) 10)
+    (lambda ()
+      (+ 1 (guard (e ((eq? e 'never) 0))
+             (raise-continuable 'c)))))) ; returns 11
+(newline)
+
+; guard runs the after thunks of dynamic-wind
+(display
+  (guard (e (#t 'caught))
+    (dynamic-wind
+      (lambda () (display "in "))
+      (lambda () (raise 'error))
+      (lambda () (display "out "))))) ; returns "in " "out " caught
+(newline)
+
+; handlers are restored after with-exception-handler returns
+(display
+  (guard (e (#t (list 'guard e)))
+    (with-exception-handler
+      (lambda (c) 'ignored)
+      (lambda () 'fine))
+    (raise 'later))) ; returns (guard later)
+(newline)
+
+; returning from the handler of raise is an error
+(with-exception-handler
+  (lambda (c) 'returned)
+  (lambda () (raise 'not-continuable)))
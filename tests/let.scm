@@ -0,0 +1,77 @@
+; let, let*, letrec, letrec*, named let, cond and
+; internal definitions
+
+(define x 10)
+
+(display (let ((x 1) (y 2)) (+ x y))) ; returns 3
+(newline)
+(display (let ((x 1) (y x)) y)) ; returns 10
+(newline)
+(display (let* ((x 1) (y x)) y)) ; returns 1
+(newline)
+(display (let* () x)) ; returns 10
+(newline)
+
+(display
+  (letrec ((even? (lambda (n) (if (= n 0) #t (odd? (- n 1)))))
+           (odd? (lambda (n) (if (= n 0) #f (even? (- n 1))))))
+    (even? 100))) ; returns #t
+(newline)
+
+(display (letrec* ((a 1) (b (+ a 1))) (list a b))) ; returns (1 2)
+(newline)
+
+; named let loops run in constant space
+(display
+  (let loop ((i 0) (acc '()))
+    (if (= i 5)
+      acc
+      (loop (+ i 1) (cons i acc))))) ; returns (4 3 2 1 0)
+(newline)
+(display (let count ((n 100000)) (if (= n 0) 'done (count (- n 1))))) ; returns done
+(newline)
+
+(define (classify n)
+  (cond ((< n 0) 'negative)
+        ((= n 0) 'zero)
+        ((< n 10) 'small)
+        (else 'large)))
+(display (list (classify -5) (classify 0) (classify 5) (classify 50))) ; returns (negative zero small large)
+(newline)
+
+(define (lookup key alist)
+  (cond ((null-pair? alist) #f)
+        ((eq? key (car (car alist))) (car alist))
+        (else (lookup key (cdr alist)))))
+(define (null-pair? l) (eq? l '()))
+
+(display (cond ((lookup 'b '((a 1) (b 2))) => cdr) (else 'missing))) ; returns (2)
+(newline)
+(display (cond ((lookup 'z '((a 1))) => cdr) (else 'missing))) ; returns missing
+(newline)
+(display (cond (#f 1) ((+ 1 1)))) ; returns 2
+(newline)
+(display (cond (#f 1))) ; returns #f
+(newline)
+
+; internal definitions see each other, like letrec*
+(define (f n)
+  (define (even? n) (if (= n 0) #t (odd? (- n 1))))
+  (define (odd? n) (if (= n 0) #f (even? (- n 1))))
+  (define twice (* n 2))
+  (list (even? n) twice))
+(display (f 7)) ; returns (#f 14)
+(newline)
+
+(define counter 0)
+(set! counter (+ counter 1))
+(define counter (+ counter 1))
+(display counter) ; returns 2
+(newline)
+
+; using an internal definition before it is assigned is an error
+(define (g)
+  (define a b)
+  (define b 1)
+  a)
+(g)
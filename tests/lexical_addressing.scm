@@ -0,0 +1,32 @@
+; variable references in every binding form, and definitions that
+; add a variable to a frame while the program runs
+(define (f a b)
+  (define c (+ a b))
+  (define (g x) (* x c a))
+  (let* ((p 1) (q (+ p a)))
+    (letrec ((even? (lambda (n) (if (= n 0) #t (odd? (- n 1)))))
+             (odd? (lambda (n) (if (= n 0) #f (even? (- n 1))))))
+      (define z (+ q b))
+      (list (g 2) (even? q) z
+            (cond ((assq-ish a) => (lambda (v) (+ v z)))
+                  ((+ a 0))
+                  (else b))
+            (case (+ a 1) ((1 2) (list a b)) ((3) => (lambda (k) (+ k c))) (else z))
+            (do ((i 0 (+ i 1)) (acc '() (cons (+ i a) acc))) ((= i 3) acc) (set! z (+ z 1)))
+            (guard (e ((symbol? e) (list e a z)) (else (list b e)))
+              (raise (if (> a 1) 'boom "str")))
+            `(a ,a b ,@(list b c) (nested `(x ,(y ,z))))
+            (let loop ((k 0) (s 0)) (if (= k a) s (loop (+ k 1) (+ s k q))))))))
+(define (assq-ish x) (if (> x 1) x #f))
+(display (f 2 3)) (newline)
+(display (f 1 3)) (newline)
+(define-macro (swap! x y) `(let ((tmp ,x)) (set! ,x ,y) (set! ,y tmp)))
+(define (h u v) (swap! u v) (list u v))
+(display (h 1 2)) (newline)
+(define (k n) (let-syntax ((inc (syntax-rules () ((_ v) (+ v n))))) (inc 10)))
+(display (k 5)) (newline)
+(define (dyn n) (when #t (define m (* n 2))) m)
+(display (dyn 4)) (newline)
+(define m 100)
+(define (dyn2 n) (define r m) (when (> n 0) (define m n)) (list r m))
+(display (dyn2 3)) (newline)
@@ -0,0 +1,87 @@
+; register machines made with make-machine
+(define gcd-machine
+  (make-machine
+   '(a b t)
+   (list (list 'rem remainder) (list '= =))
+   '(test-b
+       (test (op =) (reg b) (const 0))
+       (branch (label gcd-done))
+       (assign t (op rem) (reg a) (reg b))
+       (assign a (reg b))
+       (assign b (reg t))
+       (goto (label test-b))
+     gcd-done)))
+
+(display (set-register-contents! gcd-machine 'a 206))
+(newline)
+(set-register-contents! gcd-machine 'b 40)
+(display (start gcd-machine))
+(newline)
+(display (get-register-contents gcd-machine 'a))
+(newline)
+
+; the machine can be started again
+(set-register-contents! gcd-machine 'a 1071)
+(set-register-contents! gcd-machine 'b 462)
+(start gcd-machine)
+(display (get-register-contents gcd-machine 'a))
+(newline)
+
+; recursive factorial, continue holds a label and the stack
+; keeps n and continue across the recursive calls
+(define fact-machine
+  (make-machine
+   '(n val continue)
+   (list (list '= =) (list '- -) (list '* *))
+   '((perform (op initialize-stack))
+     (assign continue (label fact-done))
+   fact-loop
+     (test (op =) (reg n) (const 1))
+     (branch (label base-case))
+     (save continue)
+     (save n)
+     (assign n (op -) (reg n) (const 1))
+     (assign continue (label after-fact))
+     (goto (label fact-loop))
+   after-fact
+     (restore n)
+     (restore continue)
+     (assign val (op *) (reg n) (reg val))
+     (goto (reg continue))
+   base-case
+     (assign val (const 1))
+     (goto (reg continue))
+   fact-done)))
+
+(set-register-contents! fact-machine 'n 20)
+(start fact-machine)
+(display (get-register-contents fact-machine 'val))
+(newline)
+
+(display (get-register-contents (make-machine '(a) '() '()) 'a))
+(newline)
+(display fact-machine)
+(newline)
+
+; errors in a controller text are signaled when the machine is assembled,
+; the others when it runs
+(define-syntax show-error
+  (syntax-rules ()
+    ((_ exp)
+     (begin
+       (display (guard (e ((error-object? e)
+                           (cons (error-object-message e) (error-object-irritants e))))
+                  exp))
+       (newline)))))
+
+(show-error (make-machine '(a) '() '((assign b (const 1)))))
+(show-error (make-machine '(a) '() '((goto (label nowhere)))))
+(show-error (make-machine '(a) '() '((assign a (op rem) (reg a)))))
+(show-error (make-machine '(a) '() '(here here)))
+(show-error (make-machine '(a) (list (list 'f 'car)) '()))
+(show-error (start (make-machine '(a) '() '((restore a)))))
+(show-error (get-register-contents gcd-machine 'x))
+(show-error (start 'gcd-machine))
+
+(set-register-contents! gcd-machine 'b 'zero)
+(start gcd-machine)
@@ -0,0 +1,93 @@
+; define-macro, defmacro, macroexpand and macroexpand-1
+
+(define-macro (my-if test then else)
+  `(cond (,test ,then) (else ,else)))
+(display (my-if #t 'yes 'no)) ; returns yes
+(newline)
+(display (my-if #f (car '()) 'no)) ; returns no
+(newline)
+
+; procedures with rest parameters
+(display ((lambda args args) 1 2 3)) ; returns (1 2 3)
+(newline)
+(display ((lambda (a . rest) (list a rest)) 1)) ; returns (1 ())
+(newline)
+
+; a macro with a rest parameter
+(define-macro (while test . body)
+  `(let loop ()
+     (when ,test
+       ,@body
+       (loop))))
+(define i 0)
+(define acc '())
+(while (< i 5)
+  (set! acc (cons i acc))
+  (set! i (+ i 1)))
+(display acc) ; returns (4 3 2 1 0)
+(newline)
+
+; defmacro style
+(defmacro unless-zero (n . body)
+  `(if (= ,n 0) 'zero (begin ,@body)))
+(display (unless-zero 0 (car '()))) ; returns zero
+(newline)
+(display (unless-zero 2 'not-zero)) ; returns not-zero
+(newline)
+
+; a transformer given as a procedure
+(define-macro swap!
+  (lambda (a b)
+    `(let ((tmp ,a))
+       (set! ,a ,b)
+       (set! ,b tmp))))
+(define x 1)
+(define y 2)
+(swap! x y)
+(display (list x y)) ; returns (2 1)
+(newline)
+
+; macros are not hygienic, the expansion captures tmp
+(define tmp 3)
+(swap! tmp x)
+(display (list tmp x)) ; returns (3 2)
+(newline)
+
+; macros using other macros
+(define-macro (my-and . args)
+  (cond ((eq? args '()) #t)
+        ((eq? (cdr args) '()) (car args))
+        (else `(my-if ,(car args) (my-and ,@(cdr args)) #f))))
+(display (my-and 1 2 3)) ; returns 3
+(newline)
+(display (my-and 1 #f (car '()))) ; returns #f
+(newline)
+
+; macros defined inside a body are local
+(define (local-macro n)
+  (define-macro (double e) `(* 2 ,e))
+  (double n))
+(display (local-macro 21)) ; returns 42
+(newline)
+
+; a combination is expanded the first time it is evaluated
+(define expansions 0)
+(define-macro (counted e)
+  (set! expansions (+ expansions 1))
+  e)
+(define (count-to n)
+  (let loop ((i 0))
+    (if (< i n) (loop (counted (+ i 1))) i)))
+(display (list (count-to 5) (count-to 3) expansions)) ; returns (5 3 1)
+(newline)
+
+(display (macroexpand-1 '(my-and 1 2))) ; returns (my-if 1 (my-and 2) #f)
+(newline)
+(display (macroexpand '(my-and 1 2))) ; returns (cond (1 (my-and 2)) (else #f))
+(newline)
+(display (macroexpand '(+ 1 2))) ; returns (+ 1 2)
+(newline)
+(display (macroexpand-1 '(unless-zero x 'a))) ; returns (if (= x 0) (quote zero) (begin (quote a)))
+(newline)
+
+(define-macro not-a-macro 42) ; error
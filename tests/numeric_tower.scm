@@ -0,0 +1,40 @@
+; integers overflow into bignums and division of exact
+; numbers gives exact rationals
+
+(define (factorial n)
+  (if (= n 0)
+    1
+    (* n (factorial (- n 1)))))
+
+(display (factorial 100))
+(newline)
+(display (/ (factorial 100) (factorial 98))) ; returns 9900
+(newline)
+(display (+ 9223372036854775807 1)) ; returns 9223372036854775808
+(newline)
+(display (- -9223372036854775808 1)) ; returns -9223372036854775809
+(newline)
+(display (- (+ 9223372036854775807 1) 1)) ; returns 9223372036854775807
+(newline)
+(display (/ 1 3)) ; returns 1/3
+(newline)
+(display (+ 1/3 2/3)) ; returns 1
+(newline)
+(display (* 6/4 2)) ; returns 3
+(newline)
+(display (- 1/2 3/4)) ; returns -1/4
+(newline)
+(display (+ 1/2 0.25)) ; returns 0.75
+(newline)
+(display (list #e1.5 #e0.1 #i1/4 #x1F #b101 #o17 #x-ff #e#x10)) ; returns (3/2 1/10 0.25 31 5 15 -255 16)
+(newline)
+(display (list (< 1/3 0.34) (= 1/2 0.5) (> (factorial 30) (factorial 29)) (= 2/4 1/2))) ; returns (#t #t #t #t)
+(newline)
+(display (list (quotient (factorial 25) (factorial 23)) (remainder (factorial 25) 7) (modulo -7 (factorial 21)))) ; returns (600 0 51090942171709439993)
+(newline)
+(display (list (gcd (factorial 20) (factorial 22)) (lcm 4 6 (factorial 20)))) ; returns (2432902008176640000 2432902008176640000)
+(newline)
+(display (list (exact->inexact 1/3) (inexact->exact 0.5) (abs -1/2) (max 1/2 1/3))) ; returns (0.3333333333333333 1/2 1/2 1/2)
+(newline)
+(display (list +inf.0 -inf.0 (/ 1.0 0))) ; returns (+inf.0 -inf.0 +inf.0)
+(newline)
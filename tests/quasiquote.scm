@@ -0,0 +1,35 @@
+; quote and quasiquote, with unquote and unquote-splicing
+; at several nesting levels
+
+(define x 42)
+(define items '(a b c))
+
+(display '(1 2 3)) ; returns (1 2 3)
+(newline)
+(display (quote (quote a))) ; returns (quote a)
+(newline)
+(display `(x ,x)) ; returns (x 42)
+(newline)
+(display `(1 ,@items 2)) ; returns (1 a b c 2)
+(newline)
+(display `(,@items . tail)) ; returns (a b c . tail)
+(newline)
+(display `(1 . ,x)) ; returns (1 . 42)
+(newline)
+(display `(sum ,(+ 1 2) ,@(list 4 5) ,@'())) ; returns (sum 3 4 5)
+(newline)
+(display `((nested ,x) (list ,@items))) ; returns ((nested 42) (list a b c))
+(newline)
+
+; nested quasiquotes only evaluate the innermost level of unquote
+(display `(a `(b ,(c ,x)))) ; returns (a (quasiquote (b (unquote (c 42)))))
+(newline)
+(display `(a `(b ,,x))) ; returns (a (quasiquote (b (unquote 42))))
+(newline)
+(display `(1 `(2 ,@(3 ,@items)))) ; returns (1 (quasiquote (2 (unquote-splicing (3 a b c)))))
+(newline)
+
+(define (make-adder n)
+  `(lambda (y) (+ y ,n)))
+(display (make-adder 5)) ; returns (lambda (y) (+ y 5))
+(newline)
@@ -0,0 +1,28 @@
+; the reader understands quotes, dotted pairs, booleans,
+; characters and string escapes
+
+(display '(1 2 3)) ; returns (1 2 3)
+(newline)
+(display (quote (a . b))) ; returns (a . b)
+(newline)
+(display '(1 (2 3) . 4)) ; returns (1 (2 3) . 4)
+(newline)
+(display '()) ; returns ()
+(newline)
+(display #t) ; returns #t
+(newline)
+(display #false) ; returns #f
+(newline)
+(display #\a) ; returns #\a
+(newline)
+(display '(#\space #\x41 #\newline)) ; returns (#\space #\A #\newline)
+(newline)
+(display "tab:\there, quote:\", hex:\x3bb;") ; returns "tab:	here, quote:", hex:λ"
+(newline)
+(display -12) ; returns -12
+(newline)
+(display 'fish) ; returns fish
+(newline)
+
+; parse errors are reported with their position
+(display (1 . 2 3))
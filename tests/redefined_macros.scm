@@ -0,0 +1,9 @@
+; a use of a macro is expanded again once the macro is redefined. The
+; virtual machine expands a use once, when it compiles it, and keeps
+; the old expansion: redefined_macros.vm.out records what it prints
+(define-macro (twice x) `(list ,x ,x))
+(define (f) (twice 1))
+(display (f))
+(define-macro (twice x) x)
+(display (f))
+(newline)
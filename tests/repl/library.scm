@@ -0,0 +1,2 @@
+(define (square x) (* x x))
+(define loaded 'yes)
@@ -0,0 +1,85 @@
+; the statistics of the stack tell iterative processes from recursive ones
+(define (assq key alist)
+  (cond ((eq? alist '()) #f)
+        ((eq? key (car (car alist))) (car alist))
+        (else (assq key (cdr alist)))))
+
+(define (statistic name thunk)
+  (reset-statistics!)
+  (thunk)
+  (cdr (assq name (machine-statistics))))
+
+(define (fact-iter n)
+  (define (iter product counter)
+    (if (> counter n)
+        product
+        (iter (* counter product) (+ counter 1))))
+  (iter 1 1))
+
+(define (fact n)
+  (if (= n 1)
+      1
+      (* n (fact (- n 1)))))
+
+; the depth of an iterative process does not grow with n, the depth
+; counts what is on the stack where the statistics are taken
+(define iter-10 (statistic 'maximum-depth (lambda () (fact-iter 10))))
+(define iter-1000 (statistic 'maximum-depth (lambda () (fact-iter 1000))))
+(display (= iter-10 iter-1000))
+(newline)
+
+; the depth and the pushes of a recursive process grow linearly
+(define depth-10 (statistic 'maximum-depth (lambda () (fact 10))))
+(define depth-20 (statistic 'maximum-depth (lambda () (fact 20))))
+(define depth-30 (statistic 'maximum-depth (lambda () (fact 30))))
+(display (list (< depth-10 depth-20) (= (- depth-20 depth-10) (- depth-30 depth-20))))
+(newline)
+(define pushes-10 (statistic 'total-pushes (lambda () (fact 10))))
+(define pushes-20 (statistic 'total-pushes (lambda () (fact 20))))
+(define pushes-30 (statistic 'total-pushes (lambda () (fact 30))))
+(display (list (< pushes-10 pushes-20) (= (- pushes-20 pushes-10) (- pushes-30 pushes-20))))
+(newline)
+
+; a machine made with make-machine counts its own pushes, the
+; instructions executed after each label and the assignments
+(define fact-machine
+  (make-machine
+   '(n val continue)
+   (list (list '= =) (list '- -) (list '* *))
+   '((assign continue (label fact-done))
+   fact-loop
+     (test (op =) (reg n) (const 1))
+     (branch (label base-case))
+     (save continue)
+     (save n)
+     (assign n (op -) (reg n) (const 1))
+     (assign continue (label after-fact))
+     (goto (label fact-loop))
+   after-fact
+     (restore n)
+     (restore continue)
+     (assign val (op *) (reg n) (reg val))
+     (goto (reg continue))
+   base-case
+     (assign val (const 1))
+     (goto (reg continue))
+   fact-done)))
+
+(set-register-contents! fact-machine 'n 5)
+(reset-statistics! fact-machine)
+(start fact-machine)
+(display (get-register-contents fact-machine 'val))
+(newline)
+(display (machine-statistics fact-machine))
+(newline)
+
+(reset-statistics! fact-machine)
+(display (machine-statistics fact-machine))
+(newline)
+(set-register-contents! fact-machine 'n 10)
+(start fact-machine)
+(define stats (machine-statistics fact-machine))
+(display (list (assq 'total-pushes stats) (assq 'maximum-depth stats)))
+(newline)
+
+(reset-statistics! 'fact-machine)
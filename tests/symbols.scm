@@ -0,0 +1,42 @@
+; interned symbols, symbol->string, string->symbol and gensym
+
+(display (eq? 'abc 'abc)) ; returns #t
+(newline)
+(display (eq? 'abc 'abd)) ; returns #f
+(newline)
+(display (eq? (string->symbol "hello") 'hello)) ; returns #t
+(newline)
+(display (symbol->string 'hello)) ; returns "hello"
+(newline)
+(display (symbol? 'x)) ; returns #t
+(newline)
+(display (symbol? "x")) ; returns #f
+(newline)
+(display (symbol? (car '(a b)))) ; returns #t
+(newline)
+
+; quoted symbols and variable names are the same values
+(define-macro (name-of var) `(quote ,var))
+(display (eq? (name-of x) 'x)) ; returns #t
+(newline)
+
+; uninterned symbols are only equal to themselves
+(define g (gensym))
+(display (eq? g g)) ; returns #t
+(newline)
+(display (eq? (gensym) (gensym))) ; returns #f
+(newline)
+(display (symbol? (generate-uninterned-symbol))) ; returns #t
+(newline)
+(display (eq? (string->symbol (symbol->string g)) g)) ; returns #f
+(newline)
+
+; gensym names the variables introduced by non-hygienic macros
+(define-macro (my-or2 a b)
+  (let ((t (gensym "t")))
+    `(let ((,t ,a)) (if ,t ,t ,b))))
+(define t 5)
+(display (my-or2 #f t)) ; returns 5
+(newline)
+
+(symbol->string "not a symbol") ; error
@@ -0,0 +1,172 @@
+; syntax-rules macros with define-syntax, let-syntax and letrec-syntax
+
+(define-syntax swap!
+  (syntax-rules ()
+    ((_ a b)
+     (let ((tmp a))
+       (set! a b)
+       (set! b tmp)))))
+
+(define x 1)
+(define y 2)
+(swap! x y)
+(display (list x y)) ; returns (2 1)
+(newline)
+
+; the tmp of the expansion does not capture the tmp of the user
+(define tmp 3)
+(swap! tmp x)
+(display (list tmp x)) ; returns (2 3)
+(newline)
+
+(define-syntax my-or
+  (syntax-rules ()
+    ((_) #f)
+    ((_ e) e)
+    ((_ e1 e2 ...)
+     (let ((temp e1))
+       (if temp temp (my-or e2 ...))))))
+
+(define temp 5)
+(display (my-or #f temp)) ; returns 5
+(newline)
+(display (let ((temp 6)) (my-or #f temp))) ; returns 6
+(newline)
+(display (my-or)) ; returns #f
+(newline)
+
+; referential transparency, the names of the expansion refer to the
+; bindings where the macro was defined
+(display (let ((if list) (let 'shadowed)) (my-or #f 7))) ; returns 7
+(newline)
+
+(define (helper x) (* x 10))
+(define-syntax call-helper
+  (syntax-rules ()
+    ((_ e) (helper e))))
+(display (let ((helper (lambda (x) 'wrong))) (call-helper 4))) ; returns 40
+(newline)
+
+; literals
+(define-syntax my-cond
+  (syntax-rules (else)
+    ((_ (else e ...)) (begin e ...))
+    ((_ (c e ...) clause ...) (if c (begin e ...) (my-cond clause ...)))))
+(display (my-cond (#f 1) ((= 1 1) 2) (else 3))) ; returns 2
+(newline)
+(display (my-cond (#f 1) (else 3))) ; returns 3
+(newline)
+
+; a literal matches by binding, => bound around the use is a variable
+(define-syntax lit
+  (syntax-rules (=>)
+    ((_ a => b) (list 'arrow a b))
+    ((_ a b c) (list 'plain a b c))))
+(display (lit 1 => 2)) ; returns (arrow 1 2)
+(newline)
+(display (let ((=> 0)) (lit 1 => 2))) ; returns (plain 1 0 2)
+(newline)
+(define (lit-in-body =>) (lit 1 => 2))
+(display (lit-in-body 5)) ; returns (plain 1 5 2)
+(newline)
+; the same binding where the macro is defined and used
+(display (let ((=> 0))
+           (let-syntax ((lit (syntax-rules (=>)
+                               ((_ a => b) 'arrow)
+                               ((_ a b c) 'plain))))
+             (lit 1 => 2)))) ; returns arrow
+(newline)
+
+; nested ellipses
+(define-syntax my-let*
+  (syntax-rules ()
+    ((_ () body ...) (let () body ...))
+    ((_ ((name value) rest ...) body ...)
+     (let ((name value)) (my-let* (rest ...) body ...)))))
+(display (my-let* ((a 1) (b (+ a 1)) (c (* b 3))) (list a b c))) ; returns (1 2 6)
+(newline)
+
+(define-syntax flatten
+  (syntax-rules ()
+    ((_ (a ...) ...) '(a ... ...))))
+(display (flatten (1 2) () (3) (4 5 6))) ; returns (1 2 3 4 5 6)
+(newline)
+
+(define-syntax pairs
+  (syntax-rules ()
+    ((_ (k v ...) ...) '((k . (v ...)) ...))))
+(display (pairs (a 1 2) (b) (c 3))) ; returns ((a 1 2) (b) (c 3))
+(newline)
+
+; an ellipsis followed by more patterns
+(define-syntax last-of
+  (syntax-rules ()
+    ((_ x ... y) 'y)))
+(display (last-of 1 2 3)) ; returns 3
+(newline)
+
+; quoted names in the expansion are plain symbols
+(define-syntax quote-it
+  (syntax-rules ()
+    ((_ e) '(e tmp))))
+(display (eq? (car (cdr (quote-it 1))) 'tmp)) ; returns #t
+(newline)
+
+; a custom ellipsis and an escaped ellipsis
+(define-syntax my-list
+  (syntax-rules ::: ()
+    ((_ e :::) (list e :::))))
+(display (my-list 1 2 3)) ; returns (1 2 3)
+(newline)
+(define-syntax ellipsis-datum
+  (syntax-rules ()
+    ((_ e) '(e (... ...)))))
+(display (ellipsis-datum 1)) ; returns (1 ...)
+(newline)
+
+; macros that define macros
+(define-syntax define-getter
+  (syntax-rules ()
+    ((_ name value)
+     (define-syntax name
+       (syntax-rules ()
+         ((_) value))))))
+(define-getter get-five 5)
+(display (get-five)) ; returns 5
+(newline)
+
+; let-syntax and letrec-syntax
+(display
+  (let-syntax ((foo (syntax-rules () ((_ e) (* e 2)))))
+    (foo 21))) ; returns 42
+(newline)
+
+(define (outer) 'outer)
+(display
+  (let ((outer (lambda () 'local)))
+    (let-syntax ((call-outer (syntax-rules () ((_) (outer)))))
+      (call-outer)))) ; returns local
+(newline)
+
+(display
+  (letrec-syntax
+    ((my-and (syntax-rules ()
+               ((_) #t)
+               ((_ e) e)
+               ((_ e1 e2 ...) (if e1 (my-and e2 ...) #f)))))
+    (my-and 1 2 3))) ; returns 3
+(newline)
+
+; an introduced top level binding does not clash with the user's
+(define-syntax while
+  (syntax-rules ()
+    ((_ test body ...)
+     (let loop ()
+       (when test body ... (loop))))))
+(define loop 'user-loop)
+(define i 0)
+(while (< i 3) (set! i (+ i 1)))
+(display (list i loop)) ; returns (3 user-loop)
+(newline)
+
+(my-cond) ; error
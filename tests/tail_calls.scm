@@ -0,0 +1,33 @@
+; tail calls must run in constant space, both in the Go stack
+; and in the machine stack
+
+(define (count-down n)
+  (if (= n 0)
+    "done"
+    (count-down (- n 1))))
+
+(display (count-down 1000000)) ; returns "done"
+(newline)
+
+(define (ping n)
+  (if (= n 0)
+    "ping"
+    (pong (- n 1))))
+
+(define (pong n)
+  (if (= n 0)
+    "pong"
+    (ping (- n 1))))
+
+(display (ping 100001)) ; returns "pong"
+(newline)
+
+(define (sum-to n acc)
+  (if (= n 0)
+    acc
+    (begin
+      (= n acc)
+      (sum-to (- n 1) (+ acc n)))))
+
+(display (sum-to 100000 0)) ; returns 5000050000
+(newline)
@@ -0,0 +1,62 @@
+; traced procedures print their calls and values, indented by depth
+(define (fact n)
+  (if (= n 1)
+      1
+      (* n (fact (- n 1)))))
+(trace fact)
+(display (fact 4)) ; returns 24
+(newline)
+
+; tail calls are marked and keep the depth of the call they replace
+(define (count-down n)
+  (if (= n 0)
+      'done
+      (count-down (- n 1))))
+(trace count-down)
+(display (count-down 3)) ; returns done
+(newline)
+
+; procedures that call each other in tail position
+(define (my-even? n) (if (= n 0) #t (my-odd? (- n 1))))
+(define (my-odd? n) (if (= n 0) #f (my-even? (- n 1))))
+(trace my-even? my-odd?)
+(display (my-even? 4)) ; returns #t
+(newline)
+
+; a traced call nested in an untraced one, and a loop of named let
+(define (sum-squares lst)
+  (let loop ((lst lst) (total 0))
+    (if (eq? lst '())
+        total
+        (loop (cdr lst) (+ total (square (car lst)))))))
+(define (square x) (* x x))
+(trace square)
+(display (sum-squares '(1 2 3))) ; returns 14
+(newline)
+
+; a tail call that is not traced returns through the traced call
+(define (twice x) (* 2 x))
+(define (quadruple x) (twice (twice x)))
+(trace quadruple)
+(display (quadruple 5)) ; returns 20
+(newline)
+
+; untrace stops tracing a procedure, without arguments every procedure
+(untrace fact)
+(display (fact 3)) ; returns 6
+(newline)
+(untrace)
+(display (list (count-down 2) (square 3) (my-even? 2))) ; returns (done 9 #t)
+(newline)
+
+; tracing a procedure again
+(trace fact)
+(display (fact 2)) ; returns 2
+(newline)
+(untrace)
+
+; only compound procedures can be traced, not their names
+(display (guard (e ((error-object? e)
+                   (cons (error-object-message e) (error-object-irritants e))))
+  (trace (quote fact)))) ; returns ("trace: not a compound procedure" fact)
+(newline)
@@ -0,0 +1,196 @@
+package scm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tracing prints every call to a traced procedure with its arguments,
+// and the value it returns, indented by the number of traced calls that
+// have not returned yet. The evaluator traces a call before it applies
+// the procedure: the continuation of the call is saved with a trace
+// frame that holds the name and the depth of the call, and the call
+// returns through trace_return, which prints the value. A call whose
+// continuation is already trace_return is a tail call, it is marked as
+// one and returns through the same trace frame, so a loop of tail
+// calls prints at the same depth and runs in constant space. The
+// virtual machine keeps the depth in its frames and traces the same way.
+
+// the tag of the trace frames saved on the stack, only they hold it
+var trace_tag = make_uninterned_name("trace")
+
+func make_trace_frame(name string, depth int) *Value {
+	return list(trace_tag, make_name(name), make_integer(int64(depth)))
+}
+
+func is_trace_frame(v *Value) bool {
+	return isPair(v) && car(v) == trace_tag
+}
+
+func trace_frame_depth(frame *Value) int {
+	return int(caddr(frame).val.(int64))
+}
+
+// SetTracing traces every call to a compound or compiled procedure
+// bound to a name, or only the calls to the procedures passed to trace.
+// It is off by default.
+func (in *Interpreter) SetTracing(on bool) {
+	in.trace_all = on
+}
+
+// procedures are traced by what identifies them, the list of a compound
+// or compiled procedure and the closure of the virtual machine
+func (in *Interpreter) is_traced(proc *Value) bool {
+	return in.trace_all || len(in.traced) > 0 && in.traced[proc.val]
+}
+
+// tracing every procedure leaves out the procedures without a name, the
+// lambda expressions a let becomes are not procedures in the virtual
+// machine
+func (in *Interpreter) traces(key interface{}, name string) bool {
+	return in.traced[key] || in.trace_all && name != anonymous_procedure
+}
+
+const anonymous_procedure = "#<procedure>"
+
+// the name of a closure, the name of the variable it was defined for
+func closure_procedure_name(c *closure) string {
+	if c.proto.name != "" {
+		return c.proto.name
+	}
+	return anonymous_procedure
+}
+
+// the name a procedure was defined for, or assigned to by letrec and
+// named let, is bound to it in the first frame of its environment. The
+// global frame keeps the names of its procedures, the other frames are
+// small enough to be scanned
+func procedure_name(proc *Value) string {
+	env := traced_environment(proc)
+	if env == the_empty_environment {
+		return anonymous_procedure
+	}
+	frame := first_frame(env)
+	if is_global_frame(frame) {
+		if name, ok := frame.names[proc]; ok {
+			return name.String()
+		}
+		return anonymous_procedure
+	}
+	for i, v := range frame_values(frame) {
+		if v == proc {
+			return frame_variables(frame)[i].String()
+		}
+	}
+	return anonymous_procedure
+}
+
+func traced_environment(proc *Value) *Value {
+	if test(is_compiled_procedure(proc)) {
+		return compiled_procedure_env(proc)
+	}
+	return procedure_environment(proc)
+}
+
+func (in *Interpreter) trace_call(depth int, name string, args *Value, tail bool) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s(%s", strings.Repeat("  ", depth), name)
+	for ; isPair(args); args = cdr(args) {
+		fmt.Fprintf(&b, " %s", describe_traced(car(args)))
+	}
+	b.WriteString(")")
+	if tail {
+		b.WriteString(" [tail call]")
+	}
+	fmt.Fprintln(in.out, b.String())
+}
+
+func (in *Interpreter) trace_value(depth int, v *Value) {
+	fmt.Fprintf(in.out, "%s=> %s\n", strings.Repeat("  ", depth), describe_traced(v))
+}
+
+// procedures print the same in the evaluator, the compiler and the
+// virtual machine
+func describe_traced(v *Value) string {
+	if v != nil && (test(is_compound_procedure(v)) || test(is_compiled_procedure(v)) || is_closure(v)) {
+		return anonymous_procedure
+	}
+	return describe(v)
+}
+
+// a traced compound or compiled procedure in proc is applied to the
+// arguments in argl, the continuation is on top of the stack
+func (in *Interpreter) trace_apply() {
+	in.restore(in.cont)
+	name := procedure_name(reg(in.proc))
+	traced := in.traces(reg(in.proc).val, name)
+	if reg(in.cont) == in.trace_return_label {
+		// a tail call, the trace frame is below the continuation
+		if traced {
+			depth := trace_frame_depth(in.stack.items.Front().Value.(*Value))
+			in.trace_call(depth, name, reg(in.argl), true)
+		}
+		in.save(in.cont)
+		in.go_to(label(in.traced_apply))
+		return
+	}
+	if !traced {
+		in.save(in.cont)
+		in.go_to(label(in.traced_apply))
+		return
+	}
+
+	depth := 0
+	for e := in.stack.items.Front(); e != nil; e = e.Next() {
+		if v := e.Value.(*Value); is_trace_frame(v) {
+			depth = trace_frame_depth(v) + 1
+			break
+		}
+	}
+	in.trace_call(depth, name, reg(in.argl), false)
+	in.save(in.cont)
+	assign(in.unev, make_trace_frame(name, depth))
+	in.save(in.unev)
+	assign(in.cont, in.trace_return_label)
+	in.save(in.cont)
+	in.go_to(label(in.traced_apply))
+}
+
+func (in *Interpreter) traced_apply() {
+	if test(is_compiled_procedure(reg(in.proc))) {
+		in.go_to(label(in.compiled_apply))
+		return
+	}
+	in.go_to(label(in.compound_apply))
+}
+
+// a traced call returns its value in val
+func (in *Interpreter) trace_return() {
+	in.restore(in.unev)
+	in.trace_value(trace_frame_depth(reg(in.unev)), reg(in.val))
+	in.restore(in.cont)
+	in.go_to(reg(in.cont))
+}
+
+// trace primitives, untrace without arguments stops tracing
+// every procedure
+func (in *Interpreter) trace(args *Value) *Value {
+	for ; isPair(args); args = cdr(args) {
+		proc := car(args)
+		if !test(is_compound_procedure(proc)) && !test(is_compiled_procedure(proc)) && !is_closure(proc) {
+			raise_error(WrongTypeError, "trace: not a compound procedure", proc)
+		}
+		in.traced[proc.val] = true
+	}
+	return make_name("ok")
+}
+
+func (in *Interpreter) untrace(args *Value) *Value {
+	if !isPair(args) {
+		in.traced = map[interface{}]bool{}
+	}
+	for ; isPair(args); args = cdr(args) {
+		delete(in.traced, car(args).val)
+	}
+	return make_name("ok")
+}
@@ -0,0 +1,768 @@
+package scm
+
+// The virtual machine that runs the bytecode of the compiler. It shares
+// the values, the primitives and the global environment with the
+// evaluator, and implements the procedures of the evaluator that need
+// the machine itself, like call/cc, dynamic-wind and the exception
+// handlers, with frames of its own: a frame with a resume function
+// receives the value returned to it instead of running instructions.
+// A continuation is a copy of the stack and of the frames, the
+// variables that can change are in cells and are not copied.
+
+type frame struct {
+	closure *closure
+	ip      int
+	// the first slot, the procedure called is just below it
+	base  int
+	cells []*cell
+	// a frame of the machine, base is the top of the stack when it
+	// was pushed and resume is called with the value returned to it
+	resume func(m *vm, v *Value)
+	// the frame runs in a traced call at trace_depth, the frame of the
+	// call or of a tail call made by it prints the value it returns
+	traced        bool
+	trace_depth   int
+	trace_returns bool
+}
+
+type vm struct {
+	in     *Interpreter
+	stack  []*Value
+	sp     int
+	frames []frame
+
+	// the before and after thunks of the active dynamic-wind calls,
+	// and the installed exception handlers, as in the evaluator
+	winders  *Value
+	handlers *Value
+
+	// the position and the expression of the last expression
+	// evaluated before the last call, return or join
+	where *Position
+	exp   *Value
+	// the combination of the last call, the errors signaled by a
+	// primitive report its position, the errors of the call itself
+	// report the combination
+	combination *form
+	// set while a primitive procedure runs or the arguments of a
+	// machine procedure are checked
+	in_primitive bool
+	// set while the arguments of a call are checked against the
+	// parameters of the procedure
+	in_call bool
+
+	// what the machine does before it runs the next instruction, it
+	// signals the error recovered by run or starts a call
+	next func(m *vm)
+
+	// the value returned by the procedure at the bottom of the stack,
+	// or the error that stopped the machine
+	value *Value
+	err   *SchemeError
+}
+
+// the state captured by call/cc
+type vmContinuation struct {
+	stack    []*Value
+	frames   []frame
+	winders  *Value
+	handlers *Value
+}
+
+func (in *Interpreter) new_vm() *vm {
+	return &vm{
+		in:       in,
+		winders:  the_empty_extent,
+		handlers: no_handlers,
+	}
+}
+
+// evaluate datum at the top level on the virtual machine, the value
+// is left in the val register like startEval does
+func (in *Interpreter) eval_bytecode(datum *Value) error {
+	m := in.new_vm()
+	err := in.each_toplevel(datum, nil, func(exp *Value, where *Position) error {
+		if where != nil {
+			m.where = where
+		}
+		m.execute_toplevel(in.compile_toplevel(exp))
+		if m.err != nil {
+			return m.err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	assign(in.val, m.value)
+	return nil
+}
+
+// call f with every expression of exp that is compiled on its own: the
+// expressions of a begin and the expansions of the macros are compiled
+// after the expressions before them ran, so they can use the macros
+// these define. where is the position of the last expression evaluated
+func (in *Interpreter) each_toplevel(exp *Value, where *Position, f func(exp *Value, where *Position) error) (err error) {
+	if exp.pos != nil {
+		where = exp.pos
+	}
+
+	if in.special_form(exp) == nil && isPair(exp) && isName(car(exp)) {
+		if macro := binding_value(car(exp), in.global); macro != nil && test(is_macro(macro)) {
+			expansion, err := in.try_expand_macro(macro, exp, where)
+			if err != nil {
+				return err
+			}
+			return in.each_toplevel(expansion, where, f)
+		}
+	}
+
+	if in.special_form(exp) != nil && original_name(car(exp)) == make_name("begin") && isPair(cdr(exp)) {
+		for exps := begin_actions(exp); isPair(exps); exps = cdr(exps) {
+			if err := in.each_toplevel(car(exps), where, f); err != nil {
+				return err
+			}
+			where = nil
+		}
+		return nil
+	}
+
+	return f(exp, where)
+}
+
+// the expansion of the use of macro in exp, the transformer runs on
+// a machine of its own and its errors are panics like the ones of
+// the primitives. binding is the lexical binding of a name where
+// the macro is used
+func (in *Interpreter) expand_macro(macro *Value, exp *Value, where *Position, binding func(name *Value) interface{}) *Value {
+	in.use_binding = binding
+	defer func() {
+		in.use_binding = nil
+	}()
+	return in.vm_apply(macro_transformer(macro), operands(exp), where)
+}
+
+// the expansion of a macro used at the top level
+func (in *Interpreter) try_expand_macro(macro *Value, exp *Value, where *Position) (expansion *Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			serr, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			err = serr
+		}
+	}()
+	return in.expand_macro(macro, exp, where, nil), nil
+}
+
+// apply proc to args on a machine of its own
+func (in *Interpreter) vm_apply(proc *Value, args *Value, where *Position) *Value {
+	m := in.new_vm()
+	m.where = where
+	m.next = func(m *vm) {
+		m.apply(proc, args)
+	}
+	m.execute()
+	if m.err != nil {
+		panic(m.err)
+	}
+	return m.value
+}
+
+// the value of exp evaluated in the global environment while an
+// expression is compiled
+func (in *Interpreter) evaluate_at_compile_time(exp *Value, where *Position) *Value {
+	m := in.new_vm()
+	m.where = where
+	m.execute_toplevel(in.compile_toplevel(exp))
+	if m.err != nil {
+		panic(m.err)
+	}
+	return m.value
+}
+
+func (m *vm) execute_toplevel(p *Prototype) {
+	m.next = func(m *vm) {
+		m.apply(make_closure(p, nil), nullValue)
+	}
+	m.execute()
+}
+
+// run the machine until the procedure at the bottom of the stack
+// returns or an error is not handled
+func (m *vm) execute() {
+	for !m.run() {
+		// an error was signaled, keep going with its handler
+	}
+}
+
+// run instructions until the machine stops. A *SchemeError raised by a
+// primitive or an instruction is signaled like raise does, run returns
+// false so that execute() resumes the machine with the handler
+func (m *vm) run() (stopped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*SchemeError)
+			if !ok {
+				panic(r)
+			}
+			where, exp := m.last_expression()
+			if m.in_call && m.combination != nil {
+				where, exp = m.combination.pos, m.combination.exp
+			}
+			if err.Exp == nil && !m.in_primitive {
+				err.Exp = exp
+			}
+			if err.Pos == nil {
+				switch {
+				case err.Exp != nil && err.Exp.pos != nil:
+					err.Pos = err.Exp.pos
+				case m.in_primitive && m.combination != nil && m.combination.pos != nil:
+					err.Pos = m.combination.pos
+				default:
+					err.Pos = where
+				}
+			}
+			m.in_primitive = false
+			m.in_call = false
+			obj := make_error(err)
+			m.next = func(m *vm) {
+				m.signal(obj)
+			}
+			stopped = false
+		}
+	}()
+
+	if next := m.next; next != nil {
+		m.next = nil
+		next(m)
+	}
+
+	for len(m.frames) > 0 {
+		f := &m.frames[len(m.frames)-1]
+		p := f.closure.proto
+		i := p.code[f.ip]
+		f.ip++
+		arg := instruction_operand(i)
+		if m.in.stats.counting {
+			m.in.stats.executed(instruction_opcode(i).String())
+		}
+
+		switch instruction_opcode(i) {
+		case op_const:
+			m.push(p.constants[arg])
+		case op_pop:
+			m.sp--
+		case op_local:
+			m.push(m.stack[f.base+arg])
+		case op_set_local:
+			m.sp--
+			m.stack[f.base+arg] = m.stack[m.sp]
+		case op_box:
+			m.sp--
+			f.cells[arg] = &cell{value: m.stack[m.sp]}
+		case op_new_cell:
+			f.cells[arg] = &cell{}
+		case op_cell:
+			m.push(f.cells[arg].value)
+		case op_set_cell:
+			m.sp--
+			f.cells[arg].value = m.stack[m.sp]
+		case op_upvalue:
+			m.push(f.closure.upvalues[arg].value)
+		case op_set_upvalue:
+			m.sp--
+			f.closure.upvalues[arg].value = m.stack[m.sp]
+		case op_global:
+			m.push(lookup_variable_value(p.constants[arg], m.in.global))
+		case op_set_global:
+			set_variable_value(p.constants[arg], m.stack[m.sp-1], m.in.global)
+			m.sp--
+		case op_define_global:
+			m.sp--
+			define_variable(p.constants[arg], m.stack[m.sp], m.in.global)
+		case op_check:
+			if m.stack[m.sp-1] == unassigned_value {
+				raise_error(UnboundError, "Unassigned variable", p.constants[arg])
+			}
+		case op_jump_undefined:
+			if m.stack[m.sp-1] == nil {
+				m.sp--
+				f.ip = arg
+			}
+		case op_jump:
+			f.ip = arg
+		case op_jump_false:
+			m.sp--
+			if !isTrue(m.stack[m.sp]) {
+				f.ip = arg
+			}
+		case op_jump_false_or_pop:
+			if !isTrue(m.stack[m.sp-1]) {
+				f.ip = arg
+			} else {
+				m.sp--
+			}
+		case op_jump_true_or_pop:
+			if isTrue(m.stack[m.sp-1]) {
+				f.ip = arg
+			} else {
+				m.sp--
+			}
+		case op_closure:
+			child := p.prototypes[arg]
+			upvalues := make([]*cell, len(child.captures))
+			for i, c := range child.captures {
+				if c.cell {
+					upvalues[i] = f.cells[c.index]
+				} else {
+					upvalues[i] = f.closure.upvalues[c.index]
+				}
+			}
+			m.push(make_closure(child, upvalues))
+		case op_call:
+			m.remember(f)
+			m.combination = p.calls[f.ip-1]
+			m.call(arg, false)
+		case op_tail_call:
+			m.remember(f)
+			m.combination = p.calls[f.ip-1]
+			m.call(arg, true)
+		case op_return:
+			m.remember(f)
+			m.sp--
+			v := m.stack[m.sp]
+			if f.trace_returns {
+				m.in.trace_value(f.trace_depth, v)
+			}
+			m.leave()
+			m.deliver(v)
+		case op_where:
+			m.remember(f)
+		case op_signal:
+			m.signal(p.constants[arg])
+		default:
+			panic("invalid instruction " + instruction_opcode(i).String())
+		}
+	}
+	return true
+}
+
+// leave the position and the expression of the last expression
+// evaluated before the current instruction in the registers
+func (m *vm) remember(f *frame) {
+	p := f.closure.proto
+	if where := p.where[f.ip-1]; where != nil {
+		m.where = where
+	}
+	if exp := p.exps[f.ip-1]; exp != nil {
+		m.exp = exp
+	}
+}
+
+// the position and the expression of the last expression evaluated,
+// the ones of the registers while a primitive runs
+func (m *vm) last_expression() (*Position, *Value) {
+	where, exp := m.where, m.exp
+	if m.in_primitive || len(m.frames) == 0 {
+		return where, exp
+	}
+	f := &m.frames[len(m.frames)-1]
+	if f.resume != nil || f.ip == 0 {
+		return where, exp
+	}
+	p := f.closure.proto
+	if p.where[f.ip-1] != nil {
+		where = p.where[f.ip-1]
+	}
+	if p.exps[f.ip-1] != nil {
+		exp = p.exps[f.ip-1]
+	}
+	return where, exp
+}
+
+func (m *vm) push(v *Value) {
+	if m.sp == len(m.stack) {
+		m.reserve(m.sp + 1)
+	}
+	m.stack[m.sp] = v
+	m.sp++
+}
+
+// make room for n values on the stack
+func (m *vm) reserve(n int) {
+	if n <= len(m.stack) {
+		return
+	}
+	size := 2 * len(m.stack)
+	if size < n {
+		size = n + 64
+	}
+	stack := make([]*Value, size)
+	copy(stack, m.stack[:m.sp])
+	m.stack = stack
+}
+
+// call the procedure below the n values on top of the stack. In tail
+// position the frame of the caller is replaced, or left before a
+// procedure that is not a closure is applied
+func (m *vm) call(n int, tail bool) {
+	proc := m.stack[m.sp-n-1]
+	if is_closure(proc) {
+		m.enter(proc.val.(*closure), n, tail)
+		return
+	}
+
+	args := list(m.stack[m.sp-n : m.sp]...)
+	m.sp -= n + 1
+	if tail {
+		f := m.frames[len(m.frames)-1]
+		m.leave()
+		if f.traced {
+			// the value of the procedure is the value of the traced call
+			m.push_native(func(m *vm, v *Value) {
+				if f.trace_returns {
+					m.in.trace_value(f.trace_depth, v)
+				}
+				m.deliver(v)
+			})
+			native := &m.frames[len(m.frames)-1]
+			native.traced, native.trace_depth = true, f.trace_depth
+		}
+	}
+	m.apply_procedure(proc, args)
+}
+
+// push the frame of a call to c with the n arguments on top of the stack
+func (m *vm) enter(c *closure, n int, tail bool) {
+	p := c.proto
+	if !p.compiled() {
+		m.in.compile_lazily(p)
+	}
+
+	base := m.sp - n
+	traced, returns, depth := m.trace(c, list(m.stack[base:m.sp]...), tail)
+	m.in_call = true
+	if n < p.nparams {
+		raise_error(ArityError, "Too few arguments supplied", p.parameters, list(m.stack[base:m.sp]...))
+	}
+	if n > p.nparams && !p.rest {
+		raise_error(ArityError, "Too many arguments supplied", p.parameters, list(m.stack[base:m.sp]...))
+	}
+	m.in_call = false
+	if p.rest {
+		rest := list(m.stack[base+p.nparams : m.sp]...)
+		m.sp = base + p.nparams
+		m.push(rest)
+	}
+
+	if tail {
+		// the procedure and its arguments replace the frame of the caller
+		f := &m.frames[len(m.frames)-1]
+		moved := copy(m.stack[f.base-1:], m.stack[base-1:m.sp])
+		base = f.base
+		m.sp = f.base - 1 + moved
+		m.frames = m.frames[:len(m.frames)-1]
+	}
+
+	top := base + p.nslots
+	m.reserve(top)
+	for i := m.sp; i < top; i++ {
+		m.stack[i] = nil
+	}
+	m.sp = top
+
+	var cells []*cell
+	if p.ncells > 0 {
+		cells = make([]*cell, p.ncells)
+	}
+	m.frames = append(m.frames, frame{
+		closure:       c,
+		base:          base,
+		cells:         cells,
+		traced:        traced,
+		trace_depth:   depth,
+		trace_returns: returns,
+	})
+	m.in.stats.pushed(len(m.frames))
+}
+
+// trace the call to c. Like in the evaluator a tail call made by a
+// traced call is marked and prints at the same depth, and so does the
+// call whose value a traced call returns through a procedure that is
+// not a closure, like call/cc
+func (m *vm) trace(c *closure, args *Value, tail bool) (traced bool, returns bool, depth int) {
+	name := closure_procedure_name(c)
+	if len(m.frames) > 0 {
+		top := m.frames[len(m.frames)-1]
+		if top.traced && (tail || top.resume != nil) {
+			if m.in.traces(c, name) {
+				m.in.trace_call(top.trace_depth, name, args, true)
+			}
+			return true, tail && top.trace_returns, top.trace_depth
+		}
+	}
+	if !m.in.traces(c, name) {
+		return false, false, 0
+	}
+	for i := len(m.frames) - 1; i >= 0; i-- {
+		if m.frames[i].traced {
+			depth = m.frames[i].trace_depth + 1
+			break
+		}
+	}
+	m.in.trace_call(depth, name, args, false)
+	return true, true, depth
+}
+
+// pop the frame of the procedure running
+func (m *vm) leave() {
+	m.sp = m.frames[len(m.frames)-1].base - 1
+	m.frames = m.frames[:len(m.frames)-1]
+}
+
+// return v to the frame on top of the stack
+func (m *vm) deliver(v *Value) {
+	if len(m.frames) == 0 {
+		m.value = v
+		return
+	}
+	f := &m.frames[len(m.frames)-1]
+	if f.resume == nil {
+		m.push(v)
+		return
+	}
+	resume := f.resume
+	m.sp = f.base
+	m.frames = m.frames[:len(m.frames)-1]
+	resume(m, v)
+}
+
+// the value returned by the next procedure applied is passed to resume
+func (m *vm) push_native(resume func(m *vm, v *Value)) {
+	m.frames = append(m.frames, frame{base: m.sp, resume: resume})
+}
+
+// apply proc to the list args, the value goes to the frame on top
+func (m *vm) apply(proc *Value, args *Value) {
+	m.push(proc)
+	n := 0
+	for ; isPair(args); args = cdr(args) {
+		m.push(car(args))
+		n++
+	}
+	m.call(n, false)
+}
+
+func (m *vm) apply_procedure(proc *Value, args *Value) {
+	switch {
+	case test(is_primitive_procedure(proc)):
+		m.in_primitive = true
+		v := apply_primitive_procedure(proc, args)
+		m.in_primitive = false
+		m.deliver(v)
+	case test(is_continuation(proc)):
+		m.throw(proc, args)
+	case test(is_machine_procedure(proc)):
+		m.machine_procedure(proc, args)
+	case test(is_macro(proc)):
+		raise_error(SyntaxError, "the macro was not defined when its use was compiled")
+	default:
+		m.in_call = true
+		raise_error(UnknownProcError, "Unknown procedure type", proc)
+	}
+}
+
+// the arguments of a machine procedure are checked like the ones
+// of a primitive
+func (m *vm) check_arguments(name string, args *Value, n int) {
+	m.in_primitive = true
+	if got := listLen(args); got != n {
+		raise_error(ArityError, name+": wrong number of arguments", make_integer(int64(got)))
+	}
+	m.in_primitive = false
+}
+
+// the procedures the evaluator implements with labels
+func (m *vm) machine_procedure(proc *Value, args *Value) {
+	switch name := machine_procedure_name(proc).val.(string); name {
+	case "call/cc", "call-with-current-continuation":
+		m.check_arguments("call/cc", args, 1)
+		m.apply(car(args), list(m.capture()))
+	case "dynamic-wind":
+		m.check_arguments("dynamic-wind", args, 3)
+		m.dynamic_wind(args)
+	case "raise":
+		m.check_arguments("raise", args, 1)
+		m.signal(car(args))
+	case "raise-continuable":
+		m.check_arguments("raise-continuable", args, 1)
+		m.raise_continuable(car(args))
+	case "with-exception-handler":
+		m.check_arguments("with-exception-handler", args, 2)
+		handlers := m.handlers
+		m.handlers = install_handler(car(args), m.handlers)
+		m.push_native(func(m *vm, v *Value) {
+			m.handlers = handlers
+			m.deliver(v)
+		})
+		m.apply(cadr(args), nullValue)
+	case "macroexpand-1":
+		m.check_arguments("macroexpand-1", args, 1)
+		exp := car(args)
+		macro := macro_of(exp, m.in.global)
+		if macro == nil {
+			m.deliver(exp)
+			return
+		}
+		m.in.use_binding = nil
+		m.apply(macro_transformer(macro), operands(exp))
+	case "macroexpand":
+		m.check_arguments("macroexpand", args, 1)
+		m.macroexpand(car(args))
+	default:
+		raise_error(UnknownProcError, "Unknown procedure type", proc)
+	}
+}
+
+func (m *vm) capture() *Value {
+	return &Value{
+		kind: Continuation,
+		val: &vmContinuation{
+			stack:    append([]*Value(nil), m.stack[:m.sp]...),
+			frames:   copy_frames(m.frames),
+			winders:  m.winders,
+			handlers: m.handlers,
+		},
+	}
+}
+
+// the frames share the cells, but a new cell made in one of them
+// must not replace the cell of the other
+func copy_frames(frames []frame) []frame {
+	c := make([]frame, len(frames))
+	copy(c, frames)
+	for i := range c {
+		if c[i].cells != nil {
+			c[i].cells = append([]*cell(nil), c[i].cells...)
+		}
+	}
+	return c
+}
+
+// the after thunks of the extents being left and the before thunks of
+// the extents being entered run first, then the stack is replaced by
+// the one of the continuation, which receives the argument
+func (m *vm) throw(k *Value, args *Value) {
+	m.check_arguments("continuation", args, 1)
+	v := car(args)
+	c := k.val.(*vmContinuation)
+	m.wind_to(c.winders, func(m *vm) {
+		m.stack = append([]*Value(nil), c.stack...)
+		m.sp = len(c.stack)
+		m.frames = copy_frames(c.frames)
+		m.handlers = c.handlers
+		m.deliver(v)
+	})
+}
+
+// move from the current extent to target and call then
+func (m *vm) wind_to(target *Value, then func(m *vm)) {
+	if test(is_inner_extent(m.winders, target)) {
+		m.rewind(target, then)
+		return
+	}
+	after := extent_after(car(m.winders))
+	m.winders = cdr(m.winders)
+	m.push_native(func(m *vm, _ *Value) {
+		m.wind_to(target, then)
+	})
+	m.apply(after, nullValue)
+}
+
+func (m *vm) rewind(target *Value, then func(m *vm)) {
+	if m.winders == target {
+		then(m)
+		return
+	}
+	next := next_extent(m.winders, target)
+	m.push_native(func(m *vm, _ *Value) {
+		m.winders = next
+		m.rewind(target, then)
+	})
+	m.apply(extent_before(car(next)), nullValue)
+}
+
+// (dynamic-wind before thunk after)
+func (m *vm) dynamic_wind(args *Value) {
+	m.push_native(func(m *vm, _ *Value) {
+		m.winders = cons(make_extent(args), m.winders)
+		m.push_native(func(m *vm, v *Value) {
+			m.winders = cdr(m.winders)
+			m.push_native(func(m *vm, _ *Value) {
+				m.deliver(v)
+			})
+			m.apply(wind_after(args), nullValue)
+		})
+		m.apply(wind_thunk(args), nullValue)
+	})
+	m.apply(wind_before(args), nullValue)
+}
+
+// raise obj, the current handler is called with the outer handlers
+// installed and a secondary error is raised if it returns
+func (m *vm) signal(obj *Value) {
+	if test(has_no_handlers(m.handlers)) {
+		m.uncaught(obj)
+		return
+	}
+	handler := current_handler(m.handlers)
+	m.handlers = outer_handlers(m.handlers)
+	m.push_native(func(m *vm, _ *Value) {
+		m.signal(make_error(&SchemeError{
+			Kind:      NonContinuableError,
+			Message:   "exception handler returned",
+			Irritants: list(obj),
+			Pos:       m.where,
+		}))
+	})
+	m.apply(handler, list(obj))
+}
+
+func (m *vm) raise_continuable(obj *Value) {
+	if test(has_no_handlers(m.handlers)) {
+		m.uncaught(obj)
+		return
+	}
+	handlers := m.handlers
+	m.handlers = outer_handlers(m.handlers)
+	m.push_native(func(m *vm, v *Value) {
+		m.handlers = handlers
+		m.deliver(v)
+	})
+	m.apply(current_handler(handlers), list(obj))
+}
+
+// an exception without a handler stops the machine once the after
+// thunks of the active dynamic-wind calls ran
+func (m *vm) uncaught(obj *Value) {
+	m.wind_to(the_empty_extent, func(m *vm) {
+		m.err = uncaught_error(obj, m.where)
+		m.frames = m.frames[:0]
+		m.sp = 0
+	})
+}
+
+func (m *vm) macroexpand(exp *Value) {
+	macro := macro_of(exp, m.in.global)
+	if macro == nil {
+		m.deliver(exp)
+		return
+	}
+	m.push_native(func(m *vm, v *Value) {
+		m.macroexpand(v)
+	})
+	m.in.use_binding = nil
+	m.apply(macro_transformer(macro), operands(exp))
+}